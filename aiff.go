@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+)
+
+// AIFF and AIFF-C : the IFF chunks are big endian, the sample rate is an 80 bits extended float.
+// Reading : PCM 8 to 32 bits (NONE or twos, and sowt which is little endian) and float 32/64 bits
+// (fl32, fl64). Writing : 16 bits PCM in AIFF files, 32 bits float in AIFF-C files.
+// AIFF has no sizes larger than 32 bits, the files are limited to 4 GB.
+
+// aifcVersion is the timestamp of the AIFF-C version 1 specification, in the FVER chunk.
+const aifcVersion = 0xA2805140
+
+// walkAIFF returns the form type (AIFF or AIFC) and the chunks of an AIFF file. Like walkRIFF,
+// the walk goes to the end of the file and a chunk longer than the file is cut at its end.
+func walkAIFF(r io.ReadSeeker) (string, []riffChunk, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading file size: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", nil, fmt.Errorf("error reading FORM header: %w", err)
+	}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, fmt.Errorf("error reading FORM header: %w", err)
+	}
+	form := string(header[8:12])
+	if string(header[0:4]) != "FORM" || (form != "AIFF" && form != "AIFC") {
+		return "", nil, fmt.Errorf("not an AIFF/AIFF-C file")
+	}
+
+	var chunks []riffChunk
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	for offset+8 <= fileSize {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return "", nil, fmt.Errorf("error seeking chunk: %w", err)
+		}
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return "", nil, fmt.Errorf("error reading chunk header: %w", err)
+		}
+		chunk := riffChunk{
+			ID:     string(chunkHeader[0:4]),
+			Size:   int64(binary.BigEndian.Uint32(chunkHeader[4:8])),
+			Offset: offset + 8,
+		}
+		if chunk.Offset+chunk.Size > fileSize {
+			log.Warn("Truncated chunk", "chunk", chunk.ID, "size", chunk.Size, "available", fileSize-chunk.Offset)
+			chunk.Size = fileSize - chunk.Offset
+		}
+		chunks = append(chunks, chunk)
+		offset = chunk.Offset + chunk.Size + chunk.Size%2
+	}
+	return form, chunks, nil
+}
+
+// parseExtended converts an 80 bits IEEE 754 extended float (the sample rate of the COMM chunk).
+func parseExtended(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]) & 0x7FFF)
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	v := math.Ldexp(float64(mantissa), exponent-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// extended converts a positive integer to an 80 bits extended float.
+func extended(n int) []byte {
+	b := make([]byte, 10)
+	if n <= 0 {
+		return b
+	}
+	length := bits.Len64(uint64(n))
+	binary.BigEndian.PutUint16(b[0:2], uint16(16383+length-1))
+	binary.BigEndian.PutUint64(b[2:10], uint64(n)<<(64-length))
+	return b
+}
+
+// parseCOMMChunk reads the COMM chunk : channels, frames, bits, sample rate and, for AIFF-C,
+// the compression type.
+func parseCOMMChunk(data []byte, form string) (WaveInfo, error) {
+	info := WaveInfo{Container: form, Compression: "NONE"}
+	if len(data) < 18 || (form == "AIFC" && len(data) < 22) {
+		return info, fmt.Errorf("COMM chunk too short: %d bytes", len(data))
+	}
+	info.Channels = int(binary.BigEndian.Uint16(data[0:2]))
+	info.Frames = int64(binary.BigEndian.Uint32(data[2:6]))
+	info.BitsPerSample = int(binary.BigEndian.Uint16(data[6:8]))
+	rate := parseExtended(data[8:18])
+	if form == "AIFC" {
+		info.Compression = string(data[18:22])
+	}
+	info.ValidBits = info.BitsPerSample
+
+	if info.Channels == 0 {
+		return info, fmt.Errorf("no channels in COMM chunk")
+	}
+	if rate < 1 || rate > 1e7 {
+		return info, fmt.Errorf("invalid sample rate %g in COMM chunk", rate)
+	}
+	info.SampleRate = int(math.Round(rate))
+
+	switch info.Compression {
+	case "NONE", "twos", "sowt":
+		if info.BitsPerSample < 1 || info.BitsPerSample > 32 {
+			return info, fmt.Errorf("unsupported AIFF sample size: %d bits", info.BitsPerSample)
+		}
+		info.FormatCode = waveFormatPCM
+	case "fl32", "FL32":
+		info.FormatCode, info.BitsPerSample = waveFormatFloat, 32
+	case "fl64", "FL64":
+		info.FormatCode, info.BitsPerSample = waveFormatFloat, 64
+	default:
+		return info, fmt.Errorf("unsupported AIFF-C compression type %q", info.Compression)
+	}
+	info.SubFormat = info.FormatCode
+	info.ValidBits = info.BitsPerSample
+	info.BigEndian = info.Compression != "sowt"
+	return info, nil
+}
+
+// readAIFFHeader walks the chunks of an AIFF file and returns its format and the offset of
+// the first sample in the SSND chunk.
+func readAIFFHeader(r io.ReadSeeker) (WaveInfo, int64, error) {
+	form, chunks, err := walkAIFF(r)
+	if err != nil {
+		return WaveInfo{}, 0, err
+	}
+	commChunk, ok := findChunk(chunks, "COMM")
+	if !ok {
+		return WaveInfo{}, 0, fmt.Errorf("no COMM chunk found")
+	}
+	ssndChunk, ok := findChunk(chunks, "SSND")
+	if !ok {
+		return WaveInfo{}, 0, fmt.Errorf("no SSND chunk found")
+	}
+	data, err := readChunk(r, commChunk)
+	if err != nil {
+		return WaveInfo{}, 0, err
+	}
+	info, err := parseCOMMChunk(data, form)
+	if err != nil {
+		return info, 0, err
+	}
+
+	// SSND starts with the offset of the first sample and a block size, usually both 0
+	ssnd := make([]byte, 8)
+	if _, err := r.Seek(ssndChunk.Offset, io.SeekStart); err != nil {
+		return info, 0, fmt.Errorf("error seeking SSND chunk: %w", err)
+	}
+	if _, err := io.ReadFull(r, ssnd); err != nil {
+		return info, 0, fmt.Errorf("error reading SSND chunk: %w", err)
+	}
+	start := 8 + int64(binary.BigEndian.Uint32(ssnd[0:4]))
+	available := max(0, ssndChunk.Size-start) / int64(info.Channels*info.bytesPerSample())
+	if available < info.Frames {
+		log.Warn("SSND chunk shorter than the COMM frame count", "frames", info.Frames, "available", available)
+		info.Frames = available
+	}
+	return info, ssndChunk.Offset + start, nil
+}
+
+// decodeAIFF reads all the channels of an AIFF or AIFF-C file, as float64 between -1 and 1.
+func decodeAIFF(r io.ReadSeeker) (WaveInfo, [][]float64, error) {
+	info, offset, err := readAIFFHeader(r)
+	if err != nil {
+		return info, nil, err
+	}
+	channels, err := decodeFrames(r, info, offset)
+	return info, channels, err
+}
+
+// writeAIFF writes channels as an AIFF file with 16 bits PCM samples, or as an AIFF-C file with
+// 32 bits float samples (fl32) when opts.Format is aifc, in the order of the slice. The text,
+// MARK and ID3 chunks of opts.Metadata follow the SSND chunk.
+func writeAIFF(s string, sampleRate int, names []string, channels [][]float64, opts OutputOptions) error {
+	frames := len(channels[0])
+	for _, channel := range channels {
+		if len(channel) != frames {
+			return fmt.Errorf("all channels must be the same length")
+		}
+	}
+	bitsPerSample := 16
+	if opts.Format == "aifc" {
+		bitsPerSample = 32
+	}
+	dataSize := int64(frames) * int64(len(channels)*bitsPerSample/8)
+
+	var comm bytes.Buffer
+	binary.Write(&comm, binary.BigEndian, uint16(len(channels)))
+	binary.Write(&comm, binary.BigEndian, uint32(frames))
+	binary.Write(&comm, binary.BigEndian, uint16(bitsPerSample))
+	comm.Write(extended(sampleRate))
+
+	var header bytes.Buffer
+	header.WriteString("FORM")
+	header.Write(make([]byte, 4)) // set below
+	if opts.Format == "aifc" {
+		// compression type and its name as a pascal string, padded to an even size
+		name := "32-bit floating point"
+		comm.WriteString("fl32")
+		comm.WriteByte(byte(len(name)))
+		comm.WriteString(name)
+		if len(name)%2 == 0 {
+			comm.WriteByte(0)
+		}
+		header.WriteString("AIFCFVER")
+		binary.Write(&header, binary.BigEndian, uint32(4))
+		binary.Write(&header, binary.BigEndian, uint32(aifcVersion))
+	} else {
+		header.WriteString("AIFF")
+	}
+	header.WriteString("COMM")
+	binary.Write(&header, binary.BigEndian, uint32(comm.Len()))
+	header.Write(comm.Bytes())
+	header.WriteString("SSND")
+	binary.Write(&header, binary.BigEndian, uint32(8+dataSize))
+	header.Write(make([]byte, 8)) // offset and block size
+
+	trailer := opts.Metadata.aiffChunks()
+	formSize := int64(header.Len()) - 8 + dataSize + int64(len(trailer))
+	if formSize > math.MaxUint32 {
+		return fmt.Errorf("AIFF files are limited to 4 GB: %d bytes of audio data, use wav or flac", dataSize)
+	}
+	binary.BigEndian.PutUint32(header.Bytes()[4:8], uint32(formSize))
+
+	outFile, err := os.Create(s)
+	if err != nil {
+		return fmt.Errorf("error creating AIFF file: %w", err)
+	}
+	defer outFile.Close()
+	w := bufio.NewWriterSize(outFile, 1<<16)
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return fmt.Errorf("error writing AIFF header: %w", err)
+	}
+
+	q := newQuantizer(len(channels), 16, opts.Dither)
+	sample := make([]byte, bitsPerSample/8)
+	for i := 0; i < frames; i++ {
+		for c, channel := range channels {
+			if bitsPerSample == 32 {
+				binary.BigEndian.PutUint32(sample, math.Float32bits(float32(channel[i])))
+			} else {
+				binary.BigEndian.PutUint16(sample, uint16(q.quantize(c, channel[i])))
+			}
+			if _, err := w.Write(sample); err != nil {
+				return fmt.Errorf("error writing audio data: %w", err)
+			}
+		}
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return fmt.Errorf("error writing AIFF metadata: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing audio data: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("error closing AIFF file: %w", err)
+	}
+	q.report(s, names)
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// aiffFile builds an AIFF file (form AIFF or AIFC) from chunk ids and contents.
+func aiffFile(form string, chunks ...[2]string) []byte {
+	var body bytes.Buffer
+	body.WriteString(form)
+	for _, chunk := range chunks {
+		body.WriteString(chunk[0])
+		binary.Write(&body, binary.BigEndian, uint32(len(chunk[1])))
+		body.WriteString(chunk[1])
+		if len(chunk[1])%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("FORM")
+	binary.Write(&b, binary.BigEndian, uint32(body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// commChunk returns the content of a COMM chunk, with a compression type when it is not empty.
+func commChunk(channels int, frames int, bits int, sampleRate int, compression string) string {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint16(channels))
+	binary.Write(&b, binary.BigEndian, uint32(frames))
+	binary.Write(&b, binary.BigEndian, uint16(bits))
+	b.Write(extended(sampleRate))
+	if compression != "" {
+		b.WriteString(compression + "\x00\x00") // empty pascal string, padded
+	}
+	return b.String()
+}
+
+// bigEndian returns the big endian bytes of values.
+func bigEndian(values ...any) string {
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	return b.String()
+}
+
+func TestExtended(t *testing.T) {
+	for _, tt := range []struct {
+		rate int
+		want string
+	}{
+		{44100, "\x40\x0e\xac\x44\x00\x00\x00\x00\x00\x00"},
+		{48000, "\x40\x0e\xbb\x80\x00\x00\x00\x00\x00\x00"},
+		{8000, "\x40\x0b\xfa\x00\x00\x00\x00\x00\x00\x00"},
+	} {
+		if got := extended(tt.rate); string(got) != tt.want {
+			t.Errorf("extended(%d) = % x, want % x", tt.rate, got, tt.want)
+		}
+		if got := parseExtended([]byte(tt.want)); got != float64(tt.rate) {
+			t.Errorf("parseExtended(% x) = %v, want %d", tt.want, got, tt.rate)
+		}
+	}
+}
+
+func TestDecodeAIFFLayouts(t *testing.T) {
+	// two frames of the stereo values (0.5, -0.25) then (-1, 1) in each format
+	const half, quarter = 0.5, 0.25
+	pcm16 := bigEndian(int16(16384), int16(-8192), int16(-32767), int16(32767))
+	pcm24 := "\x40\x00\x00" + "\xe0\x00\x00" + "\x80\x00\x01" + "\x7f\xff\xff"
+	ssnd := func(data string) [2]string { return [2]string{"SSND", bigEndian(uint32(0), uint32(0)) + data} }
+
+	tests := []struct {
+		name     string
+		file     []byte
+		info     WaveInfo
+		accuracy float64
+	}{
+		{"pcm16", aiffFile("AIFF", [2]string{"COMM", commChunk(2, 2, 16, 44100, "")}, ssnd(pcm16)),
+			WaveInfo{Container: "AIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Compression: "NONE", BigEndian: true, Frames: 2}, 1e-4},
+		{"chunks and offset", aiffFile("AIFF",
+			[2]string{"NAME", "odd"},
+			[2]string{"SSND", bigEndian(uint32(3), uint32(0)) + "pad" + pcm16},
+			[2]string{"COMM", commChunk(2, 2, 16, 48000, "")},
+			[2]string{"ANNO", "test"}),
+			WaveInfo{Container: "AIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 48000, BitsPerSample: 16, ValidBits: 16, Compression: "NONE", BigEndian: true, Frames: 2}, 1e-4},
+		{"pcm8 signed", aiffFile("AIFF", [2]string{"COMM", commChunk(2, 2, 8, 22050, "")}, ssnd("\x40\xe0\x81\x7f")),
+			WaveInfo{Container: "AIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 22050, BitsPerSample: 8, ValidBits: 8, Compression: "NONE", BigEndian: true, Frames: 2}, 1e-2},
+		{"pcm24", aiffFile("AIFF", [2]string{"COMM", commChunk(2, 2, 24, 96000, "")}, ssnd(pcm24)),
+			WaveInfo{Container: "AIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 96000, BitsPerSample: 24, ValidBits: 24, Compression: "NONE", BigEndian: true, Frames: 2}, 1e-6},
+		{"aifc twos", aiffFile("AIFC", [2]string{"FVER", bigEndian(uint32(aifcVersion))}, [2]string{"COMM", commChunk(2, 2, 16, 44100, "twos")}, ssnd(pcm16)),
+			WaveInfo{Container: "AIFC", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Compression: "twos", BigEndian: true, Frames: 2}, 1e-4},
+		{"aifc sowt", aiffFile("AIFC", [2]string{"COMM", commChunk(2, 2, 16, 44100, "sowt")}, ssnd(samples(int16(16384), int16(-8192), int16(-32767), int16(32767)))),
+			WaveInfo{Container: "AIFC", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Compression: "sowt", Frames: 2}, 1e-4},
+		{"aifc fl32", aiffFile("AIFC", [2]string{"COMM", commChunk(2, 2, 32, 44100, "fl32")}, ssnd(bigEndian(float32(half), float32(-quarter), float32(-1), float32(1)))),
+			WaveInfo{Container: "AIFC", FormatCode: 3, SubFormat: 3, Channels: 2, SampleRate: 44100, BitsPerSample: 32, ValidBits: 32, Compression: "fl32", BigEndian: true, Frames: 2}, 0},
+		{"aifc fl64", aiffFile("AIFC", [2]string{"COMM", commChunk(2, 2, 64, 44100, "fl64")}, ssnd(bigEndian(half, -quarter, -1.0, 1.0))),
+			WaveInfo{Container: "AIFC", FormatCode: 3, SubFormat: 3, Channels: 2, SampleRate: 44100, BitsPerSample: 64, ValidBits: 64, Compression: "fl64", BigEndian: true, Frames: 2}, 0},
+		{"truncated SSND", aiffFile("AIFF", [2]string{"COMM", commChunk(2, 5, 16, 44100, "")}, ssnd(pcm16+"\x01\x02")),
+			WaveInfo{Container: "AIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Compression: "NONE", BigEndian: true, Frames: 2}, 1e-4},
+	}
+
+	want := [][]float64{{half, -1}, {-quarter, 1}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, channels, err := decodeAIFF(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != tt.info {
+				t.Errorf("got %+v, want %+v", info, tt.info)
+			}
+			for c := range want {
+				for i := range want[c] {
+					if d := channels[c][i] - want[c][i]; d > tt.accuracy || d < -tt.accuracy {
+						t.Errorf("channel %d frame %d = %v, want %v", c, i, channels[c][i], want[c][i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeAIFFErrors(t *testing.T) {
+	ssnd := [2]string{"SSND", bigEndian(uint32(0), uint32(0), int16(1), int16(2))}
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"not a FORM", riffFile([2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)})},
+		{"no COMM", aiffFile("AIFF", ssnd)},
+		{"no SSND", aiffFile("AIFF", [2]string{"COMM", commChunk(2, 1, 16, 44100, "")})},
+		{"no channels", aiffFile("AIFF", [2]string{"COMM", commChunk(0, 1, 16, 44100, "")}, ssnd)},
+		{"sample rate 0", aiffFile("AIFF", [2]string{"COMM", commChunk(2, 1, 16, 0, "")}, ssnd)},
+		{"compressed", aiffFile("AIFC", [2]string{"COMM", commChunk(2, 1, 16, 44100, "ulaw")}, ssnd)},
+		{"short COMM", aiffFile("AIFC", [2]string{"COMM", commChunk(2, 1, 16, 44100, "")}, ssnd)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeAIFF(bytes.NewReader(tt.file)); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+// TestAIFFWriterChannelOrder writes 4.0 and 5.1 files and checks the header and that each
+// channel is read back at its place : LF, RF, LB, RB (the AIFF quadraphonic order) and
+// L, R, C, LFE, Ls, Rs (the wave order, AIFF has no 5.1 order).
+func TestAIFFWriterChannelOrder(t *testing.T) {
+	const frames, sampleRate = 10, 48000
+	dir := t.TempDir()
+	for _, format := range []string{"aiff", "aifc"} {
+		for _, count := range []int{2, 4, 6} {
+			in := constantChannels(count, frames)
+			path := filepath.Join(dir, format+string(rune('0'+count))+"."+format)
+			if err := writeAIFF(path, sampleRate, nil, in, OutputOptions{Format: format}); err != nil {
+				t.Fatal(err)
+			}
+			info, out, err := readAudio(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantInfo := WaveInfo{Container: "AIFF", FormatCode: 1, SubFormat: 1, Channels: count, SampleRate: sampleRate, BitsPerSample: 16, ValidBits: 16, Compression: "NONE", BigEndian: true, Frames: frames}
+			if format == "aifc" {
+				wantInfo.Container, wantInfo.FormatCode, wantInfo.SubFormat, wantInfo.BitsPerSample, wantInfo.ValidBits, wantInfo.Compression = "AIFC", 3, 3, 32, 32, "fl32"
+			}
+			if info != wantInfo {
+				t.Errorf("%s: got %+v, want %+v", path, info, wantInfo)
+			}
+			for c := range in {
+				want := float64(float32(in[c][0]))
+				if format == "aiff" {
+					want = float64(pcm16(in[c][0])) / 32767
+				}
+				for i := range out[c] {
+					if out[c][i] != want {
+						t.Fatalf("%s: channel %d frame %d = %v, want %v", path, c, i, out[c][i], want)
+					}
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := binary.BigEndian.Uint32(data[4:8]); int(size) != len(data)-8 {
+				t.Errorf("%s: FORM size %d, file %d bytes", path, size, len(data))
+			}
+		}
+	}
+}
+
+func FuzzDecodeAIFF(f *testing.F) {
+	f.Add(aiffFile("AIFF", [2]string{"COMM", commChunk(2, 1, 24, 96000, "")}, [2]string{"SSND", bigEndian(uint32(0), uint32(0)) + "\x40\x00\x00\xe0\x00\x00"}))
+	f.Add(aiffFile("AIFC", [2]string{"NAME", "odd"}, [2]string{"COMM", commChunk(1, 1, 64, 44100, "fl64")}, [2]string{"SSND", bigEndian(uint32(0), uint32(0), 0.5)}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, channels, err := decodeAIFF(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(channels) != info.Channels {
+			t.Fatalf("%d channels decoded, format says %d", len(channels), info.Channels)
+		}
+		for _, channel := range channels {
+			if int64(len(channel)) != info.Frames {
+				t.Fatalf("%d frames decoded, format says %d", len(channel), info.Frames)
+			}
+		}
+		if int64(len(data)) < info.Frames*int64(info.Channels*info.bytesPerSample()) {
+			t.Fatalf("%d frames in %d bytes", info.Frames, len(data))
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+)
+
+// AnalogParameters model the phase-shift networks of an analog decoder.
+//
+// The digital decoders multiply by j, an exact 90° phase shift at every frequency. Analog SQ and QS
+// decoders cannot do that : every signal goes through one of two cascades of first-order all-pass
+// sections, and it is the phase difference between the reference cascade and the quadrature cascade
+// which is close to 90°, only within the audio band. In analog mode the real part of each matrix
+// coefficient goes through the reference cascade and the imaginary part through the quadrature cascade.
+type AnalogParameters struct {
+	Enabled bool `json:"enabled"`
+	// Network is a named network of analogNetworks, which replaces the poles. Empty : the poles
+	// as given.
+	Network string `json:"network,omitempty"`
+	// ReferencePolesHz are the pole frequencies of the reference all-pass cascade.
+	ReferencePolesHz []float64 `json:"reference_poles_hz"`
+	// QuadraturePolesHz are the pole frequencies of the cascade which leads the reference by about 90°.
+	QuadraturePolesHz []float64 `json:"quadrature_poles_hz"`
+}
+
+// defaultAnalog is a 3+3 sections network, not a period design : the 948 Hz section is the R1/C1
+// stage of sqdecoder1.asc (3.57K, 0.047µ), the other poles were fitted around it so that the phase
+// difference stays within ±16° of 90° from 50 Hz to 12 kHz.
+var defaultAnalog = AnalogParameters{
+	ReferencePolesHz:  []float64{20.6, 948.5, 11110},
+	QuadraturePolesHz: []float64{154.5, 4032, 70262},
+}
+
+// wienPoles returns the poles in Hz of a Wien bridge all-pass network : R1 and C1 in series, R2
+// and C2 in parallel. The all-pass has the denominator of the Wien network,
+// R1C1R2C2 s² + (R1C1 + R2C2 + R2C1) s + 1, whose two roots are real : the network is two
+// first-order sections.
+func wienPoles(r1, c1, r2, c2 float64) []float64 {
+	a := r1 * c1 * r2 * c2
+	b := r1*c1 + r2*c2 + r2*c1
+	d := math.Sqrt(b*b - 4*a)
+	return []float64{(b - d) / (2 * a) / (2 * math.Pi), (b + d) / (2 * a) / (2 * math.Pi)}
+}
+
+// analogNetworks are the named networks of -analog-network :
+//   - fitted : defaultAnalog
+//   - mc1312 : the application circuit of the Motorola MC1312P SQ decoder (images/motorola1312P.png),
+//     two Wien bridge networks per input, 3.9K/0.1µ with 4.7K/0.018µ for the reference and
+//     3.9K/0.018µ with 4.7K/0.0033µ for the quadrature : poles at 175 Hz and 4.38 kHz, 972 Hz and
+//     23.9 kHz, within ±9° of 90° from 250 Hz to 10 kHz only
+//
+// The CBS network is left for later : the component values of the CBS decoders are not in this
+// repository, and -analog-network cbs says so (see unavailableNetworks).
+var analogNetworks = map[string]AnalogParameters{
+	"fitted": defaultAnalog,
+	"mc1312": {
+		ReferencePolesHz:  wienPoles(3.9e3, 0.1e-6, 4.7e3, 0.018e-6),
+		QuadraturePolesHz: wienPoles(3.9e3, 0.018e-6, 4.7e3, 0.0033e-6),
+	},
+}
+
+// unavailableNetworks are the networks asked for but not modelled yet, with the reason.
+var unavailableNetworks = map[string]string{
+	"cbs": "the CBS network is not modelled yet, its component values are not documented in this repository : use mc1312 or explicit poles",
+}
+
+// analogNetworkNames returns the sorted names of analogNetworks, for help and error messages.
+func analogNetworkNames() string {
+	names := make([]string, 0, len(analogNetworks))
+	for name := range analogNetworks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// withNetwork returns the parameters with the poles of the named network, if any.
+func (analog AnalogParameters) withNetwork() (AnalogParameters, error) {
+	if analog.Network == "" {
+		return analog, nil
+	}
+	if reason, ok := unavailableNetworks[strings.ToLower(analog.Network)]; ok {
+		return analog, fmt.Errorf("analog network %q: %s", analog.Network, reason)
+	}
+	network, ok := analogNetworks[strings.ToLower(analog.Network)]
+	if !ok {
+		return analog, fmt.Errorf("analog network must be one of %s, not %q", analogNetworkNames(), analog.Network)
+	}
+	analog.Network = strings.ToLower(analog.Network)
+	analog.ReferencePolesHz = append([]float64{}, network.ReferencePolesHz...)
+	analog.QuadraturePolesHz = append([]float64{}, network.QuadraturePolesHz...)
+	return analog, nil
+}
+
+// allPass returns the response of a cascade of first-order all-pass sections at frequency f :
+// H(f) = Π (1 - j*f/p) / (1 + j*f/p). Its gain is 1 and its phase is -2*atan(f/p) per section.
+func allPass(polesHz []float64, f float64) complex128 {
+	h := complex(1, 0)
+	for _, p := range polesHz {
+		h *= complex(1, -f/p) / complex(1, f/p)
+	}
+	return h
+}
+
+// responses returns the reference and quadrature cascade responses at frequency f.
+func (analog AnalogParameters) responses(f float64) (complex128, complex128) {
+	return allPass(analog.ReferencePolesHz, f), allPass(analog.QuadraturePolesHz, f)
+}
+
+// apply returns the response of the analog decoder for a matrix coefficient :
+// real(c) through the reference cascade, imag(c) through the quadrature cascade.
+// With an ideal network (quadrature = j*reference) it is c*reference.
+func (analog AnalogParameters) apply(c complex128, reference complex128, quadrature complex128) complex128 {
+	return complex(real(c), 0)*reference + complex(imag(c), 0)*quadrature
+}
+
+// maxPhaseError returns the largest deviation from 90°, in degrees, of the phase difference
+// between the quadrature and reference cascades between minFreq and maxFreq.
+func (analog AnalogParameters) maxPhaseError(minFreq float64, maxFreq float64) float64 {
+	const steps = 200
+	var maxError float64
+	for i := 0; i < steps; i++ {
+		f := minFreq * math.Pow(maxFreq/minFreq, float64(i)/(steps-1))
+		reference, quadrature := analog.responses(f)
+		difference := cmplx.Phase(quadrature/reference) * 180 / math.Pi
+		maxError = math.Max(maxError, math.Abs(difference-90))
+	}
+	return maxError
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAnalogNetworks(t *testing.T) {
+	// poles of the MC1312P application circuit, from its Wien bridge networks
+	mc1312, err := AnalogParameters{Network: "MC1312"}.withNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{175.2, 4381}, {972.4, 23923}}
+	for i, poles := range [][]float64{mc1312.ReferencePolesHz, mc1312.QuadraturePolesHz} {
+		for j, p := range poles {
+			if math.Abs(p/want[i][j]-1) > 0.002 {
+				t.Errorf("pole %d of cascade %d : %.1f Hz, want %.1f", j, i, p, want[i][j])
+			}
+		}
+	}
+
+	tests := []struct {
+		network          string
+		minFreq, maxFreq float64
+		maxError         float64
+	}{
+		{"fitted", 50, 12000, 16},
+		{"mc1312", 250, 10000, 9},
+	}
+	for _, tt := range tests {
+		analog, err := AnalogParameters{Network: tt.network}.withNetwork()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := analog.maxPhaseError(tt.minFreq, tt.maxFreq); got > tt.maxError {
+			t.Errorf("%s : %.1f° from 90° between %g and %g Hz, want %g at most", tt.network, got, tt.minFreq, tt.maxFreq, tt.maxError)
+		}
+	}
+
+	for _, name := range []string{"cbs", "sansui"} {
+		if _, err := (AnalogParameters{Network: name}).withNetwork(); err == nil {
+			t.Errorf("network %s accepted", name)
+		}
+	}
+}
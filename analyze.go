@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// levelDB returns the peak and RMS levels of a channel in dBFS.
+func levelDB(data []float64) (float64, float64) {
+	var sum float64
+	for _, v := range data {
+		sum += v * v
+	}
+	rms := 0.0
+	if len(data) > 0 {
+		rms = math.Sqrt(sum / float64(len(data)))
+	}
+	return 20 * math.Log10(maxAbs(data)), 20 * math.Log10(rms)
+}
+
+// correlation returns the Pearson correlation of two channels (1 = mono, 0 = unrelated, -1 = out of phase).
+func correlation(left, right []float64) float64 {
+	var sumLR, sumLL, sumRR float64
+	for i := range left {
+		sumLR += left[i] * right[i]
+		sumLL += left[i] * left[i]
+		sumRR += right[i] * right[i]
+	}
+	if sumLL == 0 || sumRR == 0 {
+		return 0
+	}
+	return sumLR / math.Sqrt(sumLL*sumRR)
+}
+
+// printAnalysis writes the levels and correlation of LT/RT and the levels of the decoded quad channels.
+// The decoded levels are relative to the loudest decoded channel, since the decoders normalize their output.
+// With crosscheck the FFT decode is compared to the FIR Hilbert decode.
+func printAnalysis(w io.Writer, input string, cfg Config, LT, RT []float64, sampleRate int, crosscheck bool) {
+	fmt.Fprintf(w, "file: %s\n", input)
+
+	peakLT, rmsLT := levelDB(LT)
+	peakRT, rmsRT := levelDB(RT)
+	fmt.Fprintf(w, "LT    peak %7.2f dBFS   rms %7.2f dBFS\n", peakLT, rmsLT)
+	fmt.Fprintf(w, "RT    peak %7.2f dBFS   rms %7.2f dBFS\n", peakRT, rmsRT)
+	fmt.Fprintf(w, "LT/RT correlation %.3f\n", correlation(LT, RT))
+	maxDelayMS := cfg.Align.MaxDelayMS
+	if maxDelayMS <= 0 {
+		maxDelayMS = 0.1
+	}
+	d := estimateDelay(LT, RT, int(math.Ceil(maxDelayMS*float64(sampleRate)/1000)))
+	edge := ""
+	if d.Edge {
+		edge = ", at the end of the search range"
+	}
+	fmt.Fprintf(w, "LT/RT delay %+.3f samples (%+.1f µs, LT late when positive), correlation %.3f at the peak%s\n", d.Samples, d.Microseconds(sampleRate), d.Correlation, edge)
+
+	m := decodingMatrix(cfg)
+	outputs := DecodeMatrix(LT, RT, m, cfg.decodeOptions(sampleRate))
+
+	channels := m.Channels()
+	rms := make([]float64, len(channels))
+	loudest := math.Inf(-1)
+	for i, channel := range channels {
+		_, rms[i] = levelDB(outputs[channel])
+		loudest = math.Max(loudest, rms[i])
+	}
+
+	fmt.Fprintf(w, "%s decoded channels (rms relative to the loudest channel):\n", m.Name)
+	for i, channel := range channels {
+		fmt.Fprintf(w, "%-4s  %7.2f dB\n", channel, rms[i]-loudest)
+	}
+	decoded := make([][]float64, len(channels))
+	for i, channel := range channels {
+		decoded[i] = outputs[channel]
+	}
+	l := measureLoudness(decoded, channels, sampleRate)
+	fmt.Fprintf(w, "loudness %.1f LUFS   range %.1f LU   true peak %.1f dBTP (normalize=%s)\n", l.IntegratedLUFS, l.RangeLU, l.TruePeakDBTP, cfg.Normalize)
+
+	if crosscheck {
+		// second independent implementation : time domain FIR Hilbert transformers
+		hilbertOutputs := DecodeHilbert(LT, RT, m, cfg.decodeOptions(sampleRate), cfg.Hilbert)
+		fmt.Fprintf(w, "FFT vs FIR Hilbert decoding (%d taps, %s), difference relative to the FFT output:\n", cfg.Hilbert.Taps, cfg.Hilbert.Window)
+		for _, channel := range channels {
+			fmt.Fprintf(w, "%-4s  %7.2f dB\n", channel, engineDifference(outputs[channel], hilbertOutputs[channel]))
+		}
+	}
+}
+
+// Detection is the result of DetectMatrix : the guessed matrix and how the polarized LT/RT energy
+// is shared between the front positions, the SQ back positions and the QS back positions.
+type Detection struct {
+	Matrix    string
+	Polarized float64 // share of the energy that comes from well localized sources
+	Front     float64
+	SQBack    float64
+	QSBack    float64
+}
+
+// stokes holds the Stokes parameters of an (LT, RT) pair, accumulated over a time-frequency tile.
+// Like the polarization of light, each quad position maps to a point of the Poincaré sphere :
+//
+//	s1 = |LT|²-|RT|²   (left / right amplitude ratio)
+//	s2 = 2*Re(LT*conj(RT))   (in phase / antiphase)
+//	s3 = 2*Im(LT*conj(RT))   (quadrature)
+type stokes struct {
+	s0, s1, s2, s3 float64
+}
+
+func (s *stokes) add(lt, rt complex128) {
+	cross := lt * cmplx.Conj(rt)
+	powerLT := real(lt)*real(lt) + imag(lt)*imag(lt)
+	powerRT := real(rt)*real(rt) + imag(rt)*imag(rt)
+	s.s0 += powerLT + powerRT
+	s.s1 += powerLT - powerRT
+	s.s2 += 2 * real(cross)
+	s.s3 += 2 * imag(cross)
+}
+
+// polarization returns the degree of polarization (0 for uncorrelated LT/RT, 1 for a single source)
+// and the unit vector of the tile on the Poincaré sphere.
+func (s stokes) polarization() (float64, [3]float64) {
+	length := math.Sqrt(s.s1*s.s1 + s.s2*s.s2 + s.s3*s.s3)
+	if s.s0 == 0 || length == 0 {
+		return 0, [3]float64{}
+	}
+	return length / s.s0, [3]float64{s.s1 / length, s.s2 / length, s.s3 / length}
+}
+
+// stokesTiles cuts LT/RT in Hann windowed frames and returns, for each frame, the Stokes
+// parameters of bands of bandWidth bins between minFreq and maxFreq.
+func stokesTiles(LT []float64, RT []float64, sampleRate int, windowSize int, bandWidth int, minFreq float64, maxFreq float64) [][]stokes {
+	fft := fourier.NewFFT(windowSize)
+	window := make([]float64, windowSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(windowSize-1)) // Hann
+	}
+
+	freqResolution := float64(sampleRate) / float64(windowSize)
+	minIndex := int(minFreq / freqResolution)
+	maxIndex := int(maxFreq / freqResolution)
+	if maxIndex > windowSize/2 {
+		maxIndex = windowSize / 2
+	}
+
+	var frames [][]stokes
+	frameLT := make([]float64, windowSize)
+	frameRT := make([]float64, windowSize)
+	for start := 0; start+windowSize <= len(LT); start += windowSize {
+		for i := 0; i < windowSize; i++ {
+			frameLT[i] = LT[start+i] * window[i]
+			frameRT[i] = RT[start+i] * window[i]
+		}
+		freqLT := fft.Coefficients(nil, frameLT)
+		freqRT := fft.Coefficients(nil, frameRT)
+
+		var bands []stokes
+		for band := minIndex; band+bandWidth <= maxIndex; band += bandWidth {
+			var tile stokes
+			for i := band; i < band+bandWidth; i++ {
+				tile.add(freqLT[i], freqRT[i])
+			}
+			bands = append(bands, tile)
+		}
+		frames = append(frames, bands)
+	}
+	return frames
+}
+
+// DetectMatrix guesses the matrix encoding of a stereo recording.
+//
+// On the Poincaré sphere SQ puts lb and rb on the poles (LT and RT in quadrature, s3 = ±1) while
+// QS puts them on the equator in antiphase (s2 = -0.707) ; front positions are in phase for both.
+// Time-frequency tiles are only counted as much as they are polarized, so that reverberation and
+// uncorrelated stereo content do not vote.
+func DetectMatrix(LT []float64, RT []float64, sampleRate int) Detection {
+	const threshold = 0.1 // minimum share of polarized energy at the back positions to call it a matrix
+
+	var total, polarized, front, sqBack, qsBack float64
+	for _, frame := range stokesTiles(LT, RT, sampleRate, 2048, 16, 100, 8000) {
+		for _, tile := range frame {
+			degree, direction := tile.polarization()
+			weight := tile.s0 * degree
+			total += tile.s0
+			polarized += weight
+			switch {
+			case math.Abs(direction[2]) > 0.7:
+				sqBack += weight
+			case direction[1] < -0.5 && math.Abs(direction[2]) < 0.4:
+				qsBack += weight
+			case direction[1] > 0:
+				front += weight
+			}
+		}
+	}
+
+	detection := Detection{Matrix: "stereo"}
+	if total == 0 || polarized == 0 {
+		return detection
+	}
+	detection.Polarized = polarized / total
+	detection.Front = front / polarized
+	detection.SQBack = sqBack / polarized
+	detection.QSBack = qsBack / polarized
+
+	switch {
+	case detection.SQBack < threshold && detection.QSBack < threshold:
+		detection.Matrix = "stereo"
+	case detection.SQBack >= detection.QSBack:
+		detection.Matrix = "SQ"
+	default:
+		detection.Matrix = "QS"
+	}
+
+	log.Info("DetectMatrix is done.", "matrix", detection.Matrix, "polarized", detection.Polarized, "front", detection.Front, "sqback", detection.SQBack, "qsback", detection.QSBack)
+
+	return detection
+}
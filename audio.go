@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Audio files : the input format is found from the first bytes of the file (or given by
+// -input-format raw), the output format from -outformat or from the extension of the output file.
+
+// OutputOptions are the options of the written audio files.
+type OutputOptions struct {
+	// Format is wav, flac, aiff, aifc or raw.
+	Format string
+	// BW64 writes the wave files larger than 4 GB as BW64 (ITU-R BS.2088) instead of RF64 (EBU Tech 3306).
+	BW64 bool
+	// Metadata are the tags and chunks written in the files, after the audio data.
+	Metadata Metadata
+	// Raw is the sample format of the raw files, its rate and channels are not used.
+	Raw RawFormat
+	// Dither is the dither of the integer samples : none, tpdf or shaped.
+	Dither string
+	// SplitTracks writes one file per track, the tracks starting at the cue points of Metadata.
+	SplitTracks bool
+	// Loudness is the loudness of the channels written, for the bext chunk : measured when nil.
+	Loudness *Loudness
+}
+
+// extension returns the extension of the output files, with the dot.
+func (opts OutputOptions) extension() string {
+	switch opts.Format {
+	case "flac", "aiff", "aifc", "raw":
+		return "." + opts.Format
+	}
+	return ".wav"
+}
+
+// parseOutFormat checks the -outformat flag.
+func parseOutFormat(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", "wav", "wave":
+		return "wav", nil
+	case "flac":
+		return "flac", nil
+	case "aiff", "aif":
+		return "aiff", nil
+	case "aifc", "aiff-c":
+		return "aifc", nil
+	case "raw", "pcm":
+		return "raw", nil
+	}
+	return "", fmt.Errorf("outformat must be wav, flac, aiff, aifc or raw, not %q", value)
+}
+
+// formatFromPath returns the output format matching the extension of a file name, wav by default.
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		return "flac"
+	case ".aif", ".aiff":
+		return "aiff"
+	case ".aifc":
+		return "aifc"
+	case ".raw", ".pcm":
+		return "raw"
+	}
+	return "wav"
+}
+
+// speakerBits are the WAVEFORMATEXTENSIBLE speaker positions of the channel names.
+var speakerBits = map[string]uint32{
+	"LF": 0x1, "RF": 0x2, "C": 0x4, "CF": 0x4, "LFE": 0x8, "LB": 0x10, "RB": 0x20,
+	"CB": 0x100, "LS": 0x200, "RS": 0x400,
+}
+
+// channelMask returns the speaker mask of the channels. ok is false when a channel has no
+// speaker position or when the channels are not in the order of the mask bits.
+func channelMask(names []string) (uint32, bool) {
+	var mask uint32
+	for _, name := range names {
+		bit, found := speakerBits[name]
+		if !found || bit <= mask {
+			return 0, false
+		}
+		mask |= bit
+	}
+	return mask, true
+}
+
+// writeAudio writes the channels, in the order of the slice, in the format of opts.
+// names are the channel names, used for the FLAC channel mask.
+func writeAudio(s string, sampleRate int, names []string, channels [][]float64, opts OutputOptions) error {
+	if opts.SplitTracks && len(opts.Metadata.Cues) > 0 {
+		return writeTracks(s, sampleRate, names, channels, opts)
+	}
+	opts.Metadata = opts.Metadata.clipCues(int64(len(channels[0])))
+	switch opts.Format {
+	case "flac":
+		if len(opts.Metadata.Bext)+len(opts.Metadata.IXML)+len(opts.Metadata.ID3) > 0 {
+			log.Info("bext, iXML and ID3 chunks are not written in FLAC files, only the tags and the cue points", "output", s)
+		}
+		for _, cue := range opts.Metadata.Cues {
+			if cue.Label != "" {
+				log.Info("The labels of the cue points are not written in FLAC files", "output", s)
+				break
+			}
+		}
+		return writeFLAC(s, sampleRate, names, channels, opts)
+	case "aiff", "aifc":
+		if len(opts.Metadata.Bext)+len(opts.Metadata.IXML) > 0 {
+			log.Info("bext and iXML chunks are not written in AIFF files, only the tags and ID3", "output", s)
+		}
+		return writeAIFF(s, sampleRate, names, channels, opts)
+	case "raw":
+		return writeRaw(s, sampleRate, names, channels, opts)
+	}
+	if len(opts.Metadata.Bext) >= 602 {
+		if opts.Loudness == nil {
+			l := measureLoudness(channels, names, sampleRate)
+			opts.Loudness = &l
+		}
+		opts.Metadata.Bext = bextLoudness(opts.Metadata.Bext, *opts.Loudness)
+	}
+	return writeWave(s, sampleRate, names, channels, opts)
+}
+
+// id3Size returns the length of an ID3v2 tag from its 10 bytes header : the header, the
+// 7 bits per byte ("syncsafe") size and the footer when its flag is set.
+func id3Size(header []byte) int64 {
+	size := 10 + (int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9]))
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	return size
+}
+
+// sniffFormat returns the container of a file from its first 4 bytes : flac, aiff or wav. An
+// ID3v2 tag is skipped, only FLAC files may start with one. The file is read again from the start.
+func sniffFormat(f io.ReadSeeker) (string, error) {
+	magic := make([]byte, 10)
+	if _, err := io.ReadFull(f, magic[:4]); err != nil {
+		return "", fmt.Errorf("error reading audio file: %w", err)
+	}
+	if string(magic[0:3]) == "ID3" {
+		if _, err := io.ReadFull(f, magic[4:]); err != nil {
+			return "", fmt.Errorf("error reading ID3 tag: %w", err)
+		}
+		if _, err := f.Seek(id3Size(magic), io.SeekStart); err != nil {
+			return "", fmt.Errorf("error reading ID3 tag: %w", err)
+		}
+		if _, err := io.ReadFull(f, magic[:4]); err != nil {
+			return "", fmt.Errorf("error reading audio file after the ID3 tag: %w", err)
+		}
+		if string(magic[:4]) != "fLaC" {
+			return "", fmt.Errorf("unsupported format : ID3 tag followed by %q, not a FLAC file", magic[:4])
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("error reading audio file: %w", err)
+	}
+	switch string(magic[:4]) {
+	case "fLaC":
+		return "flac", nil
+	case "FORM":
+		return "aiff", nil
+	}
+	return "wav", nil
+}
+
+// readAudio reads all the channels of a wave, FLAC or AIFF file.
+func readAudio(s string) (WaveInfo, [][]float64, error) {
+	f, err := os.Open(s)
+	if err != nil {
+		return WaveInfo{}, nil, fmt.Errorf("error opening audio file: %w", err)
+	}
+	defer f.Close()
+
+	format, err := sniffFormat(f)
+	if err != nil {
+		return WaveInfo{}, nil, err
+	}
+	switch format {
+	case "flac":
+		info, channels, _, err := decodeFLAC(f)
+		return info, channels, err
+	case "aiff":
+		return decodeAIFF(f)
+	}
+	return decodeWave(f)
+}
+
+// readAudioInfo reads the format of a wave, FLAC or AIFF file without reading the audio data.
+func readAudioInfo(s string) (WaveInfo, error) {
+	f, err := os.Open(s)
+	if err != nil {
+		return WaveInfo{}, fmt.Errorf("error opening audio file: %w", err)
+	}
+	defer f.Close()
+
+	format, err := sniffFormat(f)
+	if err != nil {
+		return WaveInfo{}, err
+	}
+	switch format {
+	case "flac":
+		stream, err := readFLACMetadata(bufio.NewReader(f))
+		info := WaveInfo{
+			Container:     "FLAC",
+			Channels:      stream.channels,
+			SampleRate:    stream.sampleRate,
+			BitsPerSample: stream.bitsPerSample,
+			ValidBits:     stream.bitsPerSample,
+			Frames:        stream.totalSamples,
+		}
+		return info, err
+	case "aiff":
+		info, _, err := readAIFFHeader(f)
+		return info, err
+	}
+	info, _, err := readWaveHeader(f)
+	return info, err
+}
+
+// InputOptions tell how to read the input file.
+type InputOptions struct {
+	// Channels chooses LT and RT in a file with other than 2 channels, as "3,4" (channels are
+	// numbered from 1) ; empty for a stereo file.
+	Channels string
+	// Raw is the format of a raw input file, nil when the format is read from the file.
+	Raw *RawFormat
+}
+
+// inputFlags are the flags of the commands reading LT and RT.
+type inputFlags struct {
+	channels string
+	format   string
+	endian   string
+	raw      RawFormat
+}
+
+func (f *inputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.channels, "channels", "", "is optional : the two channels used as LT and RT in a file which is not stereo, for example 3,4")
+	fs.StringVar(&f.format, "input-format", "auto", "is optional : auto (wave, FLAC or AIFF, read from the file) or raw (headerless PCM, see the -raw flags)")
+	fs.IntVar(&f.raw.SampleRate, "raw-rate", 44100, "is optional : sample rate of a raw input")
+	fs.IntVar(&f.raw.Channels, "raw-channels", 2, "is optional : number of channels of a raw input")
+	fs.IntVar(&f.raw.Bits, "raw-bits", 16, "is optional : bits per sample of raw input and output, 8, 16, 24 or 32 (32 or 64 with -raw-float)")
+	fs.BoolVar(&f.raw.Float, "raw-float", false, "is optional : raw samples are IEEE float")
+	fs.StringVar(&f.endian, "raw-endian", "little", "is optional : byte order of raw samples, little or big")
+}
+
+// resolve checks the input flags. The raw format is returned for the raw outputs even when the
+// input is not raw.
+func (f *inputFlags) resolve() (InputOptions, RawFormat, error) {
+	raw := f.raw
+	switch strings.ToLower(f.endian) {
+	case "little", "le":
+	case "big", "be":
+		raw.BigEndian = true
+	default:
+		return InputOptions{}, raw, fmt.Errorf("raw-endian must be little or big, not %q", f.endian)
+	}
+	if _, err := raw.waveInfo(0); err != nil {
+		return InputOptions{}, raw, err
+	}
+
+	in := InputOptions{Channels: f.channels}
+	switch strings.ToLower(f.format) {
+	case "", "auto":
+	case "raw":
+		in.Raw = &raw
+	default:
+		return in, raw, fmt.Errorf("input-format must be auto or raw, not %q", f.format)
+	}
+	return in, raw, nil
+}
+
+// readWaveFile reads LT and RT from a stereo wave, FLAC or AIFF file.
+func readWaveFile(s string) ([]float64, []float64, int, error) {
+	return readAudioFile(s, InputOptions{})
+}
+
+// readAudioFile reads LT and RT from a wave, FLAC, AIFF or raw file.
+func readAudioFile(s string, in InputOptions) ([]float64, []float64, int, error) {
+	var info WaveInfo
+	var channels [][]float64
+	var err error
+	if in.Raw != nil {
+		info, channels, err = readRaw(s, *in.Raw)
+	} else {
+		info, channels, err = readAudio(s)
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	lt, rt, err := pickChannels(info.Channels, in.Channels)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	log.Info("Audio Data Input", "container", info.Container, "channels", info.Channels, "LT", lt+1, "RT", rt+1, "sampleRate", info.SampleRate,
+		"bitsPerSample", info.BitsPerSample, "formatCode", info.SubFormat, "frames", info.Frames)
+
+	return channels[lt], channels[rt], info.SampleRate, nil
+}
+
+// pickChannels returns the indices of LT and RT in a file with count channels.
+func pickChannels(count int, pick string) (int, int, error) {
+	if pick == "" {
+		switch count {
+		case 2:
+			return 0, 1, nil
+		case 1:
+			return 0, 0, fmt.Errorf("mono file: LT/RT decoding needs a stereo file")
+		default:
+			return 0, 0, fmt.Errorf("%d channels file: choose LT and RT with -channels, for example -channels 1,2", count)
+		}
+	}
+
+	parts := strings.Split(pick, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("channels must be two channel numbers as LT,RT, not %q", pick)
+	}
+	var picked [2]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 || n > count {
+			return 0, 0, fmt.Errorf("channel %q is not between 1 and %d", part, count)
+		}
+		picked[i] = n - 1
+	}
+	return picked[0], picked[1], nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDecodeOutputFormats checks that -outformat flac, aiff and aifc write the same channels, in
+// the same order, as the wave files.
+func TestDecodeOutputFormats(t *testing.T) {
+	input, err := filepath.Abs(filepath.Join("testdata", "sq_speech_SQ.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []string{"4.0", "5.1"} {
+		outputs := map[string]string{}
+		for _, format := range []string{"wav", "flac", "aiff", "aifc"} {
+			dir := t.TempDir()
+			outputs[format] = dir
+			inDir(t, dir, func() {
+				if err := runDecode([]string{"-input", input, "-audioformat", layout, "-outformat", format}); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+
+		entries, err := os.ReadDir(outputs["wav"])
+		if err != nil || len(entries) == 0 {
+			t.Fatalf("no wave output: %v", err)
+		}
+		for _, entry := range entries {
+			_, want := readPCM16(t, filepath.Join(outputs["wav"], entry.Name()))
+			for _, format := range []string{"flac", "aiff", "aifc"} {
+				path := filepath.Join(outputs[format], strings.TrimSuffix(entry.Name(), ".wav")+"."+format)
+				_, got, err := readAudio(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(want) || len(got[0]) != len(want[0]) {
+					t.Fatalf("%s: %d channels of %d frames, want %d of %d", path, len(got), len(got[0]), len(want), len(want[0]))
+				}
+				// aifc is float : the wave samples are its values truncated to 16 bits
+				tolerance := 0.0
+				if format == "aifc" {
+					tolerance = 1
+				}
+				for c := range want {
+					for i := range want[c] {
+						if v := math.Round(got[c][i] * 32767); math.Abs(v-float64(want[c][i])) > tolerance {
+							t.Fatalf("%s: channel %d frame %d = %v, want %d", path, c, i, v, want[c][i])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string // empty for an error
+	}{
+		{"flac", "fLaC\x00\x00\x00\x22", "flac"},
+		{"aiff", "FORM\x00\x00\x00\x04AIFF", "aiff"},
+		{"wav", "RIFF\x00\x00\x00\x04WAVE", "wav"},
+		{"ID3 flac", "ID3\x04\x00\x00\x00\x00\x00\x05tags.fLaC", "flac"},
+		{"ID3 footer flac", "ID3\x04\x00\x10\x00\x00\x00\x05tags.3DI\x04\x00\x10\x00\x00\x00\x05fLaC", "flac"},
+		{"ID3 syncsafe size", "ID3\x03\x00\x00\x00\x00\x01\x01" + strings.Repeat(".", 129) + "fLaC", "flac"},
+		{"ID3 mp3", "ID3\x04\x00\x00\x00\x00\x00\x05tags.\xff\xfb\x90\x64", ""},
+		{"ID3 truncated", "ID3\x04\x00\x00\x00\x00\x01\x00tags", ""},
+	}
+	for _, tt := range tests {
+		r := strings.NewReader(tt.data)
+		got, err := sniffFormat(r)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: %s, want an error", tt.name, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("%s: %q, %v, want %s", tt.name, got, err, tt.want)
+		case tt.want != "" && r.Len() != len(tt.data):
+			t.Errorf("%s: not read again from the start", tt.name)
+		}
+	}
+}
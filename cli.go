@@ -0,0 +1,644 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+)
+
+// A command is one subcommand of the sqdecoder command line : sqdecoder <command> [options]
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"decode", "decode an SQ or QS encoded stereo wave file into front/back, 4.0, 5.1 or stems outputs", runDecode},
+		{"encode", "encode front and back stereo wave files into an SQ or QS stereo wave file", runEncode},
+		{"analyze", "report levels, correlation, decoded channel levels and Poincaré sphere directions of an encoded wave file", runAnalyze},
+		{"detect", "guess whether a stereo wave file is SQ, QS or plain stereo", runDetect},
+		{"generate", "generate SQ and QS encoded test signals with the positions of the sources in a JSON file", runGenerate},
+		{"measure", "measure the channel separation of a decoding with synthetic test signals", runMeasure},
+		{"info", "print the format of a wave file", runInfo},
+		{"ltspice", "print the components and the frequency response of an LTspice .asc schematic", runLTspice},
+	}
+}
+
+// version is the version of the tool, written in the metadata of the decoded files. It is set
+// at build time with go build -ldflags "-X main.version=v3.1".
+var version = ""
+
+// toolVersion returns version, or the VCS revision of the build when it is not set.
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value[:min(12, len(setting.Value))]
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "devel"
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return "devel-" + revision
+}
+
+// errUsage is returned by a command when its flags are wrong or missing; usage has already been printed.
+var errUsage = errors.New("invalid usage")
+
+func printUsage() {
+	fmt.Println("2025 : See my blog https://jeandi7.github.io/jeandi7blog/")
+	fmt.Println()
+	fmt.Println("Usage: sqdecoder <command> [options]")
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println()
+	fmt.Println("Run sqdecoder <command> -help for the options of a command.")
+	fmt.Println("sqdecoder [options] without command is the same as sqdecoder decode [options].")
+}
+
+// newFlagSet returns the flag set of a subcommand, with its own help message.
+func newFlagSet(name string, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet("sqdecoder "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Println("2025 : See my blog https://jeandi7.github.io/jeandi7blog/")
+		fmt.Println()
+		fmt.Printf("Usage: sqdecoder %s [options]\n", name)
+		fmt.Println(summary)
+		fmt.Println("Options:")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the arguments of a subcommand. It returns flag.ErrHelp when -help was asked.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// requireInput prints the usage of the command when the -input flag is missing.
+func requireInput(fs *flag.FlagSet, input string) error {
+	if input == "" {
+		fmt.Println("you must provide an input audio wave file name.")
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	args := os.Args[1:]
+	name := "decode"
+
+	switch {
+	case len(args) == 0 || args[0] == "-help" || args[0] == "--help" || args[0] == "-h" || args[0] == "help":
+		printUsage()
+		return
+	case !strings.HasPrefix(args[0], "-"):
+		name = args[0]
+		args = args[1:]
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(args)
+		switch {
+		case err == nil || errors.Is(err, flag.ErrHelp):
+			return
+		case errors.Is(err, errUsage):
+			os.Exit(2)
+		default:
+			log.Error("sqdecoder "+name+" failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Printf("unknown command %q\n\n", name)
+	printUsage()
+	os.Exit(2)
+}
+
+func runDecode(args []string) error {
+	var input string = ""
+	var inputs inputFlags
+	var plots bool
+	var output OutputOptions
+	var outformat, dither, loudnessJSON, quality string
+	var outputRate int
+	var flags decodeFlags
+
+	fs := newFlagSet("decode", "Decode an SQ or QS encoded stereo wave file.")
+	fs.StringVar(&input, "input", "", "Read audio Wave File")
+	inputs.register(fs)
+	fs.BoolVar(&plots, "plots", false, "is optional : write a spectrogram PNG per decoded channel and a PNG of the levels over time")
+	fs.StringVar(&outformat, "outformat", "wav", "is optional : format of the output files, wav, flac, aiff (16 bits), aifc (32 bits float) or raw (see the -raw flags)")
+	fs.BoolVar(&output.BW64, "bw64", false, "is optional : write the outputs larger than 4 GB as BW64 instead of RF64")
+	fs.StringVar(&dither, "dither", "none", "is optional : dither of the 16 bits (and raw integer) outputs, none, tpdf or shaped (TPDF with noise shaping)")
+	fs.BoolVar(&output.SplitTracks, "split-tracks", false, "is optional : write one file per track, cut at the cue points of the input")
+	fs.IntVar(&outputRate, "samplerate", 0, "is optional : sample rate of the outputs, 48000 or 96000 for example (default the rate of the input)")
+	fs.StringVar(&quality, "resample-quality", "standard", "is optional : quality of the sample rate conversion, fast, standard or best")
+	fs.StringVar(&loudnessJSON, "loudness-json", "", "is optional : write the loudness of the decoded channels (EBU R 128) to this JSON file")
+	flags.register(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireInput(fs, input); err != nil {
+		return err
+	}
+	cfg, err := flags.resolve(fs)
+	if err != nil {
+		return fmt.Errorf("invalid decoding parameters: %w", err)
+	}
+	log.Info("Decoding parameters", "config", cfg)
+	if output.Format, err = parseOutFormat(outformat); err != nil {
+		return err
+	}
+	if output.Dither, err = parseDither(dither); err != nil {
+		return err
+	}
+	if quality, err = parseResampleQuality(quality); err != nil {
+		return err
+	}
+	if outputRate < 0 || outputRate > 768000 {
+		return fmt.Errorf("sample rate must be between 1 and 768000 Hz, or 0 to keep the rate of the input, not %d", outputRate)
+	}
+	ext := output.extension()
+	in, raw, err := inputs.resolve()
+	if err != nil {
+		return err
+	}
+	output.Raw = raw
+
+	LT, RT, sampleRate, err := readAudioFile(input, in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", input, err)
+	}
+	output.Metadata, err = readMetadata(input, in)
+	if err != nil {
+		return fmt.Errorf("failed to read the metadata of %s: %w", input, err)
+	}
+	if outputRate != 0 && outputRate != sampleRate {
+		LT, RT = resample(LT, RT, sampleRate, outputRate, quality)
+		output.Metadata = output.Metadata.resampled(sampleRate, outputRate)
+		sampleRate = outputRate
+	}
+	if err := cfg.validateRate(sampleRate); err != nil {
+		return fmt.Errorf("invalid decoding parameters: %w", err)
+	}
+	output.Metadata.addDecoding(cfg)
+	if output.SplitTracks && len(output.Metadata.Cues) == 0 {
+		log.Warn("No cue point in the input, the outputs are not split", "input", input)
+	}
+
+	m := decodingMatrix(cfg)
+	required := []string{"LF", "RF", "LB", "RB"}
+	if cfg.AudioFormat == "5.1" {
+		required = append(required, "C", "LFE")
+	}
+	if cfg.AudioFormat != "stems" {
+		for _, channel := range required {
+			if _, ok := m.row(channel); !ok {
+				return fmt.Errorf("matrix %q has no %s output, needed for audioformat %q", m.Name, channel, cfg.AudioFormat)
+			}
+		}
+	}
+
+	outputs := decodeWithEngine(LT, RT, m, cfg, sampleRate)
+
+	// the loudness of all the decoded channels, logged, written to -loudness-json and reused for
+	// the bext chunk of the files with all of them
+	report := LoudnessReport{Input: input, Layout: cfg.AudioFormat, Channels: m.Channels(), Normalize: cfg.Normalize.String()}
+	if report.Layout == "" {
+		report.Layout = "front/back"
+	}
+	decoded := make([][]float64, len(report.Channels))
+	for i, channel := range report.Channels {
+		decoded[i] = outputs[channel]
+	}
+	report.Loudness = measureLoudness(decoded, report.Channels, sampleRate)
+	log.Info("Loudness of the decoded channels", "integratedLUFS", logValue(report.Loudness.IntegratedLUFS), "rangeLU", logValue(report.Loudness.RangeLU),
+		"truePeakdBTP", logValue(report.Loudness.TruePeakDBTP), "maxMomentaryLUFS", logValue(report.Loudness.MaxMomentaryLUFS), "maxShortTermLUFS", logValue(report.Loudness.MaxShortTermLUFS))
+	if loudnessJSON != "" {
+		if err := writeLoudnessJSON(loudnessJSON, report); err != nil {
+			return err
+		}
+	}
+	wholeOutput := output
+	wholeOutput.Loudness = &report.Loudness
+	// the 4.0 and 5.1 files have all the channels, unless the custom matrix has others
+	whole := func(count int) OutputOptions {
+		if count == len(m.Channels()) {
+			return wholeOutput
+		}
+		return output
+	}
+
+	// file names : sqdemo1_4_0.wav for SQ, qsdemo2_QS_4_0.wav for QS, sqdemo1_custom_4_0.wav for a custom matrix
+	filename := fileNameExtract(input)
+	var matrixTag string
+	if cfg.Matrix != "SQ" {
+		matrixTag = cfg.Matrix + "_"
+	}
+	frontLeft, frontRight, backLeft, backRight := outputs["LF"], outputs["RF"], outputs["LB"], outputs["RB"]
+
+	if plots {
+		if err := writeDecodePlots(strings.TrimSuffix(filename+"_"+matrixTag, "_"), outputs, m.Channels(), sampleRate); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.AudioFormat {
+	case "5.1":
+		filename5_1Channels := filename + "_" + matrixTag + "5_1" + ext
+		log.Info("Write output 5.1 channels..(experimental)...", "matrix", cfg.Matrix, "ouput", filename5_1Channels)
+		// SMPTE order : L, R, C, LFE, Ls, Rs
+		err = writeAudio(filename5_1Channels, sampleRate, []string{"LF", "RF", "C", "LFE", "LB", "RB"},
+			[][]float64{frontLeft, frontRight, outputs["C"], outputs["LFE"], backLeft, backRight}, whole(6))
+		if err != nil {
+			return fmt.Errorf("failed to write output 5.1 channels: %w", err)
+		}
+
+	case "stems":
+		prefix := strings.TrimSuffix(filename+"_"+matrixTag, "_")
+		err = writeStems(prefix, sampleRate, m.Channels(), outputs, output)
+		if err != nil {
+			return fmt.Errorf("failed to write output stems: %w", err)
+		}
+
+	case "4.0":
+		filename4Channels := filename + "_" + matrixTag + "4_0" + ext
+		log.Info("Write output 4.0 channels...", "matrix", cfg.Matrix, "ouput", filename4Channels)
+		err = writeAudio(filename4Channels, sampleRate, []string{"LF", "RF", "LB", "RB"}, [][]float64{frontLeft, frontRight, backLeft, backRight}, whole(4))
+		if err != nil {
+			return fmt.Errorf("failed to write output 4.0 channels: %w", err)
+		}
+
+	default:
+		filenameBackChanels := "output_back_" + matrixTag + filename + ext
+		filenameFrontChanels := "output_front_" + matrixTag + filename + ext
+		log.Info("Write output back channels...", "matrix", cfg.Matrix, "ouput", filenameBackChanels)
+		err = writeAudio(filenameBackChanels, sampleRate, []string{"LB", "RB"}, [][]float64{backLeft, backRight}, output)
+		if err != nil {
+			return fmt.Errorf("failed to write output back channels: %w", err)
+		}
+		log.Info("Write output front channels...", "matrix", cfg.Matrix, "ouput", filenameFrontChanels)
+		err = writeAudio(filenameFrontChanels, sampleRate, []string{"LF", "RF"}, [][]float64{frontLeft, frontRight}, output)
+		if err != nil {
+			return fmt.Errorf("failed to write output front channels: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func runEncode(args []string) error {
+	var front, back, output, matrixformat, dither string
+	var inputs inputFlags
+
+	fs := newFlagSet("encode", "Encode a front stereo wave file (lf, rf) and a back stereo wave file (lb, rb) into an SQ or QS stereo wave file (LT, RT).")
+	fs.StringVar(&front, "front", "", "Read front channels (lf, rf) from this stereo Wave File")
+	fs.StringVar(&back, "back", "", "Read back channels (lb, rb) from this stereo Wave File")
+	inputs.register(fs)
+	fs.StringVar(&output, "output", "", "is optional : encoded output Wave, FLAC (.flac), AIFF (.aif, .aiff, .aifc) or raw 16 bits (.raw) File (default <front>_SQ.wav or <front>_QS.wav)")
+	fs.StringVar(&matrixformat, "matrixformat", "", "is optional : value must be SQ or QS, in any case (default SQ)")
+	fs.StringVar(&dither, "dither", "none", "is optional : dither of the output, none, tpdf or shaped (TPDF with noise shaping)")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if front == "" || back == "" {
+		fmt.Println("you must provide a front and a back stereo wave file name.")
+		fs.Usage()
+		return errUsage
+	}
+	dither, err := parseDither(dither)
+	if err != nil {
+		return err
+	}
+	// SQ when empty, as decode
+	switch strings.ToUpper(matrixformat) {
+	case "", "SQ":
+		matrixformat = "SQ"
+	case "QS":
+		matrixformat = "QS"
+	default:
+		return fmt.Errorf("matrixformat must be SQ or QS, not %q", matrixformat)
+	}
+	in, _, err := inputs.resolve()
+	if err != nil {
+		return err
+	}
+
+	frontLeft, frontRight, sampleRate, err := readAudioFile(front, in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", front, err)
+	}
+	backLeft, backRight, backSampleRate, err := readAudioFile(back, in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", back, err)
+	}
+	if backSampleRate != sampleRate {
+		return fmt.Errorf("front and back files must have the same sample rate (%d and %d)", sampleRate, backSampleRate)
+	}
+	if len(frontLeft) != len(backLeft) {
+		return fmt.Errorf("front and back files must have the same length (%d and %d samples)", len(frontLeft), len(backLeft))
+	}
+
+	var LT, RT []float64
+	if matrixformat == "QS" {
+		LT, RT = EncodeQS(frontLeft, frontRight, backLeft, backRight)
+	} else {
+		LT, RT = EncodeSQ(frontLeft, frontRight, backLeft, backRight)
+	}
+
+	if output == "" {
+		output = fileNameExtract(front) + "_" + matrixformat + ".wav"
+	}
+	log.Info("Write encoded output...", "matrix", matrixformat, "ouput", output)
+	err = writeAudio(output, sampleRate, []string{"LT", "RT"}, [][]float64{LT, RT}, OutputOptions{Format: formatFromPath(output), Dither: dither})
+	if err != nil {
+		return fmt.Errorf("failed to write encoded output: %w", err)
+	}
+
+	return nil
+}
+
+func runAnalyze(args []string) error {
+	var input string
+	var inputs inputFlags
+	var crosscheck, poincare bool
+	var flags decodeFlags
+
+	fs := newFlagSet("analyze", "Report levels and correlation of LT/RT, the levels of the decoded channels and the directions on the Poincaré sphere.")
+	fs.StringVar(&input, "input", "", "Read audio Wave File")
+	inputs.register(fs)
+	fs.BoolVar(&crosscheck, "crosscheck", false, "is optional : compare the FFT decoding with the FIR Hilbert decoding")
+	fs.BoolVar(&poincare, "poincare", false, "is optional : write the Poincaré sphere map (PNG) and the per window positions (CSV)")
+	flags.register(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireInput(fs, input); err != nil {
+		return err
+	}
+	cfg, err := flags.resolve(fs)
+	if err != nil {
+		return fmt.Errorf("invalid decoding parameters: %w", err)
+	}
+
+	in, _, err := inputs.resolve()
+	if err != nil {
+		return err
+	}
+	LT, RT, sampleRate, err := readAudioFile(input, in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", input, err)
+	}
+
+	if err := cfg.validateRate(sampleRate); err != nil {
+		return fmt.Errorf("invalid decoding parameters: %w", err)
+	}
+	printAnalysis(os.Stdout, input, cfg, LT, RT, sampleRate, crosscheck)
+
+	report := AnalyzePoincare(LT, RT, sampleRate)
+	printPoincare(os.Stdout, report)
+	if poincare {
+		filename := fileNameExtract(input)
+		if err := writePoincarePNG(filename+"_poincare.png", report); err != nil {
+			return err
+		}
+		if err := writePoincareCSV(filename+"_poincare.csv", report); err != nil {
+			return err
+		}
+		log.Info("Poincaré sphere report written", "png", filename+"_poincare.png", "csv", filename+"_poincare.csv")
+	}
+	return nil
+}
+
+func runDetect(args []string) error {
+	var input string
+	var inputs inputFlags
+
+	fs := newFlagSet("detect", "Guess the matrix encoding (SQ, QS or stereo) of a stereo wave file from the LT/RT phase relationship.")
+	fs.StringVar(&input, "input", "", "Read audio Wave File")
+	inputs.register(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireInput(fs, input); err != nil {
+		return err
+	}
+
+	in, _, err := inputs.resolve()
+	if err != nil {
+		return err
+	}
+	LT, RT, sampleRate, err := readAudioFile(input, in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", input, err)
+	}
+
+	detection := DetectMatrix(LT, RT, sampleRate)
+	fmt.Printf("%s: %s (polarized %.1f%%, front %.1f%%, SQ back %.1f%%, QS back %.1f%%)\n", input, detection.Matrix,
+		100*detection.Polarized, 100*detection.Front, 100*detection.SQBack, 100*detection.QSBack)
+	return nil
+}
+
+func runInfo(args []string) error {
+	var input string
+
+	fs := newFlagSet("info", "Print the format and the metadata of a wave, FLAC or AIFF file.")
+	fs.StringVar(&input, "input", "", "Read audio Wave File")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireInput(fs, input); err != nil {
+		return err
+	}
+
+	info, err := readAudioInfo(input)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", input, err)
+	}
+	meta, err := readMetadata(input, InputOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read the metadata of %s: %w", input, err)
+	}
+
+	fmt.Printf("file:            %s\n", input)
+	fmt.Printf("container:       %s\n", info.Container)
+	switch {
+	case info.aiff():
+		fmt.Printf("compression:     %s\n", info.Compression)
+	case info.Container != "FLAC":
+		fmt.Printf("format code:     %#x\n", info.FormatCode)
+	}
+	if info.FormatCode == waveFormatExtensible {
+		fmt.Printf("sub format:      %#x\n", info.SubFormat)
+		fmt.Printf("valid bits:      %d\n", info.ValidBits)
+		fmt.Printf("channel mask:    %#x\n", info.ChannelMask)
+	}
+	fmt.Printf("channels:        %d\n", info.Channels)
+	fmt.Printf("sample rate:     %d Hz\n", info.SampleRate)
+	fmt.Printf("bits per sample: %d\n", info.BitsPerSample)
+	fmt.Printf("frames:          %d\n", info.Frames)
+	fmt.Printf("duration:        %.3f s\n", info.Duration())
+	for _, tag := range meta.Tags {
+		fmt.Printf("tag:             %s=%s\n", tag.Name, strings.ReplaceAll(tag.Value, "\n", " / "))
+	}
+	if len(meta.Bext) >= 256 {
+		fmt.Printf("bext:            %s\n", strings.TrimRight(string(meta.Bext[:256]), "\x00"))
+	}
+	if len(meta.Bext) > 602 {
+		for _, line := range strings.Split(strings.TrimRight(string(meta.Bext[602:]), "\x00\r\n"), "\r\n") {
+			fmt.Printf("coding history:  %s\n", line)
+		}
+	}
+	if len(meta.IXML) > 0 {
+		fmt.Printf("iXML:            %d bytes\n", len(meta.IXML))
+	}
+	if len(meta.ID3) > 0 {
+		fmt.Printf("ID3:             %d bytes\n", len(meta.ID3))
+	}
+	for _, cue := range meta.Cues {
+		fmt.Printf("cue point:       %d at %.3f s (frame %d) %s\n", cue.ID, float64(cue.Position)/float64(info.SampleRate), cue.Position, cue.Label)
+	}
+	return nil
+}
+
+func runLTspice(args []string) error {
+	var input, node string
+
+	fs := newFlagSet("ltspice", "Print the components and the frequency response of an LTspice .asc schematic.")
+	fs.StringVar(&input, "input", "", "Read LTspice .asc schematic")
+	fs.StringVar(&node, "node", "", "is optional : output net (default the flag named out)")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if input == "" {
+		fmt.Println("you must provide an input LTspice .asc file name.")
+		fs.Usage()
+		return errUsage
+	}
+
+	circuit, err := ParseASC(input)
+	if err != nil {
+		return err
+	}
+	return printCircuit(os.Stdout, circuit, node)
+}
+
+func runMeasure(args []string) error {
+	var signal, output string
+	var freq, duration float64
+	var sampleRate int
+	var flags decodeFlags
+
+	fs := newFlagSet("measure", "Encode a test signal in each quad position, decode it and report the separation matrix in dB per band.")
+	fs.StringVar(&signal, "signal", "pink", "is optional : test signal, pink (pink noise) or tone")
+	fs.Float64Var(&freq, "freq", 1000, "is optional : frequency of the tone in Hz")
+	fs.Float64Var(&duration, "duration", 3, "is optional : length of the test signal in seconds, rounded up to a power of 2 samples")
+	fs.IntVar(&sampleRate, "samplerate", 44100, "is optional : sample rate of the test signal")
+	fs.StringVar(&output, "json", "", "is optional : write the measurement to this JSON file")
+	flags.register(fs)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	signal, err := parseSignal(signal)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 || sampleRate <= 0 || freq <= 0 || freq >= float64(sampleRate)/2 {
+		return fmt.Errorf("duration, sample rate and tone frequency must be positive, and the tone below half the sample rate")
+	}
+	cfg, err := flags.resolve(fs)
+	if err == nil {
+		err = cfg.validateRate(sampleRate)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid decoding parameters: %w", err)
+	}
+
+	n := 1
+	for n < int(duration*float64(sampleRate)) {
+		n *= 2
+	}
+	measurement := MeasureSeparation(cfg, signal, freq, sampleRate, n)
+	printMeasurement(os.Stdout, measurement)
+
+	if output != "" {
+		if err := writeMeasurementJSON(output, measurement); err != nil {
+			return err
+		}
+		log.Info("Measurement written", "output", output)
+	}
+	return nil
+}
+
+func runGenerate(args []string) error {
+	var prefix, matrixformat string
+	opts := GeneratorOptions{}
+
+	fs := newFlagSet("generate", "Generate SQ and QS encoded test signals : tones, sweeps, pink noise bursts or speech-like signals at each corner, at intermediate angles and rotating.")
+	fs.StringVar(&opts.Signal, "signal", "pink", "is optional : tone, sweep, pink (pink noise bursts) or speech (speech-like signal)")
+	fs.Float64Var(&opts.FreqHz, "freq", 1000, "is optional : frequency of the tone in Hz")
+	fs.StringVar(&opts.Positions, "positions", "all", "is optional : corners, angles (corners and intermediate angles), rotate or all")
+	fs.Float64Var(&opts.SegmentS, "segment", 1.5, "is optional : length of each fixed position in seconds")
+	fs.Float64Var(&opts.GapS, "gap", 0.5, "is optional : silence between the positions in seconds")
+	fs.Float64Var(&opts.RotationS, "rotation", 8, "is optional : length of the turn of the rotating source in seconds")
+	fs.IntVar(&opts.SampleRate, "samplerate", 44100, "is optional : sample rate")
+	fs.StringVar(&matrixformat, "matrixformat", "both", "is optional : SQ, QS or both")
+	fs.StringVar(&prefix, "output", "", "is optional : prefix of the output files (default test_<signal>)")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	opts.Signal = strings.ToLower(opts.Signal)
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	matrices, err := parseMatrices(matrixformat)
+	if err != nil {
+		return err
+	}
+	if prefix == "" {
+		prefix = "test_" + opts.Signal
+	}
+
+	return generateFiles(prefix, matrices, opts)
+}
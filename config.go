@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SQCoefficients are the coefficients of the SQ decoding matrix.
+type SQCoefficients struct {
+	// Alpha is the back channels coefficient, normally 1/SQR(2).
+	Alpha float64 `json:"alpha"`
+	// Blend is the fraction of each back channel mixed into the other back channel (0 = no blend).
+	// Blend decoders trade some back left/right separation for a less "hole in the middle" back image.
+	Blend float64 `json:"blend"`
+}
+
+// QSCoefficients are the coefficients of the QS decoding matrix : lf = alpha*LT + beta*RT ...
+type QSCoefficients struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+}
+
+// LFEParameters control how the LFE channel is recreated in 5.1 and stems outputs.
+type LFEParameters struct {
+	// GainDB is the LFE level, -10 dB by default (coefficient 0.316).
+	GainDB float64 `json:"gain_db"`
+	// CutoffHz is the cut-off frequency of the low-pass filter.
+	CutoffHz float64 `json:"cutoff_hz"`
+	// Filter is "continuous" (exponential attenuation above the cut-off) or "rectangular".
+	Filter string `json:"filter"`
+}
+
+// Coeff returns the linear LFE coefficient.
+func (lfe LFEParameters) Coeff() float64 {
+	return math.Pow(10, lfe.GainDB/20)
+}
+
+// Config holds the decoding parameters. It comes from a named preset (-preset) and/or a JSON
+// config file (-config), and each value can be overridden by a command line flag.
+type Config struct {
+	// Preset is the name of the preset a config file starts from.
+	Preset string `json:"preset,omitempty"`
+	// Matrix is SQ, QS or custom.
+	Matrix string `json:"matrix"`
+	// CustomMatrix is the decoding matrix used when Matrix is custom.
+	CustomMatrix *Matrix `json:"custom_matrix,omitempty"`
+	// AudioFormat is the output layout : "" (front and back stereo files), 4.0, 5.1 or stems.
+	AudioFormat string         `json:"audioformat"`
+	SQ          SQCoefficients `json:"sq"`
+	QS          QSCoefficients `json:"qs"`
+	LFE         LFEParameters  `json:"lfe"`
+	// Align measures and corrects the delay between LT and RT before decoding.
+	Align AlignParameters `json:"align"`
+	// Analog replaces the ideal j of the matrix by the phase-shift networks of an analog decoder.
+	Analog AnalogParameters `json:"analog"`
+	// Engine is fft (frequency domain over the whole file) or fir (time domain Hilbert transformers).
+	Engine  string            `json:"engine"`
+	Hilbert HilbertParameters `json:"hilbert"`
+	// Gains are the gain trims in dB applied to the decoded channels LF, RF, LB, RB, C and LFE.
+	Gains map[string]float64 `json:"gains,omitempty"`
+	// Normalize is the gain staging of the decoded channels, after the trims.
+	Normalize GainStaging `json:"normalize"`
+	// Limiter is the true-peak limiter after the gain staging.
+	Limiter Limiter `json:"limiter"`
+	// ASCFilters are LTspice schematics whose transfer functions filter LT/RT or decoded channels.
+	ASCFilters []ASCFilter `json:"asc_filters,omitempty"`
+
+	// filters are the loaded ASCFilters
+	filters []ChannelFilter
+}
+
+// presets are the named decoding parameter sets, selected with -preset.
+var presets = map[string]Config{
+	"sq-cbs-standard": {
+		Matrix:    "SQ",
+		SQ:        SQCoefficients{Alpha: 1 / math.Sqrt(2)},
+		QS:        QSCoefficients{Alpha: 0.924, Beta: 0.383},
+		LFE:       LFEParameters{GainDB: -10, CutoffHz: 150, Filter: "continuous"},
+		Align:     AlignParameters{MaxDelayMS: 0.1},
+		Analog:    defaultAnalog,
+		Engine:    "fft",
+		Hilbert:   HilbertParameters{Taps: 1023, Window: "blackman"},
+		Normalize: defaultGainStaging,
+		Limiter:   defaultLimiter,
+	},
+	"sq-wide-blend": {
+		Matrix:    "SQ",
+		SQ:        SQCoefficients{Alpha: 1 / math.Sqrt(2), Blend: 0.3},
+		QS:        QSCoefficients{Alpha: 0.924, Beta: 0.383},
+		LFE:       LFEParameters{GainDB: -10, CutoffHz: 150, Filter: "continuous"},
+		Align:     AlignParameters{MaxDelayMS: 0.1},
+		Analog:    defaultAnalog,
+		Engine:    "fft",
+		Hilbert:   HilbertParameters{Taps: 1023, Window: "blackman"},
+		Normalize: defaultGainStaging,
+		Limiter:   defaultLimiter,
+	},
+	"qs-sansui": {
+		Matrix:    "QS",
+		SQ:        SQCoefficients{Alpha: 1 / math.Sqrt(2)},
+		QS:        QSCoefficients{Alpha: 0.924, Beta: 0.383},
+		LFE:       LFEParameters{GainDB: -10, CutoffHz: 150, Filter: "continuous"},
+		Align:     AlignParameters{MaxDelayMS: 0.1},
+		Analog:    defaultAnalog,
+		Engine:    "fft",
+		Hilbert:   HilbertParameters{Taps: 1023, Window: "blackman"},
+		Normalize: defaultGainStaging,
+		Limiter:   defaultLimiter,
+	},
+}
+
+const defaultPreset = "sq-cbs-standard"
+
+// presetNames returns the sorted names of the presets, for help messages.
+func presetNames() string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// loadPreset returns a copy of a named preset.
+func loadPreset(name string) (Config, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown preset %q (available: %s)", name, presetNames())
+	}
+	cfg := preset
+	cfg.Preset = name
+	cfg.Gains = map[string]float64{}
+	for channel, gain := range preset.Gains {
+		cfg.Gains[channel] = gain
+	}
+	// the config file must not write into the preset slices
+	cfg.Analog.ReferencePolesHz = append([]float64{}, preset.Analog.ReferencePolesHz...)
+	cfg.Analog.QuadraturePolesHz = append([]float64{}, preset.Analog.QuadraturePolesHz...)
+	return cfg, nil
+}
+
+// loadConfig builds the configuration from a preset name and a JSON config file, both optional.
+// The file is applied over its own "preset" entry if any, else over the given preset.
+func loadConfig(presetName string, path string) (Config, error) {
+	if presetName == "" {
+		presetName = defaultPreset
+	}
+
+	var data []byte
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("error reading config file: %w", err)
+		}
+		var base struct {
+			Preset string `json:"preset"`
+		}
+		if err := json.Unmarshal(data, &base); err != nil {
+			return Config{}, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+		if base.Preset != "" {
+			presetName = base.Preset
+		}
+	}
+
+	cfg, err := loadPreset(presetName)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if data != nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	}
+	if cfg.Analog, err = cfg.Analog.withNetwork(); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+	cfg.filters, err = loadASCFilters(cfg.ASCFilters)
+	return cfg, err
+}
+
+func (cfg Config) validate() error {
+	switch cfg.Matrix {
+	case "SQ", "QS":
+	case "custom":
+		if cfg.CustomMatrix == nil {
+			return fmt.Errorf("matrix custom needs a custom_matrix in the config file")
+		}
+		if err := cfg.CustomMatrix.validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("matrix must be SQ, QS or custom, not %q", cfg.Matrix)
+	}
+	switch cfg.AudioFormat {
+	case "", "4.0", "5.1", "stems":
+	default:
+		return fmt.Errorf("audioformat must be 4.0, 5.1 or stems, not %q", cfg.AudioFormat)
+	}
+	switch cfg.LFE.Filter {
+	case "continuous", "rectangular":
+	default:
+		return fmt.Errorf("lfe filter must be continuous or rectangular, not %q", cfg.LFE.Filter)
+	}
+	// the upper limit is the Nyquist frequency, checked by validateRate once the rate is known
+	if !(cfg.LFE.CutoffHz > 0) || math.IsInf(cfg.LFE.CutoffHz, 1) {
+		return fmt.Errorf("lfe cutoff must be above 0 Hz, not %g", cfg.LFE.CutoffHz)
+	}
+	switch cfg.Engine {
+	case "fft":
+	case "fir":
+		if err := cfg.Hilbert.validate(); err != nil {
+			return err
+		}
+		if cfg.Analog.Enabled {
+			return fmt.Errorf("analog mode needs the fft engine")
+		}
+		// the LFE low-pass of the fir engine is a Butterworth biquad, there is no brick wall filter
+		if cfg.LFE.Filter == "rectangular" {
+			return fmt.Errorf("lfe filter rectangular needs the fft engine")
+		}
+	default:
+		return fmt.Errorf("engine must be fft or fir, not %q", cfg.Engine)
+	}
+	if cfg.Align.Enabled {
+		if err := cfg.Align.validate(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.Normalize.validate(); err != nil {
+		return err
+	}
+	if cfg.Limiter.Enabled || cfg.Normalize.Mode == "loudness" {
+		if err := cfg.Limiter.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.Analog.Enabled {
+		for _, p := range append(append([]float64{}, cfg.Analog.ReferencePolesHz...), cfg.Analog.QuadraturePolesHz...) {
+			if p <= 0 {
+				return fmt.Errorf("analog pole frequencies must be positive, not %g", p)
+			}
+		}
+	}
+	for _, f := range cfg.ASCFilters {
+		if cfg.Engine == "fir" {
+			return fmt.Errorf("LTspice filters are frequency domain filters, they need the fft engine")
+		}
+		if f.File == "" || len(f.Channels) == 0 {
+			return fmt.Errorf("LTspice filters need a file and channels")
+		}
+		for _, channel := range f.Channels {
+			if channel == "LT" || channel == "RT" || isChannelName(channel) {
+				continue
+			}
+			if _, ok := decodingMatrix(cfg).row(channel); !ok {
+				return fmt.Errorf("unknown channel %q for LTspice filter %s (LT, RT or a decoded channel)", channel, f.File)
+			}
+		}
+	}
+	for channel := range cfg.Gains {
+		if !isChannelName(channel) {
+			if _, ok := decodingMatrix(cfg).row(channel); !ok {
+				return fmt.Errorf("unknown channel %q in gains (channels are LF, RF, LB, RB, C, LFE or the custom matrix outputs)", channel)
+			}
+		}
+	}
+	return nil
+}
+
+func isChannelName(name string) bool {
+	switch name {
+	case "LF", "RF", "LB", "RB", "C", "LFE":
+		return true
+	}
+	return false
+}
+
+// parseGains parses gain trims given on the command line, like "LB=+1.5,RB=+1.5,LFE=-3".
+func parseGains(s string) (map[string]float64, error) {
+	gains := map[string]float64{}
+	if s == "" {
+		return gains, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		channel, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("gain %q must be CHANNEL=dB", item)
+		}
+		gain, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("gain %q: %w", item, err)
+		}
+		gains[strings.ToUpper(channel)] = gain
+	}
+	return gains, nil
+}
+
+// decodeFlags are the command line flags which override the configuration.
+type decodeFlags struct {
+	preset       string
+	config       string
+	matrixformat string
+	audioformat  string
+	alpha        float64
+	blend        float64
+	qsAlpha      float64
+	qsBeta       float64
+	lfeGain      float64
+	lfeCutoff    float64
+	lfeFilter    string
+	gains        string
+	normalize    string
+	peakDBFS     float64
+	targetLUFS   float64
+	truePeak     float64
+	limiter      bool
+	ceiling      float64
+	attack       float64
+	release      float64
+	analog       bool
+	analogNet    string
+	align        bool
+	alignMaxMS   float64
+	engine       string
+	hilbertTaps  int
+	hilbertWin   string
+	asc          string
+	ascNode      string
+	ascChannels  string
+}
+
+func (f *decodeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.preset, "preset", "", "is optional : named decoding preset ("+presetNames()+"), default "+defaultPreset)
+	fs.StringVar(&f.config, "config", "", "is optional : JSON config file with the decoding parameters")
+	fs.StringVar(&f.audioformat, "audioformat", "", "is optional : value must be 4.0, 5.1 (experimental) or stems (one mono file per channel)")
+	fs.StringVar(&f.matrixformat, "matrixformat", "", "is optional : value must be SQ, QS or custom (custom_matrix of the config file), in any case")
+	fs.Float64Var(&f.alpha, "alpha", 0, "is optional : SQ back channels coefficient (default 1/SQR(2))")
+	fs.Float64Var(&f.blend, "blend", 0, "is optional : SQ back channels blend (0 to 1)")
+	fs.Float64Var(&f.qsAlpha, "qs-alpha", 0, "is optional : QS main coefficient (default 0.924)")
+	fs.Float64Var(&f.qsBeta, "qs-beta", 0, "is optional : QS cross coefficient (default 0.383)")
+	fs.Float64Var(&f.lfeGain, "lfe-gain", 0, "is optional : LFE level in dB (default -10)")
+	fs.Float64Var(&f.lfeCutoff, "lfe-cutoff", 0, "is optional : LFE low-pass cut-off in Hz, below half the sample rate (default 150)")
+	fs.StringVar(&f.lfeFilter, "lfe-filter", "", "is optional : LFE low-pass filter, continuous or rectangular")
+	fs.StringVar(&f.gains, "gain", "", "is optional : gain trims in dB, for example LB=+1.5,RB=+1.5,LFE=-3")
+	fs.StringVar(&f.normalize, "normalize", "", "is optional : gain staging after the trims, none (levels as encoded), peak (one gain for all the channels, default) or pairs (front and back pairs apart, as the first versions)")
+	fs.Float64Var(&f.peakDBFS, "peak-dbfs", 0, "is optional : level of the highest peak with -normalize peak, in dBFS (default 0)")
+	fs.Float64Var(&f.targetLUFS, "target-lufs", 0, "is optional : integrated loudness with -normalize loudness, in LUFS (default -23)")
+	fs.Float64Var(&f.truePeak, "true-peak", 0, "is optional : true peak ceiling of the limiter with -normalize loudness, in dBTP (default -1)")
+	fs.BoolVar(&f.limiter, "limiter", false, "is optional : limit the true peaks of the decoded channels after the gain staging, one gain for all the channels")
+	fs.Float64Var(&f.ceiling, "limiter-ceiling", 0, "is optional : true peak ceiling of the limiter, in dBTP (default -1)")
+	fs.Float64Var(&f.attack, "limiter-attack", 0, "is optional : attack (look-ahead) of the limiter, in ms (default 5)")
+	fs.Float64Var(&f.release, "limiter-release", 0, "is optional : release of the limiter, in ms (default 100)")
+	fs.BoolVar(&f.align, "align", false, "is optional : measure the delay between LT and RT (azimuth error) and correct it before decoding")
+	fs.Float64Var(&f.alignMaxMS, "align-max-ms", 0, "is optional : largest LT/RT delay searched by -align, in ms (default 0.1)")
+	fs.BoolVar(&f.analog, "analog", false, "is optional : emulate the all-pass phase-shift networks of an analog decoder instead of an ideal j")
+	fs.StringVar(&f.analogNet, "analog-network", "", "is optional : phase-shift network of -analog, "+analogNetworkNames()+" (default fitted)")
+	fs.StringVar(&f.engine, "engine", "", "is optional : fft (default) or fir (time domain Hilbert transformers)")
+	fs.IntVar(&f.hilbertTaps, "hilbert-taps", 0, "is optional : length of the FIR Hilbert transformers, odd (default 1023)")
+	fs.StringVar(&f.hilbertWin, "hilbert-window", "", "is optional : window of the FIR Hilbert transformers, blackman, hamming, hann or rectangular")
+	fs.StringVar(&f.asc, "asc", "", "is optional : LTspice .asc schematic used as a filter")
+	fs.StringVar(&f.ascNode, "asc-node", "", "is optional : output net of the LTspice schematic (default the flag named out)")
+	fs.StringVar(&f.ascChannels, "asc-channels", "LT,RT", "is optional : channels filtered by the LTspice schematic, LT and RT before decoding or decoded channels")
+}
+
+// resolve loads the preset and the config file, then applies the flags set on the command line.
+func (f *decodeFlags) resolve(fs *flag.FlagSet) (Config, error) {
+	cfg, err := loadConfig(f.preset, f.config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var gainErr error
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "matrixformat":
+			cfg.Matrix = f.matrixformat
+		case "audioformat":
+			cfg.AudioFormat = f.audioformat
+		case "alpha":
+			cfg.SQ.Alpha = f.alpha
+		case "blend":
+			cfg.SQ.Blend = f.blend
+		case "qs-alpha":
+			cfg.QS.Alpha = f.qsAlpha
+		case "qs-beta":
+			cfg.QS.Beta = f.qsBeta
+		case "lfe-gain":
+			cfg.LFE.GainDB = f.lfeGain
+		case "lfe-cutoff":
+			cfg.LFE.CutoffHz = f.lfeCutoff
+		case "lfe-filter":
+			cfg.LFE.Filter = f.lfeFilter
+		case "analog":
+			cfg.Analog.Enabled = f.analog
+		case "analog-network":
+			cfg.Analog.Network = f.analogNet
+		case "align":
+			cfg.Align.Enabled = f.align
+		case "align-max-ms":
+			cfg.Align.MaxDelayMS = f.alignMaxMS
+		case "engine":
+			cfg.Engine = f.engine
+		case "hilbert-taps":
+			cfg.Hilbert.Taps = f.hilbertTaps
+		case "hilbert-window":
+			cfg.Hilbert.Window = f.hilbertWin
+		case "asc":
+			cfg.ASCFilters = append(cfg.ASCFilters, ASCFilter{File: f.asc, Node: f.ascNode, Channels: strings.Split(strings.ToUpper(f.ascChannels), ",")})
+		case "normalize":
+			cfg.Normalize.Mode = strings.ToLower(f.normalize)
+		case "peak-dbfs":
+			cfg.Normalize.PeakDBFS = f.peakDBFS
+		case "target-lufs":
+			cfg.Normalize.TargetLUFS = f.targetLUFS
+		case "true-peak":
+			cfg.Normalize.TruePeakDBTP = f.truePeak
+		case "limiter":
+			cfg.Limiter.Enabled = f.limiter
+		case "limiter-ceiling":
+			cfg.Limiter.CeilingDBTP = f.ceiling
+		case "limiter-attack":
+			cfg.Limiter.AttackMS = f.attack
+		case "limiter-release":
+			cfg.Limiter.ReleaseMS = f.release
+		case "gain":
+			var gains map[string]float64
+			gains, gainErr = parseGains(f.gains)
+			for channel, gain := range gains {
+				cfg.Gains[channel] = gain
+			}
+		}
+	})
+	if gainErr != nil {
+		return Config{}, gainErr
+	}
+	if cfg.Analog, err = cfg.Analog.withNetwork(); err != nil {
+		return Config{}, err
+	}
+
+	// the matrix in any case, SQ when empty as the old command line : validate rejects the others
+	switch strings.ToLower(cfg.Matrix) {
+	case "", "sq":
+		cfg.Matrix = "SQ"
+	case "qs":
+		cfg.Matrix = "QS"
+	case "custom":
+		cfg.Matrix = "custom"
+	}
+	// the old command line accepted any value as 4.0
+
+	if cfg.AudioFormat != "" && cfg.AudioFormat != "5.1" && cfg.AudioFormat != "stems" {
+		cfg.AudioFormat = "4.0"
+	}
+
+	return cfg, cfg.validate()
+}
+
+// validateRate checks the parameters which depend on the sample rate of the decoding : the LFE
+// cut-off below half the sample rate (the low-pass of the fir engine is unstable above).
+func (cfg Config) validateRate(sampleRate int) error {
+	if nyquist := float64(sampleRate) / 2; cfg.LFE.CutoffHz >= nyquist {
+		return fmt.Errorf("lfe cutoff must be below half the sample rate (%g Hz), not %g", nyquist, cfg.LFE.CutoffHz)
+	}
+	return nil
+}
+
+// decodeOptions returns the options of DecodeMatrix for a configuration.
+func (cfg Config) decodeOptions(sampleRate int) DecodeOptions {
+	return DecodeOptions{SampleRate: sampleRate, LFE: cfg.LFE, Analog: cfg.Analog, Filters: cfg.filters, Trims: cfg.Gains, Gain: cfg.Normalize, Limiter: cfg.Limiter}
+}
+
+// summary returns the decoding parameters on one line, as written in the metadata of the
+// decoded files : matrix=SQ layout=4.0 alpha=0.7071 blend=0 engine=fft ...
+func (cfg Config) summary() string {
+	layout := cfg.AudioFormat
+	if layout == "" {
+		layout = "front/back"
+	}
+	parts := []string{"matrix=" + cfg.Matrix, "layout=" + layout}
+	switch cfg.Matrix {
+	case "SQ":
+		parts = append(parts, fmt.Sprintf("alpha=%.4g", cfg.SQ.Alpha), fmt.Sprintf("blend=%.4g", cfg.SQ.Blend))
+	case "QS":
+		parts = append(parts, fmt.Sprintf("alpha=%.4g", cfg.QS.Alpha), fmt.Sprintf("beta=%.4g", cfg.QS.Beta))
+	case "custom":
+		for _, row := range cfg.CustomMatrix.Rows {
+			parts = append(parts, fmt.Sprintf("%s=%.4g*LT%+.4g*RT", row.Channel, complex128(row.LT), complex128(row.RT)))
+		}
+	}
+	if cfg.AudioFormat == "5.1" || cfg.AudioFormat == "stems" {
+		parts = append(parts, fmt.Sprintf("lfe=%gdB/%gHz/%s", cfg.LFE.GainDB, cfg.LFE.CutoffHz, cfg.LFE.Filter))
+	}
+	parts = append(parts, "engine="+cfg.Engine)
+	if cfg.Engine == "fir" {
+		parts = append(parts, fmt.Sprintf("taps=%d", cfg.Hilbert.Taps), "window="+cfg.Hilbert.Window)
+	}
+	if cfg.Align.Enabled {
+		parts = append(parts, fmt.Sprintf("align=%gms", cfg.Align.MaxDelayMS))
+	}
+	if cfg.Analog.Enabled && cfg.Analog.Network != "" {
+		parts = append(parts, "analog="+cfg.Analog.Network)
+	} else if cfg.Analog.Enabled {
+		parts = append(parts, "analog")
+	}
+	parts = append(parts, "normalize="+cfg.Normalize.String())
+	if cfg.Limiter.Enabled {
+		parts = append(parts, fmt.Sprintf("limiter=%gdBTP/%gms/%gms", cfg.Limiter.CeilingDBTP, cfg.Limiter.AttackMS, cfg.Limiter.ReleaseMS))
+	}
+	var gains []string
+	for name, gainDB := range cfg.Gains {
+		if gainDB != 0 {
+			gains = append(gains, fmt.Sprintf("%s%+gdB", name, gainDB))
+		}
+	}
+	sort.Strings(gains)
+	if len(gains) > 0 {
+		parts = append(parts, "gains="+strings.Join(gains, ","))
+	}
+	if len(cfg.ASCFilters) > 0 {
+		parts = append(parts, fmt.Sprintf("asc-filters=%d", len(cfg.ASCFilters)))
+	}
+	if cfg.Preset != "" {
+		parts = append(parts, "preset="+cfg.Preset)
+	}
+	return strings.Join(parts, " ")
+}
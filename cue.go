@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Cue points : the track markers of a needle drop, in the cue chunk of the wave files (with
+// their labels in the labl sub-chunks of a LIST/adtl chunk), in the MARK chunk of the AIFF files
+// and in the CUESHEET block of the FLAC files (which has no labels). Decoding keeps the sample
+// rate and the number of frames : the cue points are written at the same frames in the outputs,
+// and -split-tracks cuts the outputs into one file per track.
+
+// Cue is a marker at a frame of the audio.
+type Cue struct {
+	ID       uint32
+	Position int64 // frame
+	Label    string
+}
+
+// sortCues sorts the cue points by position.
+func sortCues(cues []Cue) {
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Position < cues[j].Position })
+}
+
+// parseCueChunk returns the cue points of a cue chunk : a count, then 24 bytes per point (id,
+// play order position, chunk id, chunk start, block start, sample offset in the data chunk).
+func parseCueChunk(data []byte) []Cue {
+	if len(data) < 4 {
+		return nil
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var cues []Cue
+	for i := uint32(0); i < count && len(data) >= 24; i++ {
+		cues = append(cues, Cue{
+			ID:       binary.LittleEndian.Uint32(data[0:4]),
+			Position: int64(binary.LittleEndian.Uint32(data[20:24])),
+		})
+		data = data[24:]
+	}
+	sortCues(cues)
+	return cues
+}
+
+// parseLabels adds the labl sub-chunks of a LIST chunk of type adtl to labels, by cue id.
+func parseLabels(data []byte, labels map[uint32]string) {
+	if len(data) < 4 || string(data[0:4]) != "adtl" {
+		return
+	}
+	data = data[4:]
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			size = len(data) - 8
+		}
+		if id == "labl" && size >= 4 {
+			labels[binary.LittleEndian.Uint32(data[8:12])] = strings.TrimRight(string(data[12:8+size]), "\x00")
+		}
+		data = data[min(len(data), 8+size+size%2):]
+	}
+}
+
+// writeCueChunks appends the cue chunk and the LIST/adtl chunk of the labels to b.
+func (m Metadata) writeCueChunks(b *bytes.Buffer) {
+	var cue, adtl bytes.Buffer
+	var count uint32
+	adtl.WriteString("adtl")
+	for _, c := range m.Cues {
+		// the positions of the cue chunk are 32 bits
+		if c.Position > math.MaxUint32 {
+			continue
+		}
+		count++
+		binary.Write(&cue, binary.LittleEndian, c.ID)
+		binary.Write(&cue, binary.LittleEndian, uint32(c.Position))
+		cue.WriteString("data")
+		cue.Write(make([]byte, 8)) // chunk start and block start : the data chunk of a PCM file
+		binary.Write(&cue, binary.LittleEndian, uint32(c.Position))
+		if c.Label != "" {
+			label := binary.LittleEndian.AppendUint32(nil, c.ID)
+			writeChunk(&adtl, binary.LittleEndian, "labl", append(append(label, c.Label...), 0))
+		}
+	}
+	if count == 0 {
+		return
+	}
+	writeChunk(b, binary.LittleEndian, "cue ", append(binary.LittleEndian.AppendUint32(nil, count), cue.Bytes()...))
+	if adtl.Len() > 4 {
+		writeChunk(b, binary.LittleEndian, "LIST", adtl.Bytes())
+	}
+}
+
+// parseMarkChunk returns the markers of an AIFF MARK chunk : a count, then for each marker its
+// id (16 bits), its position in frames and its name as a pascal string padded to an even size.
+func parseMarkChunk(data []byte) []Cue {
+	if len(data) < 2 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	var cues []Cue
+	for i := 0; i < count && len(data) >= 7; i++ {
+		n := int(data[6])
+		if len(data) < 7+n {
+			break
+		}
+		cues = append(cues, Cue{
+			ID:       uint32(binary.BigEndian.Uint16(data[0:2])),
+			Position: int64(binary.BigEndian.Uint32(data[2:6])),
+			Label:    string(data[7 : 7+n]),
+		})
+		data = data[min(len(data), 7+n+(n+1)%2):]
+	}
+	sortCues(cues)
+	return cues
+}
+
+// markChunk returns the MARK chunk of the cue points, numbered from 1 (the AIFF ids are positive
+// 16 bits numbers). The names are cut to 255 bytes.
+func (m Metadata) markChunk() []byte {
+	var b bytes.Buffer
+	var markers []Cue
+	for _, c := range m.Cues {
+		if c.Position <= math.MaxUint32 && len(markers) < math.MaxInt16 {
+			markers = append(markers, c)
+		}
+	}
+	binary.Write(&b, binary.BigEndian, uint16(len(markers)))
+	for i, c := range markers {
+		name := c.Label[:min(len(c.Label), 255)]
+		binary.Write(&b, binary.BigEndian, uint16(i+1))
+		binary.Write(&b, binary.BigEndian, uint32(c.Position))
+		b.WriteByte(byte(len(name)))
+		b.WriteString(name)
+		if len(name)%2 == 0 {
+			b.WriteByte(0)
+		}
+	}
+	return b.Bytes()
+}
+
+// flacLeadOut is the number of the lead-out track of the cue sheets which are not CD ones
+// (170 on a CD).
+const flacLeadOut = 255
+
+// parseCuesheet returns the tracks of a FLAC CUESHEET block as cue points, at their index 1 (the
+// start of the track after its pregap) or at their first index. The lead-out track is not a cue point.
+func parseCuesheet(block []byte) []Cue {
+	// media catalog number, lead-in samples, CD flag and reserved bits, number of tracks
+	if len(block) < 396 {
+		return nil
+	}
+	count := int(block[395])
+	block = block[396:]
+	var cues []Cue
+	for i := 0; i < count && len(block) >= 36; i++ {
+		offset := binary.BigEndian.Uint64(block[0:8])
+		number := block[8]
+		points := int(block[35])
+		block = block[36:]
+		if len(block) < 12*points {
+			break
+		}
+		var index uint64
+		for p := 0; p < points; p++ {
+			if p == 0 || block[12*p+8] == 1 {
+				index = binary.BigEndian.Uint64(block[12*p : 12*p+8])
+			}
+		}
+		block = block[12*points:]
+		if number == flacLeadOut || number == 170 || offset+index > math.MaxInt64 {
+			continue
+		}
+		cues = append(cues, Cue{ID: uint32(number), Position: int64(offset + index)})
+	}
+	sortCues(cues)
+	return cues
+}
+
+// cuesheetBlock returns a CUESHEET block (not a CD one) with a track per cue point, numbered
+// from 1, and the lead-out track at frames.
+func cuesheetBlock(cues []Cue, frames int64) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, 128+8+259)) // media catalog number, lead-in, not a CD
+	cues = cues[:min(len(cues), flacLeadOut-1)]
+	b.WriteByte(byte(len(cues) + 1))
+	track := func(offset int64, number int, points int) {
+		binary.Write(&b, binary.BigEndian, uint64(offset))
+		b.WriteByte(byte(number))
+		b.Write(make([]byte, 12+14)) // ISRC, audio track without pre-emphasis
+		b.WriteByte(byte(points))
+	}
+	for i, c := range cues {
+		track(c.Position, i+1, 1)
+		// index point 1 at the start of the track
+		b.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0})
+	}
+	track(frames, flacLeadOut, 0)
+	return b.Bytes()
+}
+
+// clipCues returns the metadata without the cue points after the last frame.
+func (m Metadata) clipCues(frames int64) Metadata {
+	var cues []Cue
+	for _, c := range m.Cues {
+		if c.Position <= frames {
+			cues = append(cues, c)
+		}
+	}
+	m.Cues = cues
+	return m
+}
+
+// trackBounds returns the first frame of each track and the end of the audio : the tracks start
+// at the cue points, and at 0 when the first cue point is later.
+func trackBounds(cues []Cue, frames int64) []int64 {
+	bounds := []int64{0}
+	for _, c := range cues {
+		if c.Position > bounds[len(bounds)-1] && c.Position < frames {
+			bounds = append(bounds, c.Position)
+		}
+	}
+	return append(bounds, frames)
+}
+
+// trackMetadata returns the metadata of the track number n starting at frame start : its label
+// is the title (the title of the side becomes the album when there is none), the bext time
+// reference is moved to the start of the track and there is no cue point.
+func (m Metadata) trackMetadata(n int, start int64, label string) Metadata {
+	track := m
+	track.Cues = nil
+	track.Tags = append([]Tag(nil), m.Tags...)
+	track.Set("TRACKNUMBER", fmt.Sprint(n))
+	if label != "" {
+		title, ok := track.Get("TITLE")
+		if _, album := track.Get("ALBUM"); ok && !album {
+			track.Set("ALBUM", title)
+		}
+		track.Set("TITLE", label)
+	}
+	// bext : the time reference (frames since midnight) is a 64 bits number at byte 338
+	if len(m.Bext) >= 346 {
+		track.Bext = append([]byte(nil), m.Bext...)
+		reference := binary.LittleEndian.Uint64(track.Bext[338:346])
+		binary.LittleEndian.PutUint64(track.Bext[338:346], reference+uint64(start))
+	}
+	return track
+}
+
+// writeTracks writes one file per track, cut at the cue points : name_01.wav, name_02.wav...
+func writeTracks(s string, sampleRate int, names []string, channels [][]float64, opts OutputOptions) error {
+	frames := int64(len(channels[0]))
+	bounds := trackBounds(opts.Metadata.Cues, frames)
+	labels := map[int64]string{}
+	for _, c := range opts.Metadata.Cues {
+		if _, ok := labels[c.Position]; !ok {
+			labels[c.Position] = c.Label
+		}
+	}
+
+	ext := filepath.Ext(s)
+	split := opts
+	split.SplitTracks = false
+	// the loudness of each track is its own
+	split.Loudness = nil
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		track := make([][]float64, len(channels))
+		for c := range channels {
+			track[c] = channels[c][start:end]
+		}
+		split.Metadata = opts.Metadata.trackMetadata(i+1, start, labels[start])
+		name := fmt.Sprintf("%s_%02d%s", strings.TrimSuffix(s, ext), i+1, ext)
+		log.Info("Write track...", "track", i+1, "label", labels[start], "start", start, "frames", end-start, "ouput", name)
+		if err := writeAudio(name, sampleRate, names, track, split); err != nil {
+			return fmt.Errorf("track %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// cueChunk returns a cue chunk content with a point per id and position.
+func cueChunk(points ...[2]uint32) string {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(points)))
+	for _, p := range points {
+		b = binary.LittleEndian.AppendUint32(b, p[0])
+		b = binary.LittleEndian.AppendUint32(b, p[1])
+		b = append(b, "data\x00\x00\x00\x00\x00\x00\x00\x00"...)
+		b = binary.LittleEndian.AppendUint32(b, p[1])
+	}
+	return string(b)
+}
+
+// cuedWave returns a stereo wave file with cue points at 3*scale (label "Song, part 2"), scale
+// ("Song") and 8*scale, and a bext chunk whose time reference is 1000.
+func cuedWave(data string, scale uint32) []byte {
+	bext := []byte(bextChunk("side A", ""))
+	binary.LittleEndian.PutUint64(bext[338:346], 1000)
+	return riffFile(
+		[2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)},
+		[2]string{"data", data},
+		[2]string{"bext", string(bext)},
+		[2]string{"cue ", cueChunk([2]uint32{7, 3 * scale}, [2]uint32{3, scale}, [2]uint32{9, 8 * scale})},
+		[2]string{"LIST", "adtllabl\x09\x00\x00\x00\x03\x00\x00\x00Song\x00\x00labl\x11\x00\x00\x00\x07\x00\x00\x00Song, part 2\x00\x00note\x06\x00\x00\x00\x03\x00\x00\x00a\x00"},
+		[2]string{"LIST", "INFOINAM\x07\x00\x00\x00Side A\x00\x00"},
+	)
+}
+
+func TestReadCues(t *testing.T) {
+	m, err := readWaveMetadata(bytes.NewReader(cuedWave(samples(int16(1), int16(2)), 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cue{{3, 10, "Song"}, {7, 30, "Song, part 2"}, {9, 80, ""}}
+	if !slices.Equal(m.Cues, want) {
+		t.Errorf("cues = %v, want %v", m.Cues, want)
+	}
+	if title, _ := m.Get("TITLE"); title != "Side A" {
+		t.Errorf("tags = %q", m.Tags)
+	}
+}
+
+func TestWriteCues(t *testing.T) {
+	dir := t.TempDir()
+	meta := Metadata{Cues: []Cue{{3, 10, "Song"}, {7, 30, "Song, part 2"}, {9, 80, ""}, {12, 101, "after the end"}}}
+	tests := []struct {
+		format string
+		want   []Cue
+	}{
+		{"wav", []Cue{{3, 10, "Song"}, {7, 30, "Song, part 2"}, {9, 80, ""}}},
+		{"aiff", []Cue{{1, 10, "Song"}, {2, 30, "Song, part 2"}, {3, 80, ""}}},
+		{"aifc", []Cue{{1, 10, "Song"}, {2, 30, "Song, part 2"}, {3, 80, ""}}},
+		{"flac", []Cue{{1, 10, ""}, {2, 30, ""}, {3, 80, ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			opts := OutputOptions{Format: tt.format, Metadata: meta}
+			path := filepath.Join(dir, "cues"+opts.extension())
+			if err := writeAudio(path, 44100, []string{"LF", "RF", "LB", "RB"}, constantChannels(4, 100), opts); err != nil {
+				t.Fatal(err)
+			}
+			info, channels, err := readAudio(path)
+			if err != nil || info.Frames != 100 || len(channels) != 4 {
+				t.Fatalf("audio: %+v, %v", info, err)
+			}
+			m, err := readMetadata(path, InputOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(m.Cues, tt.want) {
+				t.Errorf("cues = %v, want %v", m.Cues, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		cues []Cue
+		want []int64
+	}{
+		{"no cue", nil, []int64{0, 100}},
+		{"first track at 0", []Cue{{Position: 0}, {Position: 40}}, []int64{0, 40, 100}},
+		{"lead-in before the first cue", []Cue{{Position: 10}, {Position: 40}}, []int64{0, 10, 40, 100}},
+		{"same position and end", []Cue{{Position: 40}, {Position: 40}, {Position: 100}}, []int64{0, 40, 100}},
+	}
+	for _, tt := range tests {
+		if got := trackBounds(tt.cues, 100); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: bounds = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestDecodeCues checks that the decoded files get the cue points of the input at the same
+// frames, and that -split-tracks cuts them there.
+func TestDecodeCues(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "sq_speech_SQ.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := int64(binary.LittleEndian.Uint32(fixture[40:44]) / 4)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "side.wav")
+	if err := os.WriteFile(input, cuedWave(string(fixture[44:]), 1000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inDir(t, dir, func() {
+		if err := runDecode([]string{"-input", input, "-audioformat", "4.0"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	decoded, err := readMetadata(filepath.Join(dir, "side_4_0.wav"), InputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cue{{3, 1000, "Song"}, {7, 3000, "Song, part 2"}, {9, 8000, ""}}
+	if !slices.Equal(decoded.Cues, want) {
+		t.Errorf("decoded cues = %v, want %v", decoded.Cues, want)
+	}
+
+	inDir(t, dir, func() {
+		if err := runDecode([]string{"-input", input, "-audioformat", "4.0", "-split-tracks"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// the lead-in before the first cue point is the first track
+	tracks := []struct {
+		frames    int64
+		title     string
+		reference uint64
+	}{
+		{1000, "Side A", 1000},
+		{2000, "Song", 2000},
+		{5000, "Song, part 2", 4000},
+		{frames - 8000, "Side A", 9000},
+	}
+	for i, track := range tracks {
+		path := filepath.Join(dir, fmt.Sprintf("side_4_0_%02d.wav", i+1))
+		info, err := readWaveInfo(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := readMetadata(path, InputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		title, _ := meta.Get("TITLE")
+		album, _ := meta.Get("ALBUM")
+		number, _ := meta.Get("TRACKNUMBER")
+		if info.Frames != track.frames || title != track.title || number != fmt.Sprint(i+1) || len(meta.Cues) != 0 {
+			t.Errorf("track %d: %d frames, tags %q, cues %v", i+1, info.Frames, meta.Tags, meta.Cues)
+		}
+		if labelled := track.title != "Side A"; labelled != (album == "Side A") {
+			t.Errorf("track %d: album %q", i+1, album)
+		}
+		if reference := binary.LittleEndian.Uint64(meta.Bext[338:346]); reference != track.reference {
+			t.Errorf("track %d: time reference %d, want %d", i+1, reference, track.reference)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "side_4_0_05.wav")); err == nil {
+		t.Errorf("5 tracks written, want 4")
+	}
+}
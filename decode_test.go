@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata/golden")
+
+// goldenStep keeps one frame out of goldenStep in the golden files, the RMS covers all the frames.
+const goldenStep = 64
+
+// goldenOutput is the summary of one written file in a golden file.
+type goldenOutput struct {
+	Channels int       `json:"channels"`
+	Frames   int       `json:"frames"`
+	RMS      []float64 `json:"rms"`
+	Samples  [][]int16 `json:"samples"`
+}
+
+func summarize(samples [][]int16) goldenOutput {
+	out := goldenOutput{Channels: len(samples), Frames: len(samples[0])}
+	for _, channel := range samples {
+		var sum float64
+		var kept []int16
+		for i, v := range channel {
+			sum += float64(v) * float64(v)
+			if i%goldenStep == 0 {
+				kept = append(kept, v)
+			}
+		}
+		out.RMS = append(out.RMS, math.Round(math.Sqrt(sum/float64(len(channel)))*1000)/1000)
+		out.Samples = append(out.Samples, kept)
+	}
+	return out
+}
+
+// inDir runs f in dir, the decode command writes its outputs in the current directory.
+func inDir(t *testing.T, dir string, f func()) {
+	t.Helper()
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(previous)
+	f()
+}
+
+// decodeCase runs the decode command and returns the written files by name.
+func decodeCase(t *testing.T, args []string) map[string]goldenOutput {
+	t.Helper()
+	dir := t.TempDir()
+	inDir(t, dir, func() {
+		if err := runDecode(args); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]goldenOutput{}
+	for _, entry := range entries {
+		header, samples := readPCM16(t, filepath.Join(dir, entry.Name()))
+		sampleRate := int(uint32(header[24]) | uint32(header[25])<<8 | uint32(header[26])<<16 | uint32(header[27])<<24)
+		// the RIFF size counts the metadata chunks, checked by readPCM16
+		if want := expectedHeader(len(samples), sampleRate, len(samples[0])); !bytes.Equal(header[8:], want[8:]) {
+			t.Errorf("%s: header\n got % x\nwant % x", entry.Name(), header, want)
+		}
+		outputs[entry.Name()] = summarize(samples)
+	}
+	return outputs
+}
+
+// compareGolden checks the outputs against the golden file : same files, same number of channels
+// and frames, samples within 2 LSB and RMS within 0.1%.
+func compareGolden(t *testing.T, path string, got map[string]goldenOutput) {
+	t.Helper()
+	if *update {
+		data, err := json.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create the golden files)", err)
+	}
+	var want map[string]goldenOutput
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(m map[string]goldenOutput) string {
+		var list []string
+		for name := range m {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		return strings.Join(list, " ")
+	}
+	if names(got) != names(want) {
+		t.Fatalf("written files %q, want %q", names(got), names(want))
+	}
+
+	for name, w := range want {
+		g := got[name]
+		if g.Channels != w.Channels || g.Frames != w.Frames {
+			t.Errorf("%s: %d channels %d frames, want %d channels %d frames", name, g.Channels, g.Frames, w.Channels, w.Frames)
+			continue
+		}
+		for c := range w.RMS {
+			if math.Abs(g.RMS[c]-w.RMS[c]) > 0.001*w.RMS[c]+0.5 {
+				t.Errorf("%s channel %d: rms %.3f, want %.3f", name, c, g.RMS[c], w.RMS[c])
+			}
+			for i := range w.Samples[c] {
+				if diff := int(g.Samples[c][i]) - int(w.Samples[c][i]); diff > 2 || diff < -2 {
+					t.Errorf("%s channel %d frame %d: %d, want %d", name, c, i*goldenStep, g.Samples[c][i], w.Samples[c][i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeGolden(t *testing.T) {
+	fixtures := []string{"qsdemo2_excerpt.wav", "sq_speech_SQ.wav"}
+	layouts := []struct{ name, audioformat string }{
+		{"frontback", ""},
+		{"4.0", "4.0"},
+		{"5.1", "5.1"},
+		{"stems", "stems"},
+	}
+
+	type decodeTest struct {
+		name string
+		args []string
+	}
+	var tests []decodeTest
+	for _, fixture := range fixtures {
+		input, err := filepath.Abs(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := strings.TrimSuffix(fixture, ".wav")
+		for _, matrix := range []string{"SQ", "QS"} {
+			for _, layout := range layouts {
+				args := []string{"-input", input, "-matrixformat", matrix}
+				if layout.audioformat != "" {
+					args = append(args, "-audioformat", layout.audioformat)
+				}
+				tests = append(tests, decodeTest{fmt.Sprintf("%s_%s_%s", base, matrix, layout.name), args})
+			}
+		}
+		tests = append(tests,
+			decodeTest{base + "_SQ_4.0_fir", []string{"-input", input, "-audioformat", "4.0", "-engine", "fir"}},
+			decodeTest{base + "_SQ_4.0_analog", []string{"-input", input, "-audioformat", "4.0", "-analog"}},
+			decodeTest{base + "_SQ_4.0_blend", []string{"-input", input, "-audioformat", "4.0", "-preset", "sq-wide-blend"}},
+		)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeCase(t, tt.args)
+			compareGolden(t, filepath.Join("testdata", "golden", tt.name+".json"), got)
+		})
+	}
+}
+
+func TestResolveMatrixFormat(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // empty for an error
+	}{
+		{"", "SQ"},
+		{"SQ", "SQ"},
+		{"qs", "QS"},
+		{"Custom", ""}, // custom without custom_matrix
+		{"QSS", ""},
+		{"CD-4", ""},
+	}
+	for _, tt := range tests {
+		var flags decodeFlags
+		fs := newFlagSet("decode", "")
+		flags.register(fs)
+		args := []string{}
+		if tt.value != "" {
+			args = append(args, "-matrixformat", tt.value)
+		}
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := flags.resolve(fs)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("-matrixformat %q: decoded as %s, want an error", tt.value, cfg.Matrix)
+		case tt.want != "" && (err != nil || cfg.Matrix != tt.want):
+			t.Errorf("-matrixformat %q: %q, %v, want %s", tt.value, cfg.Matrix, err, tt.want)
+		}
+	}
+}
+
+func TestResolveEngineLFEFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		ok     bool
+	}{
+		{"continuous", true},
+		{"rectangular", false}, // no brick wall filter in the time domain
+	}
+	for _, tt := range tests {
+		var flags decodeFlags
+		fs := newFlagSet("decode", "")
+		flags.register(fs)
+		if err := fs.Parse([]string{"-audioformat", "5.1", "-engine", "fir", "-lfe-filter", tt.filter}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := flags.resolve(fs); (err == nil) != tt.ok {
+			t.Errorf("-engine fir -lfe-filter %s: error %v, want ok %v", tt.filter, err, tt.ok)
+		}
+	}
+}
+
+func TestResolveLFECutoff(t *testing.T) {
+	tests := []struct {
+		cutoff string
+		ok     bool
+	}{
+		{"150", true},
+		{"0", false},
+		{"-100", false}, // exp(-(f-cutoff)/tau) grows without limit
+		{"NaN", false},
+		{"22050", false}, // at the Nyquist frequency of 44.1 kHz
+		{"30000", false}, // the biquad of the fir engine is unstable
+	}
+	for _, tt := range tests {
+		var flags decodeFlags
+		fs := newFlagSet("decode", "")
+		flags.register(fs)
+		if err := fs.Parse([]string{"-audioformat", "5.1", "-lfe-cutoff", tt.cutoff}); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := flags.resolve(fs)
+		if err == nil {
+			err = cfg.validateRate(44100)
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("-lfe-cutoff %s: error %v, want ok %v", tt.cutoff, err, tt.ok)
+		}
+	}
+}
+
+func TestEncodeMatrixFormat(t *testing.T) {
+	dir := t.TempDir()
+	inDir(t, dir, func() {
+		// raw front and back files, read with -input-format raw as decode reads them
+		stereo := constantChannels(2, 100)
+		for _, name := range []string{"front.raw", "back.raw"} {
+			if err := writeAudio(name, 44100, []string{"LF", "RF"}, stereo, OutputOptions{Format: "raw", Raw: RawFormat{Bits: 16}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tests := []struct {
+			value string
+			want  string // empty for an error
+		}{
+			{"", "front_SQ.wav"},
+			{"qs", "front_QS.wav"},
+			{"Sq", "front_SQ.wav"},
+			{"foo", ""},
+		}
+		for _, tt := range tests {
+			args := []string{"-front", "front.raw", "-back", "back.raw", "-input-format", "raw", "-matrixformat", tt.value}
+			err := runEncode(args)
+			switch {
+			case tt.want == "" && err == nil:
+				t.Errorf("-matrixformat %q: encoded, want an error", tt.value)
+			case tt.want != "" && err != nil:
+				t.Errorf("-matrixformat %q: %v", tt.value, err)
+			case tt.want != "":
+				if _, err := os.Stat(tt.want); err != nil {
+					t.Errorf("-matrixformat %q: %v", tt.value, err)
+				}
+				os.Remove(tt.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// LT/RT alignment : the matrix decoders rely on the phase between LT and RT, and an azimuth error
+// of the cartridge (or of the tape head) delays one channel by a few microseconds, which turns
+// into a phase error growing with the frequency : at 10 kHz, 10 µs are 36°.
+// - the delay is the lag of the highest cross-correlation of LT and RT within ±MaxDelayMS,
+//   averaged over blocks (the cross spectra are summed, one FFT per block), refined below the
+//   sample on the cross spectrum, which gives the band-limited cross-correlation at any lag
+//   (a parabola through the peak and its neighbours is biased towards the nearest sample)
+// - LT is moved by half the delay and RT by the other half, in opposite directions, with a
+//   Kaiser windowed sinc fractional delay : the decoded channels stay centred on the input
+// The in-phase part of the programme (the front center) gives the peak. The quadrature parts (the
+// back channels of SQ) give an odd correlation around zero, whose slopes look like peaks at larger
+// lags : the search range is short (azimuth errors are a few tens of µs), and a peak at its end
+// or a low correlation is not corrected.
+
+// AlignParameters are the parameters of the LT/RT alignment.
+type AlignParameters struct {
+	Enabled bool `json:"enabled"`
+	// MaxDelayMS is the largest delay searched, in ms.
+	MaxDelayMS float64 `json:"max_delay_ms"`
+}
+
+// validate checks the search range.
+func (a AlignParameters) validate() error {
+	if !(a.MaxDelayMS > 0 && a.MaxDelayMS <= 100) {
+		return fmt.Errorf("align max delay must be above 0 and up to 100 ms, not %g", a.MaxDelayMS)
+	}
+	return nil
+}
+
+// delayBlock is the length of the blocks of the cross-correlation.
+const delayBlock = 1 << 15
+
+// minAlignCorrelation is the correlation below which the peak is not trusted : LT and RT
+// unrelated (two mono sources hard left and right) or in quadrature (SQ back channels only).
+const minAlignCorrelation = 0.2
+
+// Delay is the measured delay of LT relative to RT.
+type Delay struct {
+	// Samples is positive when LT is late.
+	Samples float64
+	// Correlation is the normalized cross-correlation at the peak.
+	Correlation float64
+	// Edge is true when the peak is at the end of the search range : not a delay but the slope
+	// of a correlation with no peak.
+	Edge bool
+}
+
+// Microseconds returns the delay in µs.
+func (d Delay) Microseconds(sampleRate int) float64 {
+	return d.Samples / float64(sampleRate) * 1e6
+}
+
+// estimateDelay measures the delay of LT relative to RT, up to maxLag samples.
+func estimateDelay(LT, RT []float64, maxLag int) Delay {
+	n := min(len(LT), len(RT))
+	maxLag = min(maxLag, delayBlock-2)
+	fft := fourier.NewFFT(2 * delayBlock)
+	cross := make([]complex128, delayBlock+1)
+	a, b := make([]float64, 2*delayBlock), make([]float64, 2*delayBlock)
+	var powerLT, powerRT float64
+	for start := 0; start < n; start += delayBlock {
+		end := min(start+delayBlock, n)
+		clear(a)
+		clear(b)
+		copy(a, LT[start:end])
+		copy(b, RT[start:end])
+		for i := range end - start {
+			powerLT += a[i] * a[i]
+			powerRT += b[i] * b[i]
+		}
+		fa := fft.Coefficients(nil, a)
+		fb := fft.Coefficients(nil, b)
+		for k := range cross {
+			cross[k] += fa[k] * cmplx.Conj(fb[k])
+		}
+	}
+	if powerLT == 0 || powerRT == 0 {
+		return Delay{}
+	}
+
+	// c[lag] = sum of LT[i+lag]*RT[i], the negative lags at the end
+	c := fft.Sequence(nil, cross)
+	at := func(lag int) float64 {
+		if lag < 0 {
+			lag += len(c)
+		}
+		return c[lag]
+	}
+	best := 0
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		if at(lag) > at(best) {
+			best = lag
+		}
+	}
+	// the inverse transform is not scaled by 1/N
+	norm := float64(len(c)) * math.Sqrt(powerLT*powerRT)
+	// the cross-correlation at a fractional lag, the inverse transform of the cross spectrum
+	between := func(lag float64) float64 {
+		v := real(cross[0]) + real(cross[delayBlock])*math.Cos(math.Pi*lag)
+		step := cmplx.Rect(1, 2*math.Pi*lag/float64(len(c)))
+		phasor := step
+		for k := 1; k < delayBlock; k++ {
+			v += 2 * real(cross[k]*phasor)
+			phasor *= step
+		}
+		return v
+	}
+	if best == -maxLag || best == maxLag {
+		return Delay{Samples: float64(best), Correlation: between(float64(best)) / norm, Edge: true}
+	}
+	// ternary search of the peak between the neighbours of the best lag
+	low, high := float64(best)-1, float64(best)+1
+	for range 40 {
+		third := (high - low) / 3
+		if between(low+third) < between(high-third) {
+			low += third
+		} else {
+			high -= third
+		}
+	}
+	lag := (low + high) / 2
+	return Delay{Samples: lag, Correlation: between(lag) / norm}
+}
+
+// fractionalDelayTaps is the half length of the fractional delay filter.
+const fractionalDelayTaps = 32
+
+// fractionalDelay returns data delayed by delay samples, which may be negative and not a whole
+// number : out[n] = data(n-delay). The samples outside of data are zero.
+func fractionalDelay(data []float64, delay float64) []float64 {
+	whole := math.Floor(delay)
+	frac := delay - whole
+	// out[n] = sum of h[k]*data[n-whole-fractionalDelayTaps+1+k]
+	h := make([]float64, 2*fractionalDelayTaps)
+	const beta = 9
+	norm := besselI0(beta)
+	var sum float64
+	for k := range h {
+		t := float64(fractionalDelayTaps-1-k) - frac
+		x := t / fractionalDelayTaps
+		if math.Abs(x) >= 1 {
+			continue
+		}
+		h[k] = besselI0(beta*math.Sqrt(1-x*x)) / norm
+		if t != 0 {
+			h[k] *= math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+		sum += h[k]
+	}
+	for k := range h {
+		h[k] /= sum
+	}
+
+	out := make([]float64, len(data))
+	offset := -int(whole) - fractionalDelayTaps + 1
+	for n := range out {
+		var v float64
+		for k, c := range h {
+			if j := n + offset + k; j >= 0 && j < len(data) {
+				v += c * data[j]
+			}
+		}
+		out[n] = v
+	}
+	return out
+}
+
+// alignLTRT measures the delay between LT and RT and corrects it, half on each channel.
+func alignLTRT(LT, RT []float64, a AlignParameters, sampleRate int) ([]float64, []float64, Delay) {
+	d := estimateDelay(LT, RT, int(math.Ceil(a.MaxDelayMS*float64(sampleRate)/1000)))
+	attrs := []any{"delaySamples", fmt.Sprintf("%+.3f", d.Samples), "delayMicroseconds", fmt.Sprintf("%+.1f", d.Microseconds(sampleRate)),
+		"correlation", fmt.Sprintf("%.3f", d.Correlation)}
+	if d.Correlation < minAlignCorrelation {
+		log.Warn("LT/RT delay not corrected, LT and RT are not correlated enough", attrs...)
+		return LT, RT, d
+	}
+	if d.Edge {
+		log.Warn("LT/RT delay not corrected, no peak within the search range (see -align-max-ms)", attrs...)
+		return LT, RT, d
+	}
+	log.Info("LT/RT delay corrected", attrs...)
+	return fractionalDelay(LT, -d.Samples/2), fractionalDelay(RT, d.Samples/2), d
+}
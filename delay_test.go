@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFractionalDelay(t *testing.T) {
+	in := sineTone(1000, 0.5, 44100, 4096)
+	for _, delay := range []float64{0.5, -0.25, 3.7, -12} {
+		out := fractionalDelay(in, delay)
+		var worst float64
+		for n := 100; n < len(in)-100; n++ {
+			want := 0.5 * math.Sin(2*math.Pi*1000*(float64(n)-delay)/44100)
+			worst = max(worst, math.Abs(out[n]-want))
+		}
+		if worst > 1e-4 {
+			t.Errorf("delay %g: error %.2e", delay, worst)
+		}
+	}
+}
+
+func TestEstimateDelay(t *testing.T) {
+	// a mono source, LT late or early by a fraction of a sample (10 µs at 44.1 kHz is 0.44 sample)
+	source := pinkNoise(0.5, 5*44100, 1)
+	for _, delay := range []float64{0, 0.44, -0.3, 2.5, -7.8} {
+		LT, RT := fractionalDelay(source, delay), source
+		d := estimateDelay(LT, RT, 44)
+		if math.Abs(d.Samples-delay) > 0.05 || d.Correlation < 0.9 {
+			t.Errorf("delay %g: measured %.3f samples, correlation %.3f", delay, d.Samples, d.Correlation)
+		}
+
+		LT, RT, _ = alignLTRT(LT, RT, AlignParameters{Enabled: true, MaxDelayMS: 1}, 44100)
+		if residual := estimateDelay(LT, RT, 44); math.Abs(residual.Samples) > 0.05 {
+			t.Errorf("delay %g: %.3f samples left after the alignment", delay, residual.Samples)
+		}
+	}
+
+	// unrelated channels are left alone
+	LT, RT := pinkNoise(0.5, 44100, 1), pinkNoise(0.5, 44100, 2)
+	if alignedLT, _, d := alignLTRT(LT, RT, AlignParameters{Enabled: true, MaxDelayMS: 1}, 44100); &alignedLT[0] != &LT[0] {
+		t.Errorf("unrelated channels aligned, correlation %.3f", d.Correlation)
+	}
+
+	// a peak beyond the search range is not a delay
+	LT = fractionalDelay(source, 10)
+	if alignedLT, _, d := alignLTRT(LT, source, AlignParameters{Enabled: true, MaxDelayMS: 0.1}, 44100); !d.Edge || &alignedLT[0] != &LT[0] {
+		t.Errorf("delay of 10 samples corrected within 0.1 ms : %+v", d)
+	}
+
+	if err := (AlignParameters{Enabled: true}).validate(); err == nil {
+		t.Errorf("no search range accepted")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// EncodeSQ encodes quadriphonic channels into SQ stereo channels (CBS SQ encoding matrix).
+// lf, rf, lb and rb are the left-front, right-front, left-back and right-back input signals.
+// Returns the left-total and right-total signals.
+//
+//	LT = lf - j*alpha*lb + alpha*rb
+//	RT = rf - alpha*lb + j*alpha*rb
+//
+// It is the conjugate transpose of the DecodeSQ matrix, so that DecodeSQ(EncodeSQ(...)) gives back the quad channels.
+func EncodeSQ(lf, rf, lb, rb []float64) ([]float64, []float64) {
+	log.Info("EncodeSQ...")
+	LT, RT := encodeMatrix(lf, rf, lb, rb, encodeSQ)
+	log.Info("EncodeSQ is done.")
+	return LT, RT
+}
+
+// encodeSQ applies the SQ encoding equations to one frequency coefficient of each channel.
+func encodeSQ(LF, RF, LB, RB complex128) (complex128, complex128) {
+	var alpha float64 = 1 / math.Sqrt(2)
+	lt := LF - complex(0, alpha)*LB + complex(alpha, 0)*RB
+	rt := RF - complex(alpha, 0)*LB + complex(0, alpha)*RB
+	return lt, rt
+}
+
+// EncodeQS encodes quadriphonic channels into QS stereo channels (Sansui QS encoding matrix).
+// Returns the left-total and right-total signals.
+//
+//	LT = 0.924*lf + 0.383*rf + j*0.924*lb - j*0.383*rb
+//	RT = 0.383*lf + 0.924*rf - j*0.383*lb + j*0.924*rb
+//
+// It is the conjugate transpose of the DecodeQS matrix.
+func EncodeQS(lf, rf, lb, rb []float64) ([]float64, []float64) {
+	log.Info("EncodeQS...")
+	LT, RT := encodeMatrix(lf, rf, lb, rb, encodeQS)
+	log.Info("EncodeQS is done.")
+	return LT, RT
+}
+
+// encodeQS applies the QS encoding equations to one frequency coefficient of each channel.
+func encodeQS(LF, RF, LB, RB complex128) (complex128, complex128) {
+	var alpha float64 = 0.924
+	var beta float64 = 0.383
+	lt := complex(alpha, 0)*LF + complex(beta, 0)*RF + complex(0, alpha)*LB - complex(0, beta)*RB
+	rt := complex(beta, 0)*LF + complex(alpha, 0)*RF - complex(0, beta)*LB + complex(0, alpha)*RB
+	return lt, rt
+}
+
+// encodeMatrix goes to the frequency domain, applies the encoding equations to each coefficient
+// and goes back to the time domain. The LT/RT pair is normalized.
+func encodeMatrix(lf, rf, lb, rb []float64, encode func(LF, RF, LB, RB complex128) (complex128, complex128)) ([]float64, []float64) {
+	N := len(lf)
+	if len(rf) != N || len(lb) != N || len(rb) != N {
+		log.Error("Input slices lf, rf, lb and rb must have the same length : encoding")
+		panic("Input slices lf, rf, lb and rb must have the same length : encoding")
+	}
+
+	fft := fourier.NewFFT(N)
+	freqLF := fft.Coefficients(nil, lf)
+	freqRF := fft.Coefficients(nil, rf)
+	freqLB := fft.Coefficients(nil, lb)
+	freqRB := fft.Coefficients(nil, rb)
+
+	M := len(freqLF)
+	left := make([]complex128, M)
+	right := make([]complex128, M)
+
+	for i := 0; i < M; i++ {
+		left[i], right[i] = encode(freqLF[i], freqRF[i], freqLB[i], freqRB[i])
+	}
+
+	LT := fft.Sequence(nil, left)
+	RT := fft.Sequence(nil, right)
+
+	// gonum's inverse transform is not scaled by 1/N
+	for i := range LT {
+		LT[i] /= float64(N)
+		RT[i] /= float64(N)
+	}
+
+	normalize(&LT, &RT)
+
+	return LT, RT
+}
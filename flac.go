@@ -0,0 +1,1017 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+)
+
+// FLAC reading and writing, in pure Go (https://xiph.org/flac/format.html).
+// The decoder reads every subframe type (constant, verbatim, fixed and LPC predictors) and the
+// stereo decorrelation modes. The encoder uses the fixed predictors (orders 0 to 4) with Rice
+// coded residuals in partitions, and the best of left/right, left/side, side/right and mid/side
+// for stereo : it is about as good as flac -2.
+
+const flacBlockSize = 4096
+
+// crc8Table and crc16Table are the CRCs of the frame header (polynomial x^8+x^2+x+1) and
+// of the whole frame (x^16+x^15+x^2+1).
+var crc8Table, crc16Table = func() ([256]byte, [256]uint16) {
+	var t8 [256]byte
+	var t16 [256]uint16
+	for i := 0; i < 256; i++ {
+		c8 := byte(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i], t16[i] = c8, c16
+	}
+	return t8, t16
+}()
+
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// flacChannelMasks are the WAVEFORMATEXTENSIBLE speaker masks of the FLAC default channel
+// assignments, by number of channels.
+var flacChannelMasks = map[int]uint32{1: 0x4, 2: 0x3, 3: 0x7, 4: 0x33, 5: 0x37, 6: 0x3F, 7: 0x70F, 8: 0x63F}
+
+// bitReader reads big endian bit fields. The bytes read are kept for the CRCs of the frame.
+type bitReader struct {
+	r     io.ByteReader
+	cache uint64 // bits not read yet, right aligned
+	n     uint   // number of bits in cache
+	frame []byte
+}
+
+func (br *bitReader) readByte() error {
+	b, err := br.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	br.frame = append(br.frame, b)
+	br.cache = br.cache<<8 | uint64(b)
+	br.n += 8
+	return nil
+}
+
+// bits reads an unsigned field of n bits, n up to 32.
+func (br *bitReader) bits(n uint) (uint64, error) {
+	for br.n < n {
+		if err := br.readByte(); err != nil {
+			return 0, err
+		}
+	}
+	br.n -= n
+	v := br.cache >> br.n
+	br.cache &= 1<<br.n - 1
+	return v, nil
+}
+
+// signed reads a two's complement field of n bits.
+func (br *bitReader) signed(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := br.bits(n)
+	return int64(v<<(64-n)) >> (64 - n), err
+}
+
+// unary reads zeros up to a one and returns their count.
+func (br *bitReader) unary() (uint64, error) {
+	var count uint64
+	for {
+		if br.n == 0 {
+			if err := br.readByte(); err != nil {
+				return 0, err
+			}
+		}
+		if br.cache == 0 {
+			count += uint64(br.n)
+			br.n = 0
+			continue
+		}
+		length := uint(bits.Len64(br.cache))
+		count += uint64(br.n - length)
+		br.n = length - 1
+		br.cache &= 1<<br.n - 1
+		return count, nil
+	}
+}
+
+// align skips the bits up to the next byte.
+func (br *bitReader) align() {
+	br.n -= br.n % 8
+	br.cache &= 1<<br.n - 1
+}
+
+// flacStream is the content of the STREAMINFO, VORBIS_COMMENT and CUESHEET metadata blocks.
+type flacStream struct {
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	totalSamples  int64
+	md5           [16]byte
+	tags          []string
+	cues          []Cue
+	id3           []byte // ID3v2 tag before the fLaC marker, header included
+}
+
+// readFLACMetadata reads the fLaC marker and the metadata blocks, up to the first frame.
+// An ID3v2 tag before the marker is kept aside.
+func readFLACMetadata(r *bufio.Reader) (flacStream, error) {
+	var stream flacStream
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker); err != nil {
+		return stream, fmt.Errorf("error reading FLAC marker: %w", err)
+	}
+	if string(marker[0:3]) == "ID3" {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return stream, fmt.Errorf("error reading ID3 tag: %w", err)
+		}
+		size := id3Size(append(marker[:4:4], header...)) - 10
+		body, err := io.ReadAll(io.LimitReader(r, size))
+		if err != nil || int64(len(body)) != size {
+			return stream, fmt.Errorf("error reading ID3 tag: %d bytes of %d, %v", len(body), size, err)
+		}
+		stream.id3 = append(append(marker[:4:4], header...), body...)
+		marker = make([]byte, 4)
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return stream, fmt.Errorf("error reading FLAC marker: %w", err)
+		}
+	}
+	if string(marker) != "fLaC" {
+		return stream, fmt.Errorf("not a FLAC file")
+	}
+
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return stream, fmt.Errorf("error reading FLAC metadata: %w", err)
+		}
+		last = header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return stream, fmt.Errorf("error reading FLAC metadata: %w", err)
+		}
+		switch blockType {
+		case 0:
+			if length < 34 {
+				return stream, fmt.Errorf("FLAC STREAMINFO too short: %d bytes", length)
+			}
+			packed := binary.BigEndian.Uint64(block[10:18])
+			stream.sampleRate = int(packed >> 44)
+			stream.channels = int(packed>>41&0x7) + 1
+			stream.bitsPerSample = int(packed>>36&0x1F) + 1
+			stream.totalSamples = int64(packed & (1<<36 - 1))
+			// 4 to 32 bits per sample, 1 to 3 are reserved (and have no full scale)
+			if stream.bitsPerSample < 4 {
+				return stream, fmt.Errorf("invalid FLAC STREAMINFO: %d bits per sample", stream.bitsPerSample)
+			}
+			copy(stream.md5[:], block[18:34])
+		case 4:
+			stream.tags = parseVorbisComment(block)
+		case 5:
+			stream.cues = parseCuesheet(block)
+		}
+	}
+	if stream.sampleRate == 0 {
+		return stream, fmt.Errorf("no FLAC STREAMINFO")
+	}
+	return stream, nil
+}
+
+// parseVorbisComment returns the NAME=value comments of a VORBIS_COMMENT block (little endian lengths).
+func parseVorbisComment(block []byte) []string {
+	var tags []string
+	next := func() ([]byte, bool) {
+		if len(block) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(block)
+		if uint64(n) > uint64(len(block)-4) {
+			return nil, false
+		}
+		field := block[4 : 4+n]
+		block = block[4+n:]
+		return field, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return nil
+	}
+	if len(block) < 4 {
+		return nil
+	}
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+	for i := uint32(0); i < count; i++ {
+		field, ok := next()
+		if !ok {
+			break
+		}
+		tags = append(tags, string(field))
+	}
+	return tags
+}
+
+// flacSampleRates and flacSampleSizes are the values of the codes of the frame header, 0 for
+// "from STREAMINFO" or for the codes with the value at the end of the header.
+var flacSampleRates = [16]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+var flacSampleSizes = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// decodeFLACFrame decodes one frame into one slice per channel. It returns io.EOF at the end of the stream.
+func decodeFLACFrame(br *bitReader, stream flacStream) ([][]int64, error) {
+	br.frame = br.frame[:0]
+	sync, err := br.bits(15)
+	if err == io.ErrUnexpectedEOF && len(br.frame) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sync != 0x7FFC {
+		if len(br.frame) > 0 && br.frame[0] == 'T' {
+			// ID3v1 tag at the end of the file
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("lost FLAC frame sync")
+	}
+	if _, err := br.bits(1); err != nil { // blocking strategy
+		return nil, err
+	}
+	fields, err := br.bits(16)
+	if err != nil {
+		return nil, err
+	}
+	blockSizeCode := fields >> 12
+	sampleRateCode := fields >> 8 & 0xF
+	assignment := int(fields >> 4 & 0xF)
+	sampleSizeCode := fields >> 1 & 0x7
+
+	// frame or sample number, UTF-8 like coding
+	first, err := br.bits(8)
+	if err != nil {
+		return nil, err
+	}
+	for extra := bits.LeadingZeros8(^uint8(first)) - 1; extra > 0; extra-- {
+		if _, err := br.bits(8); err != nil {
+			return nil, err
+		}
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, err := br.bits(8)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode == 7:
+		v, err := br.bits(16)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return nil, fmt.Errorf("reserved FLAC block size")
+	}
+	switch sampleRateCode {
+	case 12:
+		_, err = br.bits(8)
+	case 13, 14:
+		_, err = br.bits(16)
+	case 15:
+		err = fmt.Errorf("invalid FLAC sample rate code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bitsPerSample := stream.bitsPerSample
+	if sampleSizeCode != 0 {
+		bitsPerSample = flacSampleSizes[sampleSizeCode]
+		if bitsPerSample == 0 {
+			return nil, fmt.Errorf("reserved FLAC sample size")
+		}
+	}
+	channels := assignment + 1
+	if assignment >= 8 && assignment <= 10 {
+		channels = 2
+	} else if assignment > 10 {
+		return nil, fmt.Errorf("reserved FLAC channel assignment %d", assignment)
+	}
+	if channels != stream.channels {
+		return nil, fmt.Errorf("FLAC frame with %d channels in a %d channels stream", channels, stream.channels)
+	}
+
+	headerCRC := crc8(br.frame)
+	if crc, err := br.bits(8); err != nil {
+		return nil, err
+	} else if byte(crc) != headerCRC {
+		return nil, fmt.Errorf("FLAC frame header CRC mismatch")
+	}
+
+	samples := make([][]int64, channels)
+	for c := range samples {
+		size := uint(bitsPerSample)
+		// the side channel has one more bit
+		if (assignment == 8 && c == 1) || (assignment == 9 && c == 0) || (assignment == 10 && c == 1) {
+			size++
+		}
+		samples[c] = make([]int64, blockSize)
+		if err := decodeFLACSubframe(br, samples[c], size); err != nil {
+			return nil, err
+		}
+	}
+
+	br.align()
+	frameCRC := crc16(br.frame)
+	if crc, err := br.bits(16); err != nil {
+		return nil, err
+	} else if uint16(crc) != frameCRC {
+		return nil, fmt.Errorf("FLAC frame CRC mismatch")
+	}
+
+	switch assignment {
+	case 8: // left, side
+		for i := range samples[1] {
+			samples[1][i] = samples[0][i] - samples[1][i]
+		}
+	case 9: // side, right
+		for i := range samples[0] {
+			samples[0][i] += samples[1][i]
+		}
+	case 10: // mid, side
+		for i := range samples[0] {
+			mid := samples[0][i]<<1 | samples[1][i]&1
+			side := samples[1][i]
+			samples[0][i] = (mid + side) >> 1
+			samples[1][i] = (mid - side) >> 1
+		}
+	}
+	return samples, nil
+}
+
+// flacFixedCoefficients are the coefficients of the fixed predictors of order 0 to 4.
+var flacFixedCoefficients = [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+// decodeFLACSubframe decodes one channel of a frame, with samples of size bits.
+func decodeFLACSubframe(br *bitReader, out []int64, size uint) error {
+	header, err := br.bits(8)
+	if err != nil {
+		return err
+	}
+	if header&0x80 != 0 {
+		return fmt.Errorf("invalid FLAC subframe padding")
+	}
+	kind := header >> 1 & 0x3F
+	var wasted uint
+	if header&1 != 0 {
+		k, err := br.unary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		if wasted >= size {
+			return fmt.Errorf("invalid FLAC wasted bits")
+		}
+		size -= wasted
+	}
+
+	switch {
+	case kind == 0: // constant
+		v, err := br.signed(size)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = v
+		}
+	case kind == 1: // verbatim
+		for i := range out {
+			if out[i], err = br.signed(size); err != nil {
+				return err
+			}
+		}
+	case kind >= 8 && kind <= 12: // fixed predictor
+		order := int(kind - 8)
+		if err := decodeFLACPredicted(br, out, size, flacFixedCoefficients[order]); err != nil {
+			return err
+		}
+	case kind >= 32: // LPC
+		order := int(kind-32) + 1
+		if order > len(out) {
+			return fmt.Errorf("FLAC LPC order %d larger than the block", order)
+		}
+		warmup := make([]int64, order)
+		for i := range warmup {
+			if warmup[i], err = br.signed(size); err != nil {
+				return err
+			}
+		}
+		precision, err := br.bits(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return fmt.Errorf("invalid FLAC LPC precision")
+		}
+		shift, err := br.signed(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return fmt.Errorf("negative FLAC LPC shift")
+		}
+		coefficients := make([]int64, order)
+		for i := range coefficients {
+			if coefficients[i], err = br.signed(uint(precision) + 1); err != nil {
+				return err
+			}
+		}
+		copy(out, warmup)
+		if err := decodeFLACResidual(br, out, order); err != nil {
+			return err
+		}
+		predict(out, coefficients, uint(shift))
+	default:
+		return fmt.Errorf("reserved FLAC subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// decodeFLACPredicted reads the warm-up samples and the residual of a fixed predictor subframe.
+func decodeFLACPredicted(br *bitReader, out []int64, size uint, coefficients []int64) error {
+	order := len(coefficients)
+	if order > len(out) {
+		return fmt.Errorf("FLAC predictor order %d larger than the block", order)
+	}
+	var err error
+	for i := 0; i < order; i++ {
+		if out[i], err = br.signed(size); err != nil {
+			return err
+		}
+	}
+	if err := decodeFLACResidual(br, out, order); err != nil {
+		return err
+	}
+	predict(out, coefficients, 0)
+	return nil
+}
+
+// predict adds the prediction to the residuals which follow the warm-up samples.
+func predict(out []int64, coefficients []int64, shift uint) {
+	order := len(coefficients)
+	for i := order; i < len(out); i++ {
+		var sum int64
+		for j, c := range coefficients {
+			sum += c * out[i-1-j]
+		}
+		out[i] += sum >> shift
+	}
+}
+
+// decodeFLACResidual reads the Rice coded residual of a predicted subframe into out[order:].
+func decodeFLACResidual(br *bitReader, out []int64, order int) error {
+	method, err := br.bits(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return fmt.Errorf("reserved FLAC residual coding method")
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partitionOrder, err := br.bits(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	if len(out)%partitions != 0 || len(out)>>partitionOrder < order {
+		return fmt.Errorf("invalid FLAC partition order %d", partitionOrder)
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		count := len(out) >> partitionOrder
+		if p == 0 {
+			count -= order
+		}
+		param, err := br.bits(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			size, err := br.bits(5)
+			if err != nil {
+				return err
+			}
+			for ; count > 0; count-- {
+				if out[i], err = br.signed(uint(size)); err != nil {
+					return err
+				}
+				i++
+			}
+			continue
+		}
+		for ; count > 0; count-- {
+			q, err := br.unary()
+			if err != nil {
+				return err
+			}
+			r, err := br.bits(uint(param))
+			if err != nil {
+				return err
+			}
+			u := q<<param | r
+			out[i] = int64(u>>1) ^ -int64(u&1)
+			i++
+		}
+	}
+	return nil
+}
+
+// decodeFLAC reads all the channels of a FLAC stream, as float64 between -1 and 1, and its tags.
+// The MD5 signature of STREAMINFO is checked when there is one.
+func decodeFLAC(r io.Reader) (WaveInfo, [][]float64, []string, error) {
+	reader := bufio.NewReaderSize(r, 1<<16)
+	stream, err := readFLACMetadata(reader)
+	if err != nil {
+		return WaveInfo{}, nil, nil, err
+	}
+	info := WaveInfo{
+		Container:     "FLAC",
+		Channels:      stream.channels,
+		SampleRate:    stream.sampleRate,
+		BitsPerSample: stream.bitsPerSample,
+		ValidBits:     stream.bitsPerSample,
+	}
+
+	channels := make([][]float64, stream.channels)
+	for c := range channels {
+		channels[c] = make([]float64, 0, min(stream.totalSamples, 1<<20))
+	}
+	scale := 1 / float64(int64(1)<<(stream.bitsPerSample-1)-1)
+	hash := md5.New()
+	sampleBytes := (stream.bitsPerSample + 7) / 8
+	pcm := make([]byte, 0, flacBlockSize*stream.channels*sampleBytes)
+
+	br := &bitReader{r: reader}
+	for {
+		samples, err := decodeFLACFrame(br, stream)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, nil, nil, fmt.Errorf("error decoding FLAC frame %d: %w", len(channels[0]), err)
+		}
+		pcm = pcm[:0]
+		for i := range samples[0] {
+			for c := range samples {
+				v := samples[c][i]
+				for b := 0; b < sampleBytes; b++ {
+					pcm = append(pcm, byte(v>>(8*b)))
+				}
+			}
+		}
+		hash.Write(pcm)
+		for c := range samples {
+			for _, v := range samples[c] {
+				channels[c] = append(channels[c], float64(v)*scale)
+			}
+		}
+	}
+
+	info.Frames = int64(len(channels[0]))
+	if stream.md5 != [16]byte{} && !bytes.Equal(hash.Sum(nil), stream.md5[:]) {
+		log.Warn("FLAC MD5 signature mismatch : the decoded audio differs from the encoded audio")
+	}
+	return info, channels, stream.tags, nil
+}
+
+// bitWriter writes big endian bit fields.
+type bitWriter struct {
+	buf   []byte
+	cache uint64
+	n     uint
+}
+
+// bits writes the n low bits of v, n up to 32.
+func (w *bitWriter) bits(v uint64, n uint) {
+	w.cache = w.cache<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		w.buf = append(w.buf, byte(w.cache>>w.n))
+	}
+	w.cache &= 1<<w.n - 1
+}
+
+// unary writes q zeros and a one.
+func (w *bitWriter) unary(q uint64) {
+	for ; q >= 32; q -= 32 {
+		w.bits(0, 32)
+	}
+	w.bits(1, uint(q)+1)
+}
+
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.bits(0, 8-w.n)
+	}
+}
+
+// flacSubframe is the best coding found for one channel of a frame.
+type flacSubframe struct {
+	kind      int // 0 constant, 1 verbatim, 8 + order for a fixed predictor
+	size      uint
+	residual  []int64
+	partition uint // partition order
+	params    []uint
+	cost      int // in bits
+}
+
+// riceCost returns the bits of the Rice coded residuals with the best parameter of each partition.
+func riceCost(residual []int64, order int, blockSize int, partitionOrder uint) (int, []uint) {
+	partitions := 1 << partitionOrder
+	params := make([]uint, partitions)
+	total := 0
+	start := 0
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * (blockSize >> partitionOrder)
+		if p == 0 {
+			start = order
+		}
+		var sum uint64
+		for _, r := range residual[start:end] {
+			sum += uint64(r<<1 ^ r>>63)
+		}
+		count := uint64(end - start)
+		best, bestCost := uint(0), uint64(1<<63)
+		// the optimal parameter is near log2 of the mean
+		guess := 0
+		if count > 0 && sum > count {
+			guess = bits.Len64(sum/count) - 1
+		}
+		for k := max(0, guess-1); k <= min(30, guess+1); k++ {
+			cost := count*uint64(k+1) + riceSum(residual[start:end], uint(k))
+			if cost < bestCost {
+				best, bestCost = uint(k), cost
+			}
+		}
+		params[p] = best
+		total += 5 + int(bestCost)
+		start = end
+	}
+	return total, params
+}
+
+func riceSum(residual []int64, k uint) uint64 {
+	var sum uint64
+	for _, r := range residual {
+		sum += uint64(r<<1^r>>63) >> k
+	}
+	return sum
+}
+
+// encodeFLACSubframe chooses the coding of one channel : constant, verbatim or the fixed
+// predictor and the partition order with the fewest bits.
+func encodeFLACSubframe(samples []int64, size uint) flacSubframe {
+	constant := true
+	for _, v := range samples {
+		if v != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return flacSubframe{kind: 0, size: size, cost: 8 + int(size)}
+	}
+
+	best := flacSubframe{kind: 1, size: size, cost: 8 + int(size)*len(samples)}
+	residual := make([]int64, len(samples))
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		coefficients := flacFixedCoefficients[order]
+		for i := order; i < len(samples); i++ {
+			var sum int64
+			for j, c := range coefficients {
+				sum += c * samples[i-1-j]
+			}
+			residual[i] = samples[i] - sum
+		}
+		for po := uint(0); po <= 8; po++ {
+			if len(samples)%(1<<po) != 0 || len(samples)>>po <= order {
+				break
+			}
+			cost, params := riceCost(residual, order, len(samples), po)
+			cost += 8 + order*int(size) + 6
+			if cost < best.cost {
+				best = flacSubframe{kind: 8 + order, size: size, residual: append([]int64{}, residual...), partition: po, params: params, cost: cost}
+			}
+		}
+	}
+	return best
+}
+
+// write writes the subframe of samples.
+func (s flacSubframe) write(w *bitWriter, samples []int64) {
+	w.bits(uint64(s.kind)<<1, 8)
+	switch {
+	case s.kind == 0:
+		w.bits(uint64(samples[0]), s.size)
+	case s.kind == 1:
+		for _, v := range samples {
+			w.bits(uint64(v), s.size)
+		}
+	default:
+		order := s.kind - 8
+		for _, v := range samples[:order] {
+			w.bits(uint64(v), s.size)
+		}
+		method, paramBits := uint64(0), uint(4)
+		for _, k := range s.params {
+			if k >= 15 {
+				method, paramBits = 1, 5
+			}
+		}
+		w.bits(method, 2)
+		w.bits(uint64(s.partition), 4)
+		start := order
+		for p, k := range s.params {
+			end := (p + 1) * (len(samples) >> s.partition)
+			w.bits(uint64(k), paramBits)
+			for _, r := range s.residual[start:end] {
+				u := uint64(r<<1 ^ r>>63)
+				w.unary(u >> k)
+				w.bits(u, k)
+			}
+			start = end
+		}
+	}
+}
+
+// encodeFLACFrame encodes one block of samples (one slice per channel) as a frame.
+func encodeFLACFrame(w *bitWriter, number uint64, samples [][]int64, sampleRate int, bitsPerSample int) {
+	blockSize := len(samples[0])
+	size := uint(bitsPerSample)
+
+	assignment := len(samples) - 1
+	subframes := make([]flacSubframe, len(samples))
+	for c := range samples {
+		subframes[c] = encodeFLACSubframe(samples[c], size)
+	}
+	if len(samples) == 2 {
+		// left/side, side/right or mid/side when they are smaller than left/right
+		side := make([]int64, blockSize)
+		mid := make([]int64, blockSize)
+		for i := range side {
+			side[i] = samples[0][i] - samples[1][i]
+			mid[i] = (samples[0][i] + samples[1][i]) >> 1
+		}
+		sideFrame := encodeFLACSubframe(side, size+1)
+		midFrame := encodeFLACSubframe(mid, size)
+		left, right := subframes[0], subframes[1]
+		best := left.cost + right.cost
+		if c := left.cost + sideFrame.cost; c < best {
+			best, assignment, subframes = c, 8, []flacSubframe{left, sideFrame}
+		}
+		if c := sideFrame.cost + right.cost; c < best {
+			best, assignment, subframes = c, 9, []flacSubframe{sideFrame, right}
+		}
+		if c := midFrame.cost + sideFrame.cost; c < best {
+			assignment, subframes = 10, []flacSubframe{midFrame, sideFrame}
+		}
+		switch assignment {
+		case 8:
+			samples = [][]int64{samples[0], side}
+		case 9:
+			samples = [][]int64{side, samples[1]}
+		case 10:
+			samples = [][]int64{mid, side}
+		}
+	}
+
+	start := len(w.buf)
+	w.bits(0xFFF8, 16) // sync, fixed block size
+	blockSizeCode := uint64(7)
+	switch {
+	case blockSize == flacBlockSize:
+		blockSizeCode = 12
+	case blockSize <= 256:
+		blockSizeCode = 6
+	}
+	sampleRateCode := uint64(0)
+	for code, rate := range flacSampleRates {
+		if rate == sampleRate && rate != 0 {
+			sampleRateCode = uint64(code)
+		}
+	}
+	sampleSizeCode := uint64(0)
+	for code, bps := range flacSampleSizes {
+		if bps == bitsPerSample && bps != 0 {
+			sampleSizeCode = uint64(code)
+		}
+	}
+	w.bits(blockSizeCode<<4|sampleRateCode, 8)
+	w.bits(uint64(assignment)<<4|sampleSizeCode<<1, 8)
+	writeUTF8Number(w, number)
+	switch blockSizeCode {
+	case 6:
+		w.bits(uint64(blockSize-1), 8)
+	case 7:
+		w.bits(uint64(blockSize-1), 16)
+	}
+	w.bits(uint64(crc8(w.buf[start:])), 8)
+
+	for c, subframe := range subframes {
+		subframe.write(w, samples[c])
+	}
+	w.align()
+	w.bits(uint64(crc16(w.buf[start:])), 16)
+}
+
+// writeUTF8Number writes the frame number with the UTF-8 like coding of the frame headers.
+func writeUTF8Number(w *bitWriter, n uint64) {
+	if n < 0x80 {
+		w.bits(n, 8)
+		return
+	}
+	extra := 1
+	for n >= 1<<(5*extra+6) {
+		extra++
+	}
+	w.bits(0xFF<<(7-extra)&0xFF|n>>(6*extra), 8)
+	for i := extra - 1; i >= 0; i-- {
+		w.bits(0x80|n>>(6*i)&0x3F, 8)
+	}
+}
+
+// vorbisComment builds a VORBIS_COMMENT block.
+func vorbisComment(tags []string) []byte {
+	var b bytes.Buffer
+	vendor := "sqdecoder"
+	binary.Write(&b, binary.LittleEndian, uint32(len(vendor)))
+	b.WriteString(vendor)
+	binary.Write(&b, binary.LittleEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		binary.Write(&b, binary.LittleEndian, uint32(len(tag)))
+		b.WriteString(tag)
+	}
+	return b.Bytes()
+}
+
+// writeFLAC writes channels as a FLAC file with 16 bits samples. names are the channels
+// (LF, RF...) : the channel order must be the FLAC default one for the number of channels,
+// the channel mask is written in the WAVEFORMATEXTENSIBLE_CHANNEL_MASK tag when it is known.
+// The tags of opts.Metadata are the Vorbis comments, its cue points the tracks of a CUESHEET block.
+func writeFLAC(s string, sampleRate int, names []string, channels [][]float64, opts OutputOptions) error {
+	const bitsPerSample = 16
+	frames := len(channels[0])
+	for _, channel := range channels {
+		if len(channel) != frames {
+			return fmt.Errorf("all channels must be the same length")
+		}
+	}
+	if len(channels) > 8 {
+		return fmt.Errorf("FLAC files have at most 8 channels, not %d", len(channels))
+	}
+
+	meta := opts.Metadata
+	tags := meta.vorbisComments()
+	if mask, ok := channelMask(names); ok && len(channels) > 2 {
+		if mask != flacChannelMasks[len(channels)] {
+			log.Warn("Channel order is not the FLAC default one", "channels", strings.Join(names, ","))
+		}
+		tags = append(tags, fmt.Sprintf("WAVEFORMATEXTENSIBLE_CHANNEL_MASK=0x%04X", mask))
+	}
+
+	outFile, err := os.Create(s)
+	if err != nil {
+		return fmt.Errorf("error creating FLAC file: %w", err)
+	}
+	defer outFile.Close()
+	out := bufio.NewWriterSize(outFile, 1<<16)
+
+	// fLaC, STREAMINFO (written again at the end with the frame sizes and the MD5), VORBIS_COMMENT
+	// and CUESHEET, the last block with the flag 0x80
+	blocks := [][]byte{vorbisComment(tags)}
+	if len(meta.Cues) > 0 {
+		blocks = append(blocks, cuesheetBlock(meta.Cues, int64(frames)))
+	}
+	header := []byte("fLaC\x00\x00\x00\x22")
+	header = append(header, make([]byte, 34)...)
+	for i, block := range blocks {
+		blockType := byte(4 + i)
+		if i == len(blocks)-1 {
+			blockType |= 0x80
+		}
+		header = append(header, blockType, byte(len(block)>>16), byte(len(block)>>8), byte(len(block)))
+		header = append(header, block...)
+	}
+	if _, err := out.Write(header); err != nil {
+		return fmt.Errorf("error writing FLAC header: %w", err)
+	}
+
+	q := newQuantizer(len(channels), bitsPerSample, opts.Dither)
+	hash := md5.New()
+	minFrame, maxFrame := 1<<24-1, 0
+	block := make([][]int64, len(channels))
+	var pcm []byte
+	w := &bitWriter{}
+	for number, start := uint64(0), 0; start < frames; number, start = number+1, start+flacBlockSize {
+		end := min(frames, start+flacBlockSize)
+		pcm = pcm[:0]
+		for c := range channels {
+			block[c] = block[c][:0]
+			for _, x := range channels[c][start:end] {
+				block[c] = append(block[c], q.quantize(c, x))
+			}
+		}
+		for i := range block[0] {
+			for c := range block {
+				pcm = append(pcm, byte(block[c][i]), byte(block[c][i]>>8))
+			}
+		}
+		hash.Write(pcm)
+
+		w.buf = w.buf[:0]
+		encodeFLACFrame(w, number, block, sampleRate, bitsPerSample)
+		minFrame, maxFrame = min(minFrame, len(w.buf)), max(maxFrame, len(w.buf))
+		if _, err := out.Write(w.buf); err != nil {
+			return fmt.Errorf("error writing FLAC frame: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("error writing FLAC frame: %w", err)
+	}
+
+	minBlock := flacBlockSize
+	if frames < flacBlockSize {
+		minBlock, minFrame = frames, maxFrame
+	}
+	var info bytes.Buffer
+	binary.Write(&info, binary.BigEndian, uint16(minBlock))
+	binary.Write(&info, binary.BigEndian, uint16(flacBlockSize))
+	info.Write([]byte{byte(minFrame >> 16), byte(minFrame >> 8), byte(minFrame)})
+	info.Write([]byte{byte(maxFrame >> 16), byte(maxFrame >> 8), byte(maxFrame)})
+	binary.Write(&info, binary.BigEndian, uint64(sampleRate)<<44|uint64(len(channels)-1)<<41|uint64(bitsPerSample-1)<<36|uint64(frames))
+	info.Write(hash.Sum(nil))
+	if _, err := outFile.WriteAt(info.Bytes(), 8); err != nil {
+		return fmt.Errorf("error writing FLAC STREAMINFO: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("error closing FLAC file: %w", err)
+	}
+	q.report(s, names)
+	return nil
+}
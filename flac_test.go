@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestFLACReference decodes the first example of RFC 9639 (appendix D.1) : one stereo frame
+// with verbatim subframes, its CRCs and MD5 must match.
+func TestFLACReference(t *testing.T) {
+	data, err := hex.DecodeString(strings.Join(strings.Fields(`
+		664c 6143 8000 0022 1000 1000 0000 0f00 000f 0ac4 42f0 0000
+		0001 3e84 b418 07dc 6903 0758 6a3d ad1a 2e0f fff8 6918 0000
+		bf03 58fd 0312 8baa 9a`), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, channels, _, err := decodeFLAC(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Channels != 2 || info.SampleRate != 44100 || info.BitsPerSample != 16 || info.Frames != 1 {
+		t.Fatalf("info = %+v", info)
+	}
+	if l, r := math.Round(channels[0][0]*32767), math.Round(channels[1][0]*32767); l != 25588 || r != 10416 {
+		t.Errorf("samples = %v, %v, want 25588, 10416", l, r)
+	}
+}
+
+// flacSignals returns count channels of frames samples exercising the encoder : silence in the
+// first block (constant subframes), a sine, noise, and for stereo identical channels then
+// opposite channels (side and mid of zero).
+func flacSignals(count int, frames int) [][]float64 {
+	rng := rand.New(rand.NewSource(int64(count)))
+	channels := make([][]float64, count)
+	for c := range channels {
+		channels[c] = make([]float64, frames)
+		for i := flacBlockSize; i < frames; i++ {
+			x := 0.5 * math.Sin(2*math.Pi*float64((c+1)*i)/300)
+			if c%2 == 1 {
+				x += 0.2 * (rng.Float64()*2 - 1)
+			}
+			channels[c][i] = x
+		}
+	}
+	if count == 2 {
+		for i := 2 * flacBlockSize; i < min(frames, 3*flacBlockSize); i++ {
+			channels[1][i] = channels[0][i]
+		}
+		for i := 3 * flacBlockSize; i < frames; i++ {
+			channels[1][i] = -channels[0][i]
+		}
+	}
+	// full scale, to check the 17 bits of the side channel
+	channels[0][frames-1], channels[count-1][frames-2] = 1, -1
+	return channels
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	const sampleRate = 48000
+	frames := 4*flacBlockSize + 123
+	tests := []struct {
+		names []string
+		mask  string
+	}{
+		{[]string{"C"}, ""},
+		{[]string{"LF", "RF"}, ""},
+		{[]string{"LF", "RF", "LB", "RB"}, "0x0033"},
+		{[]string{"LF", "RF", "C", "LFE", "LB", "RB"}, "0x003F"},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.names, "_"), func(t *testing.T) {
+			in := flacSignals(len(tt.names), frames)
+			path := filepath.Join(dir, strings.Join(tt.names, "_")+".flac")
+			if err := writeFLAC(path, sampleRate, tt.names, in, OutputOptions{Metadata: Metadata{Tags: []Tag{{"TITLE", "test"}}}}); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			info, out, tags, err := decodeFLAC(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Channels != len(tt.names) || info.SampleRate != sampleRate || info.BitsPerSample != 16 || info.Frames != int64(frames) {
+				t.Fatalf("info = %+v", info)
+			}
+			for c := range in {
+				for i := range in[c] {
+					if got, want := int16(math.Round(out[c][i]*32767)), pcm16(in[c][i]); got != want {
+						t.Fatalf("channel %d frame %d = %d, want %d", c, i, got, want)
+					}
+				}
+			}
+
+			wantTags := []string{"TITLE=test"}
+			if tt.mask != "" {
+				wantTags = append(wantTags, "WAVEFORMATEXTENSIBLE_CHANNEL_MASK="+tt.mask)
+			}
+			if !slices.Equal(tags, wantTags) {
+				t.Errorf("tags = %q, want %q", tags, wantTags)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// STREAMINFO : block sizes 4096, then the frame sizes which must be set
+			if !bytes.Equal(data[8:12], []byte{0x10, 0x00, 0x10, 0x00}) || bytes.Equal(data[12:18], make([]byte, 6)) {
+				t.Errorf("STREAMINFO % x", data[8:26])
+			}
+			if len(data) > frames*len(in)*2 {
+				t.Errorf("%d bytes, larger than the PCM data", len(data))
+			}
+		})
+	}
+}
+
+// TestFLACLPCSubframe decodes a hand made LPC subframe, which the encoder never writes.
+func TestFLACLPCSubframe(t *testing.T) {
+	// order 2, s[i] = (3 s[i-1] - s[i-2]) >> 1 + residual
+	want := []int64{100, 120, 130, 135, 137}
+	w := &bitWriter{}
+	w.bits(uint64(32+1)<<1, 8)
+	w.bits(uint64(want[0]), 16)
+	w.bits(uint64(want[1]), 16)
+	w.bits(4-1, 4) // precision
+	w.bits(1, 5)   // shift
+	w.bits(3, 4)
+	w.bits(uint64(0xF), 4) // -1
+	w.bits(0, 2)           // rice, 4 bits parameters
+	w.bits(0, 4)           // partition order
+	w.bits(2, 4)
+	for i := 2; i < len(want); i++ {
+		r := want[i] - (3*want[i-1]-want[i-2])>>1
+		u := uint64(r<<1 ^ r>>63)
+		w.unary(u >> 2)
+		w.bits(u, 2)
+	}
+	w.align()
+
+	out := make([]int64, len(want))
+	if err := decodeFLACSubframe(&bitReader{r: bytes.NewReader(w.buf)}, out, 16); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(out, want) {
+		t.Errorf("samples = %v, want %v", out, want)
+	}
+}
+
+func TestFLACErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.flac")
+	if err := writeFLAC(path, 44100, []string{"LF", "RF"}, flacSignals(2, 5000), OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := slices.Clone(data)
+	corrupted[len(corrupted)-10] ^= 0x40
+	if _, _, _, err := decodeFLAC(bytes.NewReader(corrupted)); err == nil || !strings.Contains(err.Error(), "CRC") {
+		t.Errorf("corrupted frame: err = %v, want a CRC error", err)
+	}
+	if _, _, _, err := decodeFLAC(bytes.NewReader(data[:len(data)-100])); err == nil {
+		t.Error("truncated file: no error")
+	}
+	// STREAMINFO at 8, bits per sample minus one in the last bit of byte 12 and the first 4 of byte 13
+	oneBit := slices.Clone(data)
+	oneBit[8+12] &^= 0x01
+	oneBit[8+13] &^= 0xF0
+	if _, _, _, err := decodeFLAC(bytes.NewReader(oneBit)); err == nil || !strings.Contains(err.Error(), "bits per sample") {
+		t.Errorf("1 bit per sample: err = %v, want an invalid STREAMINFO", err)
+	}
+	if _, _, _, err := decodeFLAC(bytes.NewReader([]byte("RIFF0000WAVE"))); err == nil {
+		t.Error("wave file: no error")
+	}
+}
+
+func FuzzDecodeFLAC(f *testing.F) {
+	path := filepath.Join(f.TempDir(), "seed.flac")
+	if err := writeFLAC(path, 44100, []string{"LF", "RF"}, flacSignals(2, 300), OutputOptions{Metadata: Metadata{Tags: []Tag{{"A", "b"}}}}); err != nil {
+		f.Fatal(err)
+	}
+	seed, err := os.ReadFile(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(seed)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeFLAC(bytes.NewReader(data))
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Gain staging of the decoded channels : the gain trims of the configuration first, then one of
+// - none : the levels of the matrix, as encoded. The samples above full scale are clipped by the
+//   writers, which count them
+// - peak : one gain for all the channels, the highest peak at PeakDBFS (0 dBFS by default) : the
+//   balance between the speakers stays the one of the encoding
+// - pairs : the peaks of the front pair, of the back pair and of each other channel at full scale
+//   independently, the levels of the first versions (and of DecodeSQ, DecodeQS...), whose
+//   inverse FFT was not scaled
+// - loudness : one gain for all the channels, the integrated loudness (BS.1770) at TargetLUFS,
+//   then the true peaks above TruePeakDBTP limited (see Limiter) : for delivery
+// and, when enabled, the limiter last : the loud passages are limited instead of bringing the
+// level of the whole decode down.
+
+// gainModes are the values of -normalize.
+var gainModes = []string{"none", "peak", "pairs", "loudness"}
+
+// GainStaging chooses how the decoded channels are brought to the output level.
+type GainStaging struct {
+	// Mode is none, peak, pairs or loudness, pairs when empty.
+	Mode string `json:"mode"`
+	// PeakDBFS is the level of the highest peak of the peak mode, 0 dBFS or below.
+	PeakDBFS float64 `json:"peak_dbfs"`
+	// TargetLUFS is the integrated loudness of the loudness mode, TruePeakDBTP the ceiling of
+	// its limiter.
+	TargetLUFS   float64 `json:"target_lufs"`
+	TruePeakDBTP float64 `json:"true_peak_dbtp"`
+}
+
+// defaultGainStaging is the gain staging of the presets : the loudness mode delivers at
+// -23 LUFS and -1 dBTP (EBU R 128) when chosen.
+var defaultGainStaging = GainStaging{Mode: "peak", TargetLUFS: -23, TruePeakDBTP: -1}
+
+// validate checks the mode and the target peak.
+func (g GainStaging) validate() error {
+	known := g.Mode == ""
+	for _, mode := range gainModes {
+		known = known || g.Mode == mode
+	}
+	if !known {
+		return fmt.Errorf("normalize must be none, peak, pairs or loudness, not %q", g.Mode)
+	}
+	if g.PeakDBFS > 0 || math.IsNaN(g.PeakDBFS) {
+		return fmt.Errorf("peak level must be 0 dBFS or below, not %g", g.PeakDBFS)
+	}
+	if !(g.TargetLUFS >= -70 && g.TargetLUFS <= 0) {
+		return fmt.Errorf("target loudness must be between -70 and 0 LUFS, not %g", g.TargetLUFS)
+	}
+	if g.TruePeakDBTP > 0 || math.IsNaN(g.TruePeakDBTP) {
+		return fmt.Errorf("true peak ceiling must be 0 dBTP or below, not %g", g.TruePeakDBTP)
+	}
+	return nil
+}
+
+// String returns the gain staging as written in the summary of the decoding : none, pairs,
+// peak/-1dBFS or loudness/-23LUFS/-1dBTP.
+func (g GainStaging) String() string {
+	switch g.Mode {
+	case "peak":
+		return fmt.Sprintf("peak/%gdBFS", g.PeakDBFS)
+	case "loudness":
+		return fmt.Sprintf("loudness/%gLUFS/%gdBTP", g.TargetLUFS, g.TruePeakDBTP)
+	}
+	if g.Mode == "" {
+		return "pairs"
+	}
+	return g.Mode
+}
+
+// stageGains applies the gain trims in dB, by channel name, the gain staging and the limiter of
+// opts to the decoded channels.
+func stageGains(outputs map[string][]float64, opts DecodeOptions) {
+	g, limiter := opts.Gain, opts.Limiter
+	// sorted names : the same log from one run to the other
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	channels := make([][]float64, len(names))
+	for i, name := range names {
+		channels[i] = outputs[name]
+	}
+
+	for _, name := range names {
+		gainDB := opts.Trims[name]
+		if gainDB == 0 {
+			continue
+		}
+		log.Info("Apply gain trim", "channel", name, "gaindB", gainDB)
+		scale(outputs[name], math.Pow(10, gainDB/20))
+	}
+
+	switch g.Mode {
+	case "none":
+		var peak float64
+		for _, name := range names {
+			peak = max(peak, maxAbs(outputs[name]))
+		}
+		if peak > 1 && !limiter.Enabled {
+			log.Warn("Decoded channels above full scale, they will be clipped (see -normalize)", "peakdBFS", fmt.Sprintf("%+.2f", 20*math.Log10(peak)))
+		}
+	case "peak":
+		var peak float64
+		for _, name := range names {
+			peak = max(peak, maxAbs(outputs[name]))
+		}
+		if peak > 0 {
+			gain := math.Pow(10, g.PeakDBFS/20) / peak
+			log.Info("Gain staging : common peak normalization", "peakdBFS", fmt.Sprintf("%+.2f", 20*math.Log10(peak)), "gaindB", fmt.Sprintf("%+.2f", 20*math.Log10(gain)))
+			for _, name := range names {
+				scale(outputs[name], gain)
+			}
+		}
+	case "loudness":
+		measured := measureLevels(channels, names, opts.SampleRate)
+		if math.IsInf(measured.IntegratedLUFS, -1) {
+			log.Warn("Gain staging : no loudness to normalize, silence or shorter than 400 ms", "targetLUFS", g.TargetLUFS)
+		} else {
+			gainDB := g.TargetLUFS - measured.IntegratedLUFS
+			log.Info("Gain staging : loudness normalization", "integratedLUFS", logValue(measured.IntegratedLUFS), "gaindB", fmt.Sprintf("%+.2f", gainDB))
+			for _, channel := range channels {
+				scale(channel, math.Pow(10, gainDB/20))
+			}
+		}
+		// the ceiling of the loudness mode, or the one of the limiter when lower
+		if !limiter.Enabled || g.TruePeakDBTP < limiter.CeilingDBTP {
+			limiter.CeilingDBTP = g.TruePeakDBTP
+		}
+		limiter.Enabled = true
+	default:
+		normalizeOutputs(outputs)
+	}
+
+	if limiter.Enabled {
+		if reduction := limiter.limit(channels, opts.SampleRate); reduction < 0 {
+			log.Info("True peaks limited", "ceilingdBTP", limiter.CeilingDBTP, "maxReductiondB", fmt.Sprintf("%.2f", reduction))
+		}
+	}
+}
+
+// scale multiplies the samples by gain.
+func scale(data []float64, gain float64) {
+	for i := range data {
+		data[i] *= gain
+	}
+}
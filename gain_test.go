@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStageGains(t *testing.T) {
+	// peaks of LF, RF, LB, RB and C, LB trimmed by -6 dB
+	trims := map[string]float64{"LB": 20 * math.Log10(0.5)}
+	tests := []struct {
+		gain GainStaging
+		want map[string]float64
+	}{
+		{GainStaging{Mode: "none"}, map[string]float64{"LF": 0.5, "RF": 0.25, "LB": 1, "RB": 1.5, "C": 0.1}},
+		{GainStaging{Mode: "peak"}, map[string]float64{"LF": 0.5 / 1.5, "RF": 0.25 / 1.5, "LB": 1 / 1.5, "RB": 1, "C": 0.1 / 1.5}},
+		{GainStaging{Mode: "peak", PeakDBFS: -6}, map[string]float64{"LF": 0.5 / 1.5 * 0.501, "RF": 0.25 / 1.5 * 0.501, "LB": 1 / 1.5 * 0.501, "RB": 0.501, "C": 0.1 / 1.5 * 0.501}},
+		{GainStaging{}, map[string]float64{"LF": 1, "RF": 0.5, "LB": 1 / 1.5, "RB": 1, "C": 1}},
+	}
+	for _, tt := range tests {
+		outputs := map[string][]float64{
+			"LF": {0.5, -0.1}, "RF": {0.2, -0.25}, "LB": {2, 0}, "RB": {0, -1.5}, "C": {0.1, 0},
+		}
+		stageGains(outputs, DecodeOptions{SampleRate: 44100, Trims: trims, Gain: tt.gain})
+		for name, want := range tt.want {
+			if got := maxAbs(outputs[name]); math.Abs(got-want) > 1e-3 {
+				t.Errorf("%v: %s peak %.4f, want %.4f", tt.gain, name, got, want)
+			}
+		}
+	}
+
+	for _, g := range []GainStaging{{Mode: "loudest"}, {Mode: "peak", PeakDBFS: 1}, {Mode: "loudness", TargetLUFS: 3}, {Mode: "loudness", TruePeakDBTP: 0.5}} {
+		if err := g.validate(); err == nil {
+			t.Errorf("%+v: no error", g)
+		}
+	}
+}
+
+// toneAmplitude returns the amplitude of the component of data at freq Hz.
+func toneAmplitude(data []float64, freq float64, sampleRate int) float64 {
+	var re, im float64
+	for i, v := range data {
+		phase := 2 * math.Pi * freq * float64(i) / float64(sampleRate)
+		re += v * math.Cos(phase)
+		im += v * math.Sin(phase)
+	}
+	return 2 * math.Hypot(re, im) / float64(len(data))
+}
+
+// TestDecodeBalance decodes a back source 6 dB below a front source : without normalization
+// the outputs have the level of the sources, and the peak mode keeps the 6 dB.
+func TestDecodeBalance(t *testing.T) {
+	const n = 1 << 14
+	// whole numbers of periods in n samples
+	front, back := 372*44100.0/n, 186*44100.0/n
+	silence := make([]float64, n)
+	LT, RT := EncodeSQ(sineTone(front, 0.4, 44100, n), silence, sineTone(back, 0.2, 44100, n), silence)
+
+	cfg := testConfig(t, "SQ")
+	m := decodingMatrix(cfg)
+	for _, engine := range []string{"fft", "fir"} {
+		cfg.Engine = engine
+		for _, mode := range []string{"none", "peak", "pairs"} {
+			cfg.Normalize = GainStaging{Mode: mode}
+			outputs := decodeWithOptions(LT, RT, m, cfg, cfg.decodeOptions(44100))
+			// the FIR transformers need half their length to settle at both ends
+			edge := cfg.Hilbert.Taps
+			lf := toneAmplitude(outputs["LF"][edge:n-edge], front, 44100)
+			lb := toneAmplitude(outputs["LB"][edge:n-edge], back, 44100)
+			if mode != "pairs" && math.Abs(20*math.Log10(lf/lb)-6.02) > 0.1 {
+				t.Errorf("%s %s: LF %.4f, LB %.4f, want 6 dB apart", engine, mode, lf, lb)
+			}
+			if mode == "none" && (math.Abs(lf-0.4) > 0.01 || math.Abs(lb-0.2) > 0.01) {
+				t.Errorf("%s: LF %.4f, LB %.4f, want the levels of the sources", engine, lf, lb)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// generatorPositions are the fixed positions of the generate command, clockwise from LF.
+// Azimuths are in degrees, clockwise from the center front : LF is -45, RF 45, RB 135 and LB 225.
+var generatorPositions = []struct {
+	name    string
+	azimuth float64
+	corner  bool
+}{
+	{"LF", -45, true},
+	{"CF", 0, false},
+	{"RF", 45, true},
+	{"RS", 90, false},
+	{"RB", 135, true},
+	{"CB", 180, false},
+	{"LB", 225, true},
+	{"LS", 270, false},
+}
+
+// quadPanGains returns the gains of LF, RF, LB and RB for a source at an azimuth :
+// constant power panning between the two nearest speakers.
+func quadPanGains(azimuth float64) [4]float64 {
+	azimuth = math.Mod(azimuth+45, 360)
+	if azimuth < 0 {
+		azimuth += 360
+	}
+	// pairs of speakers clockwise from LF, as indices of LF, RF, LB, RB
+	pairs := [4][2]int{{0, 1}, {1, 3}, {3, 2}, {2, 0}}
+	pair := pairs[int(azimuth/90)%4]
+	t := math.Mod(azimuth, 90) / 90
+
+	var gains [4]float64
+	gains[pair[0]] = math.Cos(t * math.Pi / 2)
+	gains[pair[1]] = math.Sin(t * math.Pi / 2)
+	return gains
+}
+
+// GeneratedSegment is the ground truth of one part of a generated file.
+type GeneratedSegment struct {
+	StartS     float64 `json:"start_s"`
+	EndS       float64 `json:"end_s"`
+	Position   string  `json:"position"`
+	AzimuthDeg float64 `json:"azimuth_deg"`
+	// AzimuthEndDeg is the azimuth at the end of a rotating source.
+	AzimuthEndDeg *float64 `json:"azimuth_end_deg,omitempty"`
+	// Gains are the LF, RF, LB, RB gains of a fixed source.
+	Gains map[string]float64 `json:"gains,omitempty"`
+}
+
+// GroundTruth is the sidecar JSON file of the generate command.
+type GroundTruth struct {
+	Signal     string             `json:"signal"`
+	SampleRate int                `json:"sample_rate"`
+	DurationS  float64            `json:"duration_s"`
+	Azimuth    string             `json:"azimuth"`
+	Files      map[string]string  `json:"files"`
+	Segments   []GeneratedSegment `json:"segments"`
+}
+
+// GeneratorOptions are the parameters of the generate command.
+type GeneratorOptions struct {
+	Signal     string  // tone, sweep, pink or speech
+	FreqHz     float64 // frequency of the tone
+	Positions  string  // corners, angles, rotate or all
+	SegmentS   float64 // length of each fixed position
+	GapS       float64 // silence between segments
+	RotationS  float64 // length of one turn of the rotating source
+	SampleRate int
+}
+
+// testSignal returns n samples of the test signal, different for each segment but reproducible.
+func testSignal(opts GeneratorOptions, n int, segment int) []float64 {
+	const amplitude = 0.5
+	switch opts.Signal {
+	case "tone":
+		return sineTone(opts.FreqHz, amplitude, opts.SampleRate, n)
+	case "sweep":
+		return logSweep(20, math.Min(20000, 0.45*float64(opts.SampleRate)), amplitude, opts.SampleRate, n)
+	case "speech":
+		return speechLike(amplitude, opts.SampleRate, n, int64(segment+1))
+	default:
+		// pink noise bursts with a 10 ms fade in and out
+		data := pinkNoise(amplitude, n, int64(segment+1))
+		fade := min(n/2, opts.SampleRate/100)
+		for i := 0; i < fade; i++ {
+			gain := float64(i) / float64(fade)
+			data[i] *= gain
+			data[n-1-i] *= gain
+		}
+		return data
+	}
+}
+
+// GenerateQuad builds the quad test channels (LF, RF, LB, RB) and their ground truth.
+func GenerateQuad(opts GeneratorOptions) ([4][]float64, []GeneratedSegment) {
+	fs := float64(opts.SampleRate)
+	var channels [4][]float64
+	var segments []GeneratedSegment
+
+	appendSamples := func(source []float64, gains func(i int) [4]float64) {
+		for i, v := range source {
+			g := gains(i)
+			for c := range channels {
+				channels[c] = append(channels[c], g[c]*v)
+			}
+		}
+	}
+	appendGap := func() {
+		gap := make([]float64, int(opts.GapS*fs))
+		appendSamples(gap, func(int) [4]float64 { return [4]float64{} })
+	}
+	now := func() float64 { return float64(len(channels[0])) / fs }
+
+	if opts.Positions != "rotate" {
+		for _, p := range generatorPositions {
+			if opts.Positions == "corners" && !p.corner {
+				continue
+			}
+			gains := quadPanGains(p.azimuth)
+			segment := GeneratedSegment{StartS: now(), Position: p.name, AzimuthDeg: p.azimuth, Gains: map[string]float64{}}
+			for c, name := range quadSources {
+				segment.Gains[name] = math.Round(gains[c]*1e6) / 1e6
+			}
+			appendSamples(testSignal(opts, int(opts.SegmentS*fs), len(segments)), func(int) [4]float64 { return gains })
+			segment.EndS = now()
+			segments = append(segments, segment)
+			appendGap()
+		}
+	}
+
+	if opts.Positions == "rotate" || opts.Positions == "all" {
+		// one clockwise turn starting at the center front
+		n := int(opts.RotationS * fs)
+		end := 360.0
+		segment := GeneratedSegment{StartS: now(), Position: "rotate", AzimuthDeg: 0, AzimuthEndDeg: &end}
+		appendSamples(testSignal(opts, n, len(segments)), func(i int) [4]float64 {
+			return quadPanGains(360 * float64(i) / float64(n))
+		})
+		segment.EndS = now()
+		segments = append(segments, segment)
+		appendGap()
+	}
+
+	return channels, segments
+}
+
+// validate checks the options of the generate command.
+func (opts GeneratorOptions) validate() error {
+	switch opts.Signal {
+	case "tone", "sweep", "pink", "speech":
+	default:
+		return fmt.Errorf("signal must be tone, sweep, pink or speech, not %q", opts.Signal)
+	}
+	switch opts.Positions {
+	case "corners", "angles", "rotate", "all":
+	default:
+		return fmt.Errorf("positions must be corners, angles, rotate or all, not %q", opts.Positions)
+	}
+	if opts.SegmentS <= 0 || opts.GapS < 0 || opts.RotationS <= 0 || opts.SampleRate <= 0 {
+		return fmt.Errorf("segment, rotation and sample rate must be positive, gap must not be negative")
+	}
+	if opts.FreqHz <= 0 || opts.FreqHz >= float64(opts.SampleRate)/2 {
+		return fmt.Errorf("tone frequency must be between 0 and half the sample rate")
+	}
+	return nil
+}
+
+// generateFiles writes the encoded files (prefix_SQ.wav, prefix_QS.wav), the quad reference
+// (prefix_quad.wav, 4.0) and the ground truth (prefix.json, with the file names relative to it).
+func generateFiles(prefix string, matrices []string, opts GeneratorOptions) error {
+	channels, segments := GenerateQuad(opts)
+	truth := GroundTruth{
+		Signal:     opts.Signal,
+		SampleRate: opts.SampleRate,
+		DurationS:  float64(len(channels[0])) / float64(opts.SampleRate),
+		Azimuth:    "degrees clockwise from center front : LF -45, RF 45, RB 135, LB 225",
+		Files:      map[string]string{"quad": filepath.Base(prefix + "_quad.wav")},
+		Segments:   segments,
+	}
+
+	log.Info("Write quad reference...", "ouput", prefix+"_quad.wav")
+	if err := writeWaveFile4_0(prefix+"_quad.wav", opts.SampleRate, channels[0], channels[1], channels[2], channels[3]); err != nil {
+		return fmt.Errorf("failed to write quad reference: %w", err)
+	}
+
+	for _, matrix := range matrices {
+		var LT, RT []float64
+		if matrix == "QS" {
+			LT, RT = EncodeQS(channels[0], channels[1], channels[2], channels[3])
+		} else {
+			LT, RT = EncodeSQ(channels[0], channels[1], channels[2], channels[3])
+		}
+		path := prefix + "_" + matrix + ".wav"
+		log.Info("Write encoded test signal...", "matrix", matrix, "ouput", path)
+		if err := writeWaveFile(path, opts.SampleRate, LT, RT); err != nil {
+			return fmt.Errorf("failed to write %s test signal: %w", matrix, err)
+		}
+		truth.Files[matrix] = filepath.Base(path)
+	}
+
+	data, err := json.MarshalIndent(truth, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding ground truth: %w", err)
+	}
+	if err := os.WriteFile(prefix+".json", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing ground truth: %w", err)
+	}
+	return nil
+}
+
+// parseMatrices parses the -matrixformat flag of generate : SQ, QS or both.
+func parseMatrices(value string) ([]string, error) {
+	switch strings.ToUpper(value) {
+	case "SQ":
+		return []string{"SQ"}, nil
+	case "QS":
+		return []string{"QS"}, nil
+	case "BOTH", "":
+		return []string{"SQ", "QS"}, nil
+	}
+	return nil, fmt.Errorf("matrixformat must be SQ, QS or both, not %q", value)
+}
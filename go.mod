@@ -4,18 +4,5 @@ go 1.22.0
 
 toolchain go1.22.12
 
-require (
-	github.com/hajimehoshi/ebiten v1.12.12
-	github.com/hajimehoshi/ebiten/v2 v2.8.6
-	gonum.org/v1/gonum v0.15.1
-)
+require gonum.org/v1/gonum v0.15.1
 
-require (
-	github.com/ebitengine/oto/v3 v3.3.2 // indirect
-	github.com/ebitengine/purego v0.8.0 // indirect
-	github.com/hajimehoshi/oto v0.6.8 // indirect
-	golang.org/x/exp/shiny v0.0.0-20250210185358-939b2ce775ac // indirect
-	golang.org/x/image v0.20.0 // indirect
-	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
-	golang.org/x/sys v0.25.0 // indirect
-)
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// HilbertParameters configure the time-domain decoder (engine fir).
+type HilbertParameters struct {
+	// Taps is the length of the FIR Hilbert transformer, odd. The latency is (Taps-1)/2 samples
+	// and the lowest frequency which gets an accurate 90° shift is about 4*sampleRate/Taps.
+	Taps int `json:"taps"`
+	// Window is the window of the FIR design : blackman, hamming, hann or rectangular.
+	Window string `json:"window"`
+}
+
+func (params HilbertParameters) validate() error {
+	if params.Taps < 3 || params.Taps%2 == 0 {
+		return fmt.Errorf("hilbert taps must be an odd number >= 3, not %d", params.Taps)
+	}
+	switch params.Window {
+	case "blackman", "hamming", "hann", "rectangular":
+	default:
+		return fmt.Errorf("hilbert window must be blackman, hamming, hann or rectangular, not %q", params.Window)
+	}
+	return nil
+}
+
+// windowValue returns the value of a window of length L at index n.
+func windowValue(window string, n int, L int) float64 {
+	x := 2 * math.Pi * float64(n) / float64(L-1)
+	switch window {
+	case "blackman":
+		return 0.42 - 0.5*math.Cos(x) + 0.08*math.Cos(2*x)
+	case "hamming":
+		return 0.54 - 0.46*math.Cos(x)
+	case "hann":
+		return 0.5 - 0.5*math.Cos(x)
+	default:
+		return 1
+	}
+}
+
+// hilbertFIR designs a windowed FIR Hilbert transformer. The ideal impulse response is
+// h[k] = 2/(π*k) for odd k and 0 for even k, with k the offset from the center tap.
+// Its frequency response is -j*sign(f), so j*X is realised as -hilbert(x).
+func hilbertFIR(params HilbertParameters) []float64 {
+	L := params.Taps
+	center := (L - 1) / 2
+	h := make([]float64, L)
+	for n := 0; n < L; n++ {
+		k := n - center
+		if k%2 != 0 {
+			h[n] = 2 / (math.Pi * float64(k)) * windowValue(params.Window, n, L)
+		}
+	}
+	return h
+}
+
+// biquad is a second order IIR section (direct form I), used for the LFE low-pass in the time domain
+// and for the formants of the speech-like test signal.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// newLowPassBiquad returns a Butterworth low-pass (Q = 1/SQR(2)) at cutoffHz.
+func newLowPassBiquad(sampleRate float64, cutoffHz float64) *biquad {
+	w0 := 2 * math.Pi * cutoffHz / sampleRate
+	alpha := math.Sin(w0) / (2 / math.Sqrt(2))
+	cosw0 := math.Cos(w0)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: (1 - cosw0) / 2 / a0,
+		b1: (1 - cosw0) / a0,
+		b2: (1 - cosw0) / 2 / a0,
+		a1: -2 * cosw0 / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+// newBandPassBiquad returns a band-pass with a gain of 1 at centerHz.
+func newBandPassBiquad(sampleRate float64, centerHz float64, q float64) *biquad {
+	w0 := 2 * math.Pi * centerHz / sampleRate
+	alpha := math.Sin(w0) / (2 * q)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: alpha / a0,
+		b1: 0,
+		b2: -alpha / a0,
+		a1: -2 * math.Cos(w0) / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// HilbertDecoder decodes LT/RT sample by sample : the real part of each matrix coefficient
+// multiplies the delayed input and the imaginary part multiplies the Hilbert transformed input.
+//
+//	out = re(c)*x[n-D] - im(c)*hilbert(x)[n-D]   with D = (taps-1)/2
+type HilbertDecoder struct {
+	matrix  Matrix
+	fir     []float64
+	delay   int
+	bufLT   []float64 // ring buffers of the last taps input samples
+	bufRT   []float64
+	pos     int
+	lowPass []*biquad // LFE low-pass per matrix row, nil when the row has none
+}
+
+// NewHilbertDecoder returns a streaming decoder for a matrix.
+func NewHilbertDecoder(m Matrix, params HilbertParameters, sampleRate int, lfeParams LFEParameters) *HilbertDecoder {
+	d := &HilbertDecoder{
+		matrix:  m,
+		fir:     hilbertFIR(params),
+		delay:   (params.Taps - 1) / 2,
+		bufLT:   make([]float64, params.Taps),
+		bufRT:   make([]float64, params.Taps),
+		lowPass: make([]*biquad, len(m.Rows)),
+	}
+	for i, row := range m.Rows {
+		if row.LowPass {
+			d.lowPass[i] = newLowPassBiquad(float64(sampleRate), lfeParams.CutoffHz)
+		}
+	}
+	return d
+}
+
+// Latency returns the delay of the outputs in samples.
+func (d *HilbertDecoder) Latency() int {
+	return d.delay
+}
+
+// Process takes one LT/RT sample and writes one sample per matrix row in out.
+// The outputs are delayed by Latency() samples.
+func (d *HilbertDecoder) Process(lt float64, rt float64, out []float64) {
+	L := len(d.fir)
+	d.bufLT[d.pos] = lt
+	d.bufRT[d.pos] = rt
+
+	// fir[n] applies to the sample n samples before the newest one, only the odd offsets
+	// from the center tap are not zero
+	var hilbertLT, hilbertRT float64
+	for n := (d.delay + 1) % 2; n < L; n += 2 {
+		index := d.pos - n
+		if index < 0 {
+			index += L
+		}
+		hilbertLT += d.fir[n] * d.bufLT[index]
+		hilbertRT += d.fir[n] * d.bufRT[index]
+	}
+	index := d.pos - d.delay
+	if index < 0 {
+		index += L
+	}
+	delayedLT, delayedRT := d.bufLT[index], d.bufRT[index]
+
+	d.pos++
+	if d.pos == L {
+		d.pos = 0
+	}
+
+	for i, row := range d.matrix.Rows {
+		lt, rt := complex128(row.LT), complex128(row.RT)
+		y := real(lt)*delayedLT - imag(lt)*hilbertLT + real(rt)*delayedRT - imag(rt)*hilbertRT
+		if d.lowPass[i] != nil {
+			y = d.lowPass[i].process(y)
+		}
+		out[i] = y
+	}
+}
+
+// DecodeHilbert decodes LT and RT in the time domain with FIR Hilbert transformers, as an
+// alternative to the FFT processing of DecodeMatrix. The latency is removed so that the outputs
+// are aligned with the input. Returns the decoded channels by name, normalized like DecodeMatrix.
+func DecodeHilbert(LT []float64, RT []float64, m Matrix, opts DecodeOptions, params HilbertParameters) map[string][]float64 {
+	log.Info("DecodeHilbert...", "matrix", m.Name, "channels", m.Channels(), "taps", params.Taps, "window", params.Window)
+
+	N := len(LT)
+	if len(RT) != N {
+		log.Error("Input slices LT and RT must have the same length : hilbert decoding")
+		panic("Input slices LT and RT must have the same length : hilbert decoding")
+	}
+
+	d := NewHilbertDecoder(m, params, opts.SampleRate, opts.LFE)
+	latency := d.Latency()
+
+	outputs := make(map[string][]float64, len(m.Rows))
+	for _, row := range m.Rows {
+		outputs[row.Channel] = make([]float64, N)
+	}
+
+	sample := make([]float64, len(m.Rows))
+	for n := 0; n < N+latency; n++ {
+		var lt, rt float64
+		if n < N {
+			lt, rt = LT[n], RT[n]
+		}
+		d.Process(lt, rt, sample)
+		if n >= latency {
+			for i, row := range m.Rows {
+				outputs[row.Channel][n-latency] = sample[i]
+			}
+		}
+	}
+
+	stageGains(outputs, opts)
+
+	log.Info("DecodeHilbert is done.", "matrix", m.Name, "latency", latency)
+
+	return outputs
+}
+
+// decodeWithEngine decodes with the FFT (default) or the FIR Hilbert engine of the configuration.
+func decodeWithEngine(LT []float64, RT []float64, m Matrix, cfg Config, sampleRate int) map[string][]float64 {
+	return decodeWithOptions(LT, RT, m, cfg, cfg.decodeOptions(sampleRate))
+}
+
+// decodeWithOptions is decodeWithEngine with options changed by the caller.
+func decodeWithOptions(LT []float64, RT []float64, m Matrix, cfg Config, opts DecodeOptions) map[string][]float64 {
+	if cfg.Align.Enabled {
+		LT, RT, _ = alignLTRT(LT, RT, cfg.Align, opts.SampleRate)
+	}
+	if cfg.Engine == "fir" {
+		return DecodeHilbert(LT, RT, m, opts, cfg.Hilbert)
+	}
+	return DecodeMatrix(LT, RT, m, opts)
+}
+
+// engineDifference compares two decodes of the same channel : it returns the level of the
+// difference relative to the reference in dB, after matching the level of the other decode.
+func engineDifference(reference []float64, other []float64) float64 {
+	var cross, otherPower, refPower float64
+	for i := range reference {
+		cross += reference[i] * other[i]
+		otherPower += other[i] * other[i]
+		refPower += reference[i] * reference[i]
+	}
+	if otherPower == 0 || refPower == 0 {
+		return math.Inf(-1)
+	}
+	gain := cross / otherPower
+	var errPower float64
+	for i := range reference {
+		e := reference[i] - gain*other[i]
+		errPower += e * e
+	}
+	return 10 * math.Log10(errPower/refPower)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Limiter is a look-ahead true-peak limiter, linked : one gain for all the channels, so that the
+// image does not move when one channel is limited. It is the last step of the gain staging when
+// enabled, and always the one of the loudness normalization.
+//   - the peaks are detected on the oversampled signal, as the true peak of the loudness
+//   - the gain reaches the one of a peak when the peak is played, in a ramp of Attack
+//     milliseconds before it : the whole file is known, the look-ahead adds no latency
+//   - then it goes back to unity in Release milliseconds (exponential)
+type Limiter struct {
+	Enabled     bool    `json:"enabled"`
+	CeilingDBTP float64 `json:"ceiling_dbtp"`
+	AttackMS    float64 `json:"attack_ms"`
+	ReleaseMS   float64 `json:"release_ms"`
+}
+
+// defaultLimiter is the limiter of the presets, disabled.
+var defaultLimiter = Limiter{CeilingDBTP: -1, AttackMS: 5, ReleaseMS: 100}
+
+// limit applies the limiter to the channels and returns the largest gain reduction, in dB.
+func (l Limiter) limit(channels [][]float64, sampleRate int) float64 {
+	envelope := truePeakEnvelope(channels, sampleRate)
+	n := len(envelope)
+	if n == 0 {
+		return 0
+	}
+	ceiling := math.Pow(10, l.CeilingDBTP/20)
+
+	// gain needed at each frame
+	needed := make([]float64, n)
+	limited := false
+	for i, peak := range envelope {
+		needed[i] = 1
+		if peak > ceiling {
+			needed[i] = ceiling / peak
+			limited = true
+		}
+	}
+	if !limited {
+		return 0
+	}
+
+	// attack : the lowest gain needed in the next attack frames, then averaged over the attack
+	// frames before, a ramp down to the gain of each peak which reaches it on time
+	attack := max(1, int(l.AttackMS*float64(sampleRate)/1000))
+	ahead := slidingMin(needed, attack)
+	gain := make([]float64, n)
+	// unity gain before the start
+	sum := float64(attack)
+	for i := range n {
+		sum += ahead[i] - 1
+		if i >= attack {
+			sum -= ahead[i-attack] - 1
+		}
+		gain[i] = sum / float64(attack)
+	}
+
+	// release : the gain only goes up slowly
+	release := 1 - math.Exp(-1000/(max(l.ReleaseMS, 0.001)*float64(sampleRate)))
+	var reduction float64
+	for i := range n {
+		if i > 0 && gain[i] > gain[i-1] {
+			gain[i] = gain[i-1] + (gain[i]-gain[i-1])*release
+		}
+		reduction = min(reduction, 20*math.Log10(gain[i]))
+	}
+	for _, channel := range channels {
+		for i := range channel {
+			channel[i] *= gain[i]
+		}
+	}
+	return reduction
+}
+
+// slidingMin returns, for each i, the minimum of data[i:i+length].
+func slidingMin(data []float64, length int) []float64 {
+	out := make([]float64, len(data))
+	// indexes of increasing values, the minimum first
+	var window []int
+	for i := len(data) - 1; i >= 0; i-- {
+		for len(window) > 0 && data[window[len(window)-1]] >= data[i] {
+			window = window[:len(window)-1]
+		}
+		window = append(window, i)
+		if window[0] >= i+length {
+			window = window[1:]
+		}
+		out[i] = data[window[0]]
+	}
+	return out
+}
+
+// validate checks the ceiling and the time constants.
+func (l Limiter) validate() error {
+	if l.CeilingDBTP > 0 || math.IsNaN(l.CeilingDBTP) {
+		return fmt.Errorf("limiter ceiling must be 0 dBTP or below, not %g", l.CeilingDBTP)
+	}
+	if !(l.AttackMS > 0) || !(l.ReleaseMS > 0) {
+		return fmt.Errorf("limiter attack and release must be positive, not %g and %g ms", l.AttackMS, l.ReleaseMS)
+	}
+	return nil
+}
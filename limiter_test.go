@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestLimiter(t *testing.T) {
+	// a quiet quad programme with a burst above full scale on LB
+	const sampleRate = 48000
+	const start, length = sampleRate, sampleRate / 20
+	original := [][]float64{
+		sineTone(440, 0.3, sampleRate, 3*sampleRate),
+		sineTone(550, 0.3, sampleRate, 3*sampleRate),
+		sineTone(660, 0.3, sampleRate, 3*sampleRate),
+		sineTone(770, 0.3, sampleRate, 3*sampleRate),
+	}
+	for i := start; i < start+length; i++ {
+		original[2][i] *= 5
+	}
+	channels := make([][]float64, len(original))
+	for c := range original {
+		channels[c] = slices.Clone(original[c])
+	}
+
+	l := Limiter{Enabled: true, CeilingDBTP: -1, AttackMS: 5, ReleaseMS: 100}
+	reduction := l.limit(channels, sampleRate)
+	// the burst peaks at 1.5
+	if want := -1 - 20*math.Log10(1.5); math.Abs(reduction-want) > 0.2 {
+		t.Errorf("gain reduction %.2f dB, want %.2f", reduction, want)
+	}
+	if peak := 20 * math.Log10(truePeak(channels, sampleRate)); peak > -0.9 {
+		t.Errorf("true peak %.2f dBTP, want -1 at most", peak)
+	}
+
+	attack := 5 * sampleRate / 1000
+	for i, x := range original[0] {
+		gain := channels[0][i] / x
+		if math.Abs(x) < 0.01 {
+			continue
+		}
+		// linked : the same gain on all the channels
+		if other := channels[3][i] / original[3][i]; math.Abs(original[3][i]) > 0.01 && math.Abs(other-gain) > 1e-9 {
+			t.Fatalf("frame %d : gain %.4f on LF, %.4f on RB", i, gain, other)
+		}
+		// untouched before the attack ramp and once released
+		if (i < start-attack-truePeakTaps || i > start+length+sampleRate) && math.Abs(gain-1) > 1e-3 {
+			t.Fatalf("frame %d : gain %.4f outside of the burst", i, gain)
+		}
+	}
+
+	// below the ceiling, nothing changes
+	quiet := [][]float64{sineTone(440, 0.5, sampleRate, sampleRate)}
+	if reduction := l.limit(quiet, sampleRate); reduction != 0 || !slices.Equal(quiet[0], sineTone(440, 0.5, sampleRate, sampleRate)) {
+		t.Errorf("quiet signal limited by %.2f dB", reduction)
+	}
+
+	for _, bad := range []Limiter{{CeilingDBTP: 1, AttackMS: 5, ReleaseMS: 100}, {CeilingDBTP: -1, AttackMS: 0, ReleaseMS: 100}, {CeilingDBTP: -1, AttackMS: 5, ReleaseMS: -1}} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v: no error", bad)
+		}
+	}
+}
+
+func TestSlidingMin(t *testing.T) {
+	data := []float64{5, 3, 4, 1, 6, 7, 2, 8}
+	want := []float64{3, 1, 1, 1, 2, 2, 2, 8}
+	if got := slidingMin(data, 3); !slices.Equal(got, want) {
+		t.Errorf("slidingMin = %v, want %v", got, want)
+	}
+}
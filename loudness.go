@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Loudness of the decoded programme, ITU-R BS.1770-4 and EBU R 128 (Tech 3341 and 3342) :
+// - the channels are K-weighted (a high shelf for the head, then a high-pass filter) and their
+//   mean squares are summed with the weights of BS.1770 : 1 for the front channels, +1.5 dB for
+//   the surrounds (LB, RB), the LFE excluded
+// - the momentary loudness is measured over 400 ms, the short-term loudness over 3 s, every 100 ms
+// - the integrated loudness is the mean of the 400 ms blocks above -70 LUFS and less than 10 LU
+//   below the mean of these blocks
+// - the loudness range is the spread (10th to 95th percentile) of the short-term loudness,
+//   gated at -70 LUFS and 20 LU below the mean
+// - the true peak is the peak of the signal oversampled 4 times (2 times at 96 kHz)
+
+// Loudness are the loudness measurements of a programme.
+type Loudness struct {
+	IntegratedLUFS   float64 `json:"integrated_lufs"`
+	RangeLU          float64 `json:"loudness_range_lu"`
+	TruePeakDBTP     float64 `json:"true_peak_dbtp"`
+	MaxMomentaryLUFS float64 `json:"max_momentary_lufs"`
+	MaxShortTermLUFS float64 `json:"max_short_term_lufs"`
+}
+
+// MarshalJSON writes the loudness of a silence, -inf, as null.
+func (l Loudness) MarshalJSON() ([]byte, error) {
+	value := func(v float64) *float64 {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil
+		}
+		v = math.Round(v*100) / 100
+		return &v
+	}
+	return json.Marshal(struct {
+		IntegratedLUFS   *float64 `json:"integrated_lufs"`
+		RangeLU          *float64 `json:"loudness_range_lu"`
+		TruePeakDBTP     *float64 `json:"true_peak_dbtp"`
+		MaxMomentaryLUFS *float64 `json:"max_momentary_lufs"`
+		MaxShortTermLUFS *float64 `json:"max_short_term_lufs"`
+	}{value(l.IntegratedLUFS), value(l.RangeLU), value(l.TruePeakDBTP), value(l.MaxMomentaryLUFS), value(l.MaxShortTermLUFS)})
+}
+
+// channelWeight returns the BS.1770 weight of a channel : 1.41 (+1.5 dB) for the surrounds, 0 for
+// the LFE, 1 for the others.
+func channelWeight(name string) float64 {
+	switch strings.ToUpper(name) {
+	case "LB", "RB", "LS", "RS":
+		return 1.41
+	case "LFE":
+		return 0
+	}
+	return 1
+}
+
+// kWeighting returns the two filters of the K-weighting for a sample rate. BS.1770 gives their
+// coefficients at 48 kHz : these are the analog prototypes behind them, through the bilinear
+// transform, which give back the same coefficients at 48 kHz.
+func kWeighting(sampleRate int) [2]biquad {
+	fs := float64(sampleRate)
+
+	// high shelf, +4 dB above 2 kHz
+	k := math.Tan(math.Pi * 1681.974450955533 / fs)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// high-pass, RLB weighting
+	k = math.Tan(math.Pi * 38.13547087602444 / fs)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+	return [2]biquad{shelf, highPass}
+}
+
+// loudnessOf returns the loudness of a weighted sum of mean squares.
+func loudnessOf(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// gatedMean returns the mean power of the blocks above the absolute gate (-70 LUFS) and above
+// the relative gate, relative LU below the mean of the first ones, and the gated blocks.
+func gatedMean(powers []float64, relative float64) (float64, []float64) {
+	var kept []float64
+	var sum float64
+	for _, p := range powers {
+		if loudnessOf(p) > -70 {
+			kept = append(kept, p)
+			sum += p
+		}
+	}
+	if len(kept) == 0 {
+		return 0, nil
+	}
+	threshold := loudnessOf(sum/float64(len(kept))) + relative
+	var gated []float64
+	sum = 0
+	for _, p := range kept {
+		if loudnessOf(p) > threshold {
+			gated = append(gated, p)
+			sum += p
+		}
+	}
+	if len(gated) == 0 {
+		return 0, nil
+	}
+	return sum / float64(len(gated)), gated
+}
+
+// measureLoudness returns the loudness of the channels, names giving their weights.
+func measureLoudness(channels [][]float64, names []string, sampleRate int) Loudness {
+	l := measureLevels(channels, names, sampleRate)
+	l.TruePeakDBTP = 20 * math.Log10(truePeak(channels, sampleRate))
+	return l
+}
+
+// measureLevels returns the loudness of the channels without the true peak, which oversamples
+// all the channels : TruePeakDBTP is not set.
+func measureLevels(channels [][]float64, names []string, sampleRate int) Loudness {
+	// mean square of each 100 ms step, weighted and summed over the channels : no step below
+	// 10 Hz, nothing measured
+	step := sampleRate / 10
+	steps := 0
+	if len(channels) > 0 && step > 0 {
+		steps = len(channels[0]) / step
+	}
+	powers := make([]float64, steps)
+	filters := kWeighting(sampleRate)
+	for c, channel := range channels {
+		weight := 1.0
+		if c < len(names) {
+			weight = channelWeight(names[c])
+		}
+		if weight == 0 {
+			continue
+		}
+		shelf, highPass := filters[0], filters[1]
+		weighted := make([]float64, len(channel))
+		for i, x := range channel {
+			weighted[i] = highPass.process(shelf.process(x))
+		}
+		for s := range powers {
+			var sum float64
+			for _, v := range weighted[s*step : (s+1)*step] {
+				sum += v * v
+			}
+			powers[s] += weight * sum / float64(step)
+		}
+	}
+
+	// blocks of 400 ms (momentary) and 3 s (short-term), every 100 ms
+	blocks := func(length int) []float64 {
+		var out []float64
+		var sum float64
+		for s, p := range powers {
+			sum += p
+			if s >= length {
+				sum -= powers[s-length]
+			}
+			if s >= length-1 {
+				out = append(out, max(0, sum/float64(length)))
+			}
+		}
+		return out
+	}
+	momentary, shortTerm := blocks(4), blocks(30)
+
+	l := Loudness{
+		IntegratedLUFS:   math.Inf(-1),
+		RangeLU:          0,
+		MaxMomentaryLUFS: math.Inf(-1),
+		MaxShortTermLUFS: math.Inf(-1),
+	}
+	for _, p := range momentary {
+		l.MaxMomentaryLUFS = max(l.MaxMomentaryLUFS, loudnessOf(p))
+	}
+	for _, p := range shortTerm {
+		l.MaxShortTermLUFS = max(l.MaxShortTermLUFS, loudnessOf(p))
+	}
+	if mean, _ := gatedMean(momentary, -10); mean > 0 {
+		l.IntegratedLUFS = loudnessOf(mean)
+	}
+	if _, gated := gatedMean(shortTerm, -20); len(gated) > 0 {
+		sort.Float64s(gated)
+		percentile := func(p float64) float64 {
+			return loudnessOf(gated[int(math.Round(p*float64(len(gated)-1)))])
+		}
+		l.RangeLU = percentile(0.95) - percentile(0.10)
+	}
+	return l
+}
+
+// oversampling returns the oversampling factor of the true peak measurement : the oversampled
+// rate must be at least 192 kHz.
+func oversampling(sampleRate int) int {
+	switch {
+	case sampleRate < 96000:
+		return 4
+	case sampleRate < 192000:
+		return 2
+	}
+	return 1
+}
+
+// truePeakTaps is the half length of the interpolation filter of the true peak.
+const truePeakTaps = 12
+
+// interpolator returns the coefficients of the phases 1 to factor-1 of the oversampling filter,
+// a Hann windowed sinc : the phase p gives the value at i+p/factor from the samples
+// i-truePeakTaps+1 to i+truePeakTaps.
+func interpolator(factor int) [][]float64 {
+	phases := make([][]float64, factor-1)
+	for p := range phases {
+		frac := float64(p+1) / float64(factor)
+		var sum float64
+		for k := -truePeakTaps + 1; k <= truePeakTaps; k++ {
+			t := frac - float64(k)
+			h := math.Cos(math.Pi*t/(2*truePeakTaps)) * math.Cos(math.Pi*t/(2*truePeakTaps))
+			if t != 0 {
+				h *= math.Sin(math.Pi*t) / (math.Pi * t)
+			}
+			phases[p] = append(phases[p], h)
+			sum += h
+		}
+		for k := range phases[p] {
+			phases[p][k] /= sum
+		}
+	}
+	return phases
+}
+
+// truePeakEnvelope returns, for each frame i, the highest absolute value of the channels at i
+// and between i and i+1 on the oversampled signal.
+func truePeakEnvelope(channels [][]float64, sampleRate int) []float64 {
+	if len(channels) == 0 {
+		return nil
+	}
+	n := len(channels[0])
+	envelope := make([]float64, n)
+	phases := interpolator(oversampling(sampleRate))
+	for _, channel := range channels {
+		for i, x := range channel {
+			peak := math.Abs(x)
+			for _, h := range phases {
+				var v float64
+				for k, coefficient := range h {
+					if j := i - truePeakTaps + 1 + k; j >= 0 && j < n {
+						v += coefficient * channel[j]
+					}
+				}
+				peak = max(peak, math.Abs(v))
+			}
+			envelope[i] = max(envelope[i], peak)
+		}
+	}
+	return envelope
+}
+
+// truePeak returns the true peak of the channels, linear.
+func truePeak(channels [][]float64, sampleRate int) float64 {
+	var peak float64
+	for _, v := range truePeakEnvelope(channels, sampleRate) {
+		peak = max(peak, v)
+	}
+	return peak
+}
+
+// bextLoudness returns a copy of a bext chunk of version 2 with the loudness fields of EBU Tech
+// 3285 set : integrated loudness, loudness range, true peak, max momentary and short-term
+// loudness, in hundredths, 0x7FFF when not measured (a silence).
+func bextLoudness(bext []byte, l Loudness) []byte {
+	if len(bext) < 602 {
+		return bext
+	}
+	bext = append([]byte(nil), bext...)
+	if binary.LittleEndian.Uint16(bext[346:348]) < 2 {
+		binary.LittleEndian.PutUint16(bext[346:348], 2)
+	}
+	for i, v := range []float64{l.IntegratedLUFS, l.RangeLU, l.TruePeakDBTP, l.MaxMomentaryLUFS, l.MaxShortTermLUFS} {
+		value := int16(0x7FFF)
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			value = int16(max(-32767, min(32766, math.Round(v*100))))
+		}
+		binary.LittleEndian.PutUint16(bext[412+2*i:], uint16(value))
+	}
+	return bext
+}
+
+// logValue returns a loudness as a log attribute, with 2 decimals.
+func logValue(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// LoudnessReport is the loudness summary of a decode, written by -loudness-json.
+type LoudnessReport struct {
+	Input    string   `json:"input"`
+	Layout   string   `json:"layout"`
+	Channels []string `json:"channels"`
+	// Normalize is the gain staging, Loudness the one of the decoded channels after it.
+	Normalize string   `json:"normalize"`
+	Loudness  Loudness `json:"loudness"`
+}
+
+// writeLoudnessJSON writes the loudness report.
+func writeLoudnessJSON(s string, report LoudnessReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding loudness report: %w", err)
+	}
+	if err := os.WriteFile(s, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing loudness report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// dBFS returns the amplitude of a level in dBFS.
+func dBFS(level float64) float64 {
+	return math.Pow(10, level/20)
+}
+
+func TestMeasureLoudness(t *testing.T) {
+	// EBU Tech 3341 : a 1 kHz sine at -23 dBFS on both channels of a stereo file is at -23 LUFS
+	tone := func(sampleRate int, level float64) []float64 {
+		return sineTone(1000, dBFS(level), sampleRate, 20*sampleRate)
+	}
+	silence := make([]float64, 20*48000)
+	tests := []struct {
+		name       string
+		sampleRate int
+		names      []string
+		channels   [][]float64
+		want       float64
+	}{
+		{"stereo", 48000, []string{"LF", "RF"}, [][]float64{tone(48000, -23), tone(48000, -23)}, -23},
+		{"stereo 44.1 kHz", 44100, []string{"LF", "RF"}, [][]float64{tone(44100, -23), tone(44100, -23)}, -23},
+		{"front left", 48000, []string{"LF", "RF"}, [][]float64{tone(48000, -23), silence}, -26.01},
+		{"surround +1.5 dB", 48000, []string{"LF", "RF", "LB", "RB"}, [][]float64{silence, silence, tone(48000, -23), silence}, -26.01 + 1.49},
+		{"LFE excluded", 48000, []string{"LF", "RF", "LFE"}, [][]float64{tone(48000, -23), tone(48000, -23), tone(48000, 0)}, -23},
+	}
+	for _, tt := range tests {
+		l := measureLoudness(tt.channels, tt.names, tt.sampleRate)
+		if math.Abs(l.IntegratedLUFS-tt.want) > 0.1 {
+			t.Errorf("%s: %.2f LUFS, want %.2f", tt.name, l.IntegratedLUFS, tt.want)
+		}
+		if math.Abs(l.MaxMomentaryLUFS-tt.want) > 0.1 || math.Abs(l.MaxShortTermLUFS-tt.want) > 0.1 || l.RangeLU > 0.1 {
+			t.Errorf("%s: max momentary %.2f, max short-term %.2f, range %.2f, want a steady level", tt.name, l.MaxMomentaryLUFS, l.MaxShortTermLUFS, l.RangeLU)
+		}
+	}
+
+	if l := measureLoudness([][]float64{silence}, nil, 48000); !math.IsInf(l.IntegratedLUFS, -1) || l.RangeLU != 0 {
+		t.Errorf("silence: %+v", l)
+	}
+	// no 100 ms step below 10 Hz (a raw input may have any rate) : nothing measured
+	if l := measureLoudness([][]float64{tone(5, 0)}, nil, 5); !math.IsInf(l.IntegratedLUFS, -1) || !math.IsInf(l.MaxMomentaryLUFS, -1) {
+		t.Errorf("5 Hz sample rate: %+v", l)
+	}
+}
+
+func TestLoudnessRange(t *testing.T) {
+	// EBU Tech 3342 : 20 s at -20 dBFS then 20 s at -30 dBFS have a range of 10 LU
+	signal := append(sineTone(1000, dBFS(-20), 48000, 20*48000), sineTone(1000, dBFS(-30), 48000, 20*48000)...)
+	l := measureLoudness([][]float64{signal, signal}, []string{"LF", "RF"}, 48000)
+	if math.Abs(l.RangeLU-10) > 1 {
+		t.Errorf("range %.2f LU, want 10", l.RangeLU)
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	// a sine at a quarter of the sample rate, 45 degrees off : the samples are at -3 dBFS, the
+	// peaks between them at 0 dBFS
+	signal := make([]float64, 48000)
+	for i := range signal {
+		signal[i] = math.Sin(math.Pi*float64(i)/2 + math.Pi/4)
+	}
+	l := measureLoudness([][]float64{signal}, nil, 48000)
+	if math.Abs(l.TruePeakDBTP) > 0.2 {
+		t.Errorf("true peak %.2f dBTP, want 0", l.TruePeakDBTP)
+	}
+}
+
+func TestLoudnessNormalization(t *testing.T) {
+	// a loud programme with a burst above full scale : -23 LUFS, the burst limited at -1 dBTP
+	const sampleRate = 48000
+	lf, rf := sineTone(1000, dBFS(-10), sampleRate, 10*sampleRate), sineTone(997, dBFS(-10), sampleRate, 10*sampleRate)
+	for i := 5 * sampleRate; i < 5*sampleRate+sampleRate/10; i++ {
+		lf[i] *= 40
+	}
+	outputs := map[string][]float64{"LF": lf, "RF": rf}
+	stageGains(outputs, DecodeOptions{SampleRate: sampleRate, Gain: GainStaging{Mode: "loudness", TargetLUFS: -23, TruePeakDBTP: -1}, Limiter: defaultLimiter})
+
+	l := measureLoudness([][]float64{outputs["LF"], outputs["RF"]}, []string{"LF", "RF"}, sampleRate)
+	if math.Abs(l.IntegratedLUFS+23) > 0.5 {
+		t.Errorf("integrated %.2f LUFS, want -23", l.IntegratedLUFS)
+	}
+	if l.TruePeakDBTP > -0.9 {
+		t.Errorf("true peak %.2f dBTP, want -1 at most", l.TruePeakDBTP)
+	}
+}
+
+func TestBextLoudness(t *testing.T) {
+	bext := make([]byte, 602+4)
+	copy(bext[602:], "A=PCM")
+	got := bextLoudness(bext, Loudness{IntegratedLUFS: -23.004, RangeLU: 7.5, TruePeakDBTP: -1.2, MaxMomentaryLUFS: math.Inf(-1), MaxShortTermLUFS: -18})
+	if &got[0] == &bext[0] {
+		t.Fatalf("bext changed in place")
+	}
+	if version := binary.LittleEndian.Uint16(got[346:]); version != 2 {
+		t.Errorf("version %d, want 2", version)
+	}
+	want := []int16{-2300, 750, -120, 0x7FFF, -1800}
+	for i, w := range want {
+		if v := int16(binary.LittleEndian.Uint16(got[412+2*i:])); v != w {
+			t.Errorf("field %d = %d, want %d", i, v, w)
+		}
+	}
+	if string(got[602:]) != "A=PC" {
+		t.Errorf("coding history %q changed", got[602:])
+	}
+}
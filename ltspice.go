@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// An LTspice .asc schematic is a list of wires, flags (net names, 0 is the ground) and symbols
+// with their attributes. Only the symbols of passive networks and ideal op-amps are recognised :
+// res, cap, ind, voltage (the input source) and opamp. The connections are rebuilt from the
+// coordinates of the wire ends and of the symbol pins, then the circuit is solved by modified
+// nodal analysis at each frequency, giving its transfer function.
+
+type point struct{ x, y int }
+
+// ascPins are the pin positions of the recognised symbols, for rotation R0.
+var ascPins = map[string][]point{
+	"res":     {{16, 16}, {16, 96}},
+	"cap":     {{16, 0}, {16, 64}},
+	"ind":     {{16, 16}, {16, 96}},
+	"voltage": {{0, 16}, {0, 96}},               // +, -
+	"opamp":   {{-32, 80}, {-32, 48}, {32, 64}}, // In+, In-, OUT
+	"opamp2":  {{-32, 80}, {-32, 48}, {32, 64}}, // supply pins are ignored
+}
+
+// ascComponent is a symbol of the schematic, with the nets of its pins.
+type ascComponent struct {
+	Kind  string // res, cap, ind, voltage, opamp
+	Name  string
+	Value float64 // ohms, farads, henrys, AC amplitude for voltage
+	AC    bool    // voltage source with an AC value, used as input
+	pins  []point
+	Nets  []string
+}
+
+// Circuit is a parsed LTspice schematic.
+type Circuit struct {
+	File       string
+	Components []*ascComponent
+	netOf      map[point]string
+	flags      map[string]bool
+}
+
+// readASC reads an .asc file, which LTspice writes either in UTF-16LE or in Latin-1 (µ is 0xB5).
+func readASC(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading LTspice file: %w", err)
+	}
+	if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || (len(data) > 1 && data[1] == 0) {
+		data = bytes.TrimPrefix(data, []byte{0xFF, 0xFE})
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b) // Latin-1
+	}
+	return string(runes), nil
+}
+
+var spiceValue = regexp.MustCompile(`^([-+]?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)(meg|[fpnuµmkgt])?`)
+
+// parseSpiceValue parses a SPICE value like 3.57K, 0.047µ, 10meg or 1uF.
+func parseSpiceValue(s string) (float64, error) {
+	m := spiceValue.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q: %w", s, err)
+	}
+	multipliers := map[string]float64{
+		"f": 1e-15, "p": 1e-12, "n": 1e-9, "u": 1e-6, "µ": 1e-6,
+		"m": 1e-3, "k": 1e3, "meg": 1e6, "g": 1e9, "t": 1e12,
+	}
+	if multiplier, ok := multipliers[m[2]]; ok {
+		value *= multiplier
+	}
+	return value, nil
+}
+
+// transform applies an LTspice orientation (R0, R90, R180, R270, M0, M90, M180, M270) to a pin offset.
+func transform(p point, orientation string) (point, error) {
+	if len(orientation) < 2 {
+		return p, fmt.Errorf("invalid orientation %q", orientation)
+	}
+	if orientation[0] == 'M' {
+		p.x = -p.x
+	} else if orientation[0] != 'R' {
+		return p, fmt.Errorf("invalid orientation %q", orientation)
+	}
+	switch orientation[1:] {
+	case "0":
+	case "90":
+		p = point{-p.y, p.x}
+	case "180":
+		p = point{-p.x, -p.y}
+	case "270":
+		p = point{p.y, -p.x}
+	default:
+		return p, fmt.Errorf("invalid orientation %q", orientation)
+	}
+	return p, nil
+}
+
+// ParseASC parses an LTspice schematic and connects its components.
+func ParseASC(path string) (*Circuit, error) {
+	text, err := readASC(path)
+	if err != nil {
+		return nil, err
+	}
+
+	circuit := &Circuit{File: path, flags: map[string]bool{}}
+	var wires [][2]point
+	flagAt := map[point]string{}
+	var current *ascComponent
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		atoi := func(i int) int {
+			if i >= len(fields) {
+				err = fmt.Errorf("%s:%d: missing coordinate", path, lineNumber)
+				return 0
+			}
+			v, convErr := strconv.Atoi(fields[i])
+			if convErr != nil {
+				err = fmt.Errorf("%s:%d: invalid coordinate %q", path, lineNumber, fields[i])
+			}
+			return v
+		}
+
+		switch fields[0] {
+		case "WIRE":
+			wires = append(wires, [2]point{{atoi(1), atoi(2)}, {atoi(3), atoi(4)}})
+		case "FLAG":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("%s:%d: invalid FLAG", path, lineNumber)
+			}
+			flagAt[point{atoi(1), atoi(2)}] = fields[3]
+			circuit.flags[fields[3]] = true
+		case "SYMBOL":
+			if len(fields) < 5 {
+				return nil, fmt.Errorf("%s:%d: invalid SYMBOL", path, lineNumber)
+			}
+			kind := strings.ToLower(fields[1])
+			kind = kind[strings.LastIndexAny(kind, `\/`)+1:] // Opamps\opamp -> opamp
+			offsets, ok := ascPins[kind]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: unsupported symbol %q (recognised: res, cap, ind, voltage, opamp)", path, lineNumber, fields[1])
+			}
+			origin := point{atoi(2), atoi(3)}
+			current = &ascComponent{Kind: strings.TrimSuffix(kind, "2")}
+			for _, offset := range offsets {
+				p, transformErr := transform(offset, fields[4])
+				if transformErr != nil {
+					return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, transformErr)
+				}
+				current.pins = append(current.pins, point{origin.x + p.x, origin.y + p.y})
+			}
+			circuit.Components = append(circuit.Components, current)
+		case "SYMATTR":
+			if current == nil || len(fields) < 3 {
+				continue
+			}
+			value := strings.Join(fields[2:], " ")
+			switch fields[1] {
+			case "InstName":
+				current.Name = value
+			case "Value", "Value2":
+				upper := strings.ToUpper(value)
+				if i := strings.Index(upper, "AC "); i >= 0 && current.Kind == "voltage" {
+					amplitude, valueErr := parseSpiceValue(strings.Fields(value[i+3:])[0])
+					if valueErr != nil {
+						return nil, fmt.Errorf("%s:%d: %s: %w", path, lineNumber, current.Name, valueErr)
+					}
+					current.Value, current.AC = amplitude, true
+				} else if fields[1] == "Value" && current.Kind != "voltage" && current.Kind != "opamp" {
+					v, valueErr := parseSpiceValue(value)
+					if valueErr != nil {
+						return nil, fmt.Errorf("%s:%d: %s: %w", path, lineNumber, current.Name, valueErr)
+					}
+					current.Value = v
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range circuit.Components {
+		if c.Value <= 0 && (c.Kind == "res" || c.Kind == "cap" || c.Kind == "ind") {
+			return nil, fmt.Errorf("%s: %s has no value", path, c.Name)
+		}
+	}
+
+	circuit.connect(wires, flagAt)
+	return circuit, nil
+}
+
+// connect computes the net of every pin : points joined by wires (ends or T-junctions),
+// and points carrying the same flag, belong to the same net.
+func (circuit *Circuit) connect(wires [][2]point, flagAt map[point]string) {
+	parent := map[point]point{}
+	var find func(p point) point
+	find = func(p point) point {
+		if q, ok := parent[p]; ok && q != p {
+			root := find(q)
+			parent[p] = root
+			return root
+		}
+		parent[p] = p
+		return p
+	}
+	union := func(a, b point) { parent[find(a)] = find(b) }
+
+	var points []point
+	for _, w := range wires {
+		points = append(points, w[0], w[1])
+		union(w[0], w[1])
+	}
+	for _, c := range circuit.Components {
+		points = append(points, c.pins...)
+	}
+	for p := range flagAt {
+		points = append(points, p)
+	}
+
+	// a point lying on a wire is connected to it
+	for _, w := range wires {
+		for _, p := range points {
+			cross := (w[1].x-w[0].x)*(p.y-w[0].y) - (w[1].y-w[0].y)*(p.x-w[0].x)
+			if cross == 0 &&
+				p.x >= min(w[0].x, w[1].x) && p.x <= max(w[0].x, w[1].x) &&
+				p.y >= min(w[0].y, w[1].y) && p.y <= max(w[0].y, w[1].y) {
+				union(p, w[0])
+			}
+		}
+	}
+
+	// points with the same flag are connected
+	byName := map[string]point{}
+	for p, name := range flagAt {
+		if q, ok := byName[name]; ok {
+			union(p, q)
+		} else {
+			byName[name] = p
+		}
+	}
+
+	// name the nets : flag name if any, else N001, N002...
+	names := map[point]string{}
+	for p, name := range flagAt {
+		names[find(p)] = name
+	}
+	counter := 0
+	circuit.netOf = map[point]string{}
+	for _, c := range circuit.Components {
+		c.Nets = make([]string, len(c.pins))
+		for i, p := range c.pins {
+			root := find(p)
+			if _, ok := names[root]; !ok {
+				counter++
+				names[root] = fmt.Sprintf("N%03d", counter)
+			}
+			c.Nets[i] = names[root]
+			circuit.netOf[p] = names[root]
+		}
+	}
+}
+
+// source returns the input voltage source : the one with an AC value, else the first one.
+func (circuit *Circuit) source() (*ascComponent, error) {
+	var first *ascComponent
+	for _, c := range circuit.Components {
+		if c.Kind != "voltage" {
+			continue
+		}
+		if c.AC {
+			return c, nil
+		}
+		if first == nil {
+			first = c
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("%s: no voltage source to drive the circuit", circuit.File)
+	}
+	return first, nil
+}
+
+// Response solves the circuit at frequency f and returns V(node) / V(input source).
+func (circuit *Circuit) Response(node string, f float64) (complex128, error) {
+	response, err := circuit.solver(node)
+	if err != nil {
+		return 0, err
+	}
+	return response(f)
+}
+
+// solver numbers the unknowns of the modified nodal analysis once and returns the function
+// which solves the circuit at a frequency. Other voltage sources than the input are AC shorts,
+// op-amps are ideal (V+ = V-, no input current).
+func (circuit *Circuit) solver(node string) (func(f float64) (complex128, error), error) {
+	input, err := circuit.source()
+	if err != nil {
+		return nil, err
+	}
+
+	// unknowns : node voltages (ground excluded), then one current per voltage source and op-amp
+	index := map[string]int{}
+	for _, c := range circuit.Components {
+		for _, net := range c.Nets {
+			if _, ok := index[net]; !ok && net != "0" {
+				index[net] = len(index)
+			}
+		}
+	}
+	if _, ok := index[node]; !ok {
+		return nil, fmt.Errorf("%s: no net named %q", circuit.File, node)
+	}
+	size := len(index)
+	for _, c := range circuit.Components {
+		if c.Kind == "voltage" || c.Kind == "opamp" {
+			size++
+		}
+	}
+	at := func(net string) int {
+		if net == "0" {
+			return -1
+		}
+		return index[net]
+	}
+
+	return func(f float64) (complex128, error) {
+		A := make([][]complex128, size)
+		for i := range A {
+			A[i] = make([]complex128, size)
+		}
+		b := make([]complex128, size)
+		stamp := func(row, col int, v complex128) {
+			if row >= 0 && col >= 0 {
+				A[row][col] += v
+			}
+		}
+
+		omega := 2 * math.Pi * f
+		extra := len(index)
+		for _, c := range circuit.Components {
+			switch c.Kind {
+			case "res", "cap", "ind":
+				var y complex128
+				switch c.Kind {
+				case "res":
+					y = complex(1/c.Value, 0)
+				case "cap":
+					y = complex(0, omega*c.Value)
+				case "ind":
+					// an inductor is a short at DC
+					y = 1 / complex(1e-12, omega*c.Value)
+				}
+				p, n := at(c.Nets[0]), at(c.Nets[1])
+				stamp(p, p, y)
+				stamp(n, n, y)
+				stamp(p, n, -y)
+				stamp(n, p, -y)
+			case "voltage":
+				p, n := at(c.Nets[0]), at(c.Nets[1])
+				stamp(p, extra, 1)
+				stamp(n, extra, -1)
+				stamp(extra, p, 1)
+				stamp(extra, n, -1)
+				if c == input {
+					b[extra] = 1
+				}
+				extra++
+			case "opamp":
+				plus, minus, out := at(c.Nets[0]), at(c.Nets[1]), at(c.Nets[2])
+				stamp(out, extra, 1)
+				stamp(extra, plus, 1)
+				stamp(extra, minus, -1)
+				extra++
+			}
+		}
+
+		x, err := solveComplex(A, b)
+		if err != nil {
+			return 0, fmt.Errorf("%s at %g Hz: %w", circuit.File, f, err)
+		}
+		return x[index[node]], nil
+	}, nil
+}
+
+// solveComplex solves A*x = b by Gaussian elimination with partial pivoting.
+func solveComplex(A [][]complex128, b []complex128) ([]complex128, error) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if cmplx.Abs(A[row][col]) > cmplx.Abs(A[pivot][col]) {
+				pivot = row
+			}
+		}
+		if cmplx.Abs(A[pivot][col]) < 1e-300 {
+			return nil, fmt.Errorf("singular circuit (floating node or unsupported topology)")
+		}
+		A[col], A[pivot] = A[pivot], A[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < n; row++ {
+			factor := A[row][col] / A[col][col]
+			for k := col; k < n; k++ {
+				A[row][k] -= factor * A[col][k]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+	x := make([]complex128, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= A[row][k] * x[k]
+		}
+		x[row] = sum / A[row][row]
+	}
+	return x, nil
+}
+
+// outputNode returns the node to observe : the given one, else the flag named out (any case).
+func (circuit *Circuit) outputNode(node string) (string, error) {
+	if node != "" {
+		return node, nil
+	}
+	for name := range circuit.flags {
+		if strings.EqualFold(name, "out") {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no output node given and no flag named out", circuit.File)
+}
+
+// FrequencyFilter is a transfer function H(f) applied in the frequency domain.
+type FrequencyFilter func(f float64) complex128
+
+// Filter returns the transfer function of the circuit to a node as a frequency domain filter.
+func (circuit *Circuit) Filter(node string) (FrequencyFilter, error) {
+	node, err := circuit.outputNode(node)
+	if err != nil {
+		return nil, err
+	}
+	response, err := circuit.solver(node)
+	if err != nil {
+		return nil, err
+	}
+	// check that the circuit can be solved before handing out the filter
+	if _, err := response(1000); err != nil {
+		return nil, err
+	}
+	return func(f float64) complex128 {
+		h, err := response(f)
+		if err != nil {
+			return 0
+		}
+		return h
+	}, nil
+}
+
+// Classify recognises the kind of filter from its gain between 10 Hz and 100 kHz :
+// low-pass, high-pass, band-pass, band-stop or all-pass, with its -3 dB frequencies.
+func Classify(filter FrequencyFilter) (string, []float64) {
+	const steps = 301
+	freqs := make([]float64, steps)
+	gains := make([]float64, steps)
+	peak, peakIndex := math.Inf(-1), 0
+	dip := math.Inf(1)
+	for i := range freqs {
+		freqs[i] = 10 * math.Pow(1e4, float64(i)/(steps-1))
+		gains[i] = 20 * math.Log10(cmplx.Abs(filter(freqs[i])))
+		if gains[i] > peak {
+			peak, peakIndex = gains[i], i
+		}
+		dip = math.Min(dip, gains[i])
+	}
+
+	var corners []float64
+	for i := 1; i < steps; i++ {
+		if (gains[i-1] >= peak-3) != (gains[i] >= peak-3) {
+			// interpolate the crossing in log frequency
+			t := (peak - 3 - gains[i-1]) / (gains[i] - gains[i-1])
+			corners = append(corners, freqs[i-1]*math.Pow(freqs[i]/freqs[i-1], t))
+		}
+	}
+
+	first, last := gains[0], gains[steps-1]
+	switch {
+	case peak-dip < 1:
+		phaseStart := cmplx.Phase(filter(freqs[0]))
+		phaseEnd := cmplx.Phase(filter(freqs[steps-1]))
+		if math.Abs(phaseEnd-phaseStart) > math.Pi/4 {
+			return "all-pass", corners
+		}
+		return "flat", corners
+	case first >= peak-3 && last < peak-3:
+		return "low-pass", corners
+	case first < peak-3 && last >= peak-3:
+		if len(corners) > 1 {
+			return "band-stop", corners
+		}
+		return "high-pass", corners
+	case first < peak-3 && last < peak-3 && peakIndex > 0 && peakIndex < steps-1:
+		return "band-pass", corners
+	default:
+		return "band-stop", corners
+	}
+}
+
+// printCircuit prints the components of a circuit, the kind of filter and its response.
+func printCircuit(w io.Writer, circuit *Circuit, node string) error {
+	node, err := circuit.outputNode(node)
+	if err != nil {
+		return err
+	}
+	filter, err := circuit.Filter(node)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "file: %s\n", circuit.File)
+	fmt.Fprintln(w, "components:")
+	for _, c := range circuit.Components {
+		fmt.Fprintf(w, "  %-6s %-8s %-10s %s\n", c.Name, c.Kind, formatValue(c), strings.Join(c.Nets, " "))
+	}
+
+	kind, corners := Classify(filter)
+	fmt.Fprintf(w, "output: %s\n", node)
+	fmt.Fprintf(w, "filter: %s", kind)
+	for _, f := range corners {
+		fmt.Fprintf(w, ", -3 dB at %.1f Hz", f)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "response:")
+	for _, f := range []float64{20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 20000} {
+		h := filter(f)
+		fmt.Fprintf(w, "  %6.0f Hz %7.2f dB %7.1f°\n", f, 20*math.Log10(cmplx.Abs(h)), cmplx.Phase(h)*180/math.Pi)
+	}
+	return nil
+}
+
+func formatValue(c *ascComponent) string {
+	switch c.Kind {
+	case "res":
+		return fmt.Sprintf("%gΩ", c.Value)
+	case "cap":
+		return fmt.Sprintf("%gF", c.Value)
+	case "ind":
+		return fmt.Sprintf("%gH", c.Value)
+	case "voltage":
+		if c.AC {
+			return fmt.Sprintf("AC %g", c.Value)
+		}
+	}
+	return ""
+}
+
+// ASCFilter applies the transfer function of an LTspice schematic to some channels :
+// LT and RT before the matrix, or decoded channels (LF, RF, LB, RB, C, LFE...) after it.
+type ASCFilter struct {
+	File     string   `json:"file"`
+	Node     string   `json:"node,omitempty"`
+	Channels []string `json:"channels"`
+}
+
+// ChannelFilter is a frequency domain filter applied to one channel by DecodeMatrix.
+type ChannelFilter struct {
+	Channel string
+	Filter  FrequencyFilter
+}
+
+// loadASCFilters parses the schematics of the configuration.
+func loadASCFilters(filters []ASCFilter) ([]ChannelFilter, error) {
+	var channelFilters []ChannelFilter
+	for _, f := range filters {
+		circuit, err := ParseASC(f.File)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := circuit.Filter(f.Node)
+		if err != nil {
+			return nil, err
+		}
+		kind, corners := Classify(filter)
+		log.Info("LTspice filter", "file", f.File, "node", f.Node, "kind", kind, "cornersHz", corners, "channels", f.Channels)
+		for _, channel := range f.Channels {
+			channelFilters = append(channelFilters, ChannelFilter{Channel: channel, Filter: filter})
+		}
+	}
+	return channelFilters, nil
+}
+
+// applyChannelFilters multiplies the spectrum of a channel by the filters of that channel.
+func applyChannelFilters(filters []ChannelFilter, channel string, spectrum []complex128, sampleRate int, N int) {
+	freqResolution := float64(sampleRate) / float64(N)
+	for _, f := range filters {
+		if f.Channel != channel {
+			continue
+		}
+		for i := range spectrum {
+			spectrum[i] *= f.Filter(float64(i) * freqResolution)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// Coefficient is a complex entry of a decoding matrix.
+// In a JSON config file it is written either as a real number, as {"re": 0.707, "im": 0}
+// or as {"mag": 0.707, "phase": 90} with the phase in degrees.
+type Coefficient complex128
+
+func (c *Coefficient) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		*c = Coefficient(complex(value, 0))
+		return nil
+	}
+
+	var entry struct {
+		Re    *float64 `json:"re"`
+		Im    *float64 `json:"im"`
+		Mag   *float64 `json:"mag"`
+		Phase *float64 `json:"phase"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return fmt.Errorf("matrix coefficient must be a number, {re, im} or {mag, phase}: %w", err)
+	}
+
+	switch {
+	case entry.Mag != nil || entry.Phase != nil:
+		if entry.Re != nil || entry.Im != nil {
+			return fmt.Errorf("matrix coefficient %s mixes re/im and mag/phase", data)
+		}
+		var mag, phase float64 = 1, 0
+		if entry.Mag != nil {
+			mag = *entry.Mag
+		}
+		if entry.Phase != nil {
+			phase = *entry.Phase
+		}
+		*c = Coefficient(cmplx.Rect(mag, phase*math.Pi/180))
+	default:
+		var re, im float64
+		if entry.Re != nil {
+			re = *entry.Re
+		}
+		if entry.Im != nil {
+			im = *entry.Im
+		}
+		*c = Coefficient(complex(re, im))
+	}
+	return nil
+}
+
+func (c Coefficient) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Re float64 `json:"re"`
+		Im float64 `json:"im"`
+	}{real(c), imag(c)})
+}
+
+// MatrixRow gives one decoded output channel as a combination of the encoded channels :
+// out = lt*LT + rt*RT
+type MatrixRow struct {
+	Channel string      `json:"channel"`
+	LT      Coefficient `json:"lt"`
+	RT      Coefficient `json:"rt"`
+	// LowPass applies the LFE low-pass filter to the channel.
+	LowPass bool `json:"lowpass,omitempty"`
+}
+
+// Matrix is an Nx2 complex decoding matrix, one row per output channel.
+type Matrix struct {
+	Name string      `json:"name,omitempty"`
+	Rows []MatrixRow `json:"outputs"`
+}
+
+// row returns the row of a channel.
+func (m Matrix) row(channel string) (MatrixRow, bool) {
+	for _, row := range m.Rows {
+		if row.Channel == channel {
+			return row, true
+		}
+	}
+	return MatrixRow{}, false
+}
+
+// Channels returns the output channel names in row order.
+func (m Matrix) Channels() []string {
+	names := make([]string, len(m.Rows))
+	for i, row := range m.Rows {
+		names[i] = row.Channel
+	}
+	return names
+}
+
+func (m Matrix) validate() error {
+	if len(m.Rows) == 0 {
+		return fmt.Errorf("matrix %q has no outputs", m.Name)
+	}
+	seen := map[string]bool{}
+	for _, row := range m.Rows {
+		if row.Channel == "" {
+			return fmt.Errorf("matrix %q has an output without channel name", m.Name)
+		}
+		if seen[row.Channel] {
+			return fmt.Errorf("matrix %q has two outputs named %s", m.Name, row.Channel)
+		}
+		seen[row.Channel] = true
+	}
+	return nil
+}
+
+// sqMatrix returns the SQ decoding matrix :
+//
+//	lf = LT
+//	rf = RT
+//	lb = -alpha * (RT - j*LT)
+//	rb = alpha * (LT - j*RT)
+//
+// then blended : lb' = lb + blend*rb, rb' = rb + blend*lb
+func sqMatrix(sq SQCoefficients) Matrix {
+	alpha := complex(sq.Alpha, 0)
+	blend := complex(sq.Blend, 0)
+	j := complex(0, 1)
+
+	lbLT, lbRT := j*alpha, -alpha
+	rbLT, rbRT := alpha, -j*alpha
+
+	return Matrix{
+		Name: "SQ",
+		Rows: []MatrixRow{
+			{Channel: "LF", LT: 1, RT: 0},
+			{Channel: "RF", LT: 0, RT: 1},
+			{Channel: "LB", LT: Coefficient(lbLT + blend*rbLT), RT: Coefficient(lbRT + blend*rbRT)},
+			{Channel: "RB", LT: Coefficient(rbLT + blend*lbLT), RT: Coefficient(rbRT + blend*lbRT)},
+		},
+	}
+}
+
+// qsMatrix returns the QS decoding matrix :
+//
+//	lf = 0.924*LT + 0.383*RT
+//	rf = 0.383*LT + 0.924*RT
+//	lb = j * (0.383*RT - 0.924*LT)
+//	rb = j * (0.383*LT - 0.924*RT)
+func qsMatrix(qs QSCoefficients) Matrix {
+	alpha := complex(qs.Alpha, 0)
+	beta := complex(qs.Beta, 0)
+	j := complex(0, 1)
+
+	return Matrix{
+		Name: "QS",
+		Rows: []MatrixRow{
+			{Channel: "LF", LT: Coefficient(alpha), RT: Coefficient(beta)},
+			{Channel: "RF", LT: Coefficient(beta), RT: Coefficient(alpha)},
+			{Channel: "LB", LT: Coefficient(-j * alpha), RT: Coefficient(j * beta)},
+			{Channel: "RB", LT: Coefficient(j * beta), RT: Coefficient(-j * alpha)},
+		},
+	}
+}
+
+// with5_1 adds the center and LFE rows to a quad matrix, unless it already has them :
+//
+//	center = centerCoeff * (LT + RT)
+//	lfe = lfeCoeff * Lowpassfilter(LT + RT + lb + rb)
+func with5_1(m Matrix, centerCoeff float64, lfeCoeff float64) Matrix {
+	rows := append([]MatrixRow{}, m.Rows...)
+
+	if _, ok := m.row("C"); !ok {
+		rows = append(rows, MatrixRow{Channel: "C", LT: Coefficient(complex(centerCoeff, 0)), RT: Coefficient(complex(centerCoeff, 0))})
+	}
+
+	if _, ok := m.row("LFE"); !ok {
+		lfe := MatrixRow{Channel: "LFE", LT: 1, RT: 1, LowPass: true}
+		for _, back := range []string{"LB", "RB"} {
+			if row, ok := m.row(back); ok {
+				lfe.LT += row.LT
+				lfe.RT += row.RT
+			}
+		}
+		lfe.LT *= Coefficient(complex(lfeCoeff, 0))
+		lfe.RT *= Coefficient(complex(lfeCoeff, 0))
+		rows = append(rows, lfe)
+	}
+
+	return Matrix{Name: m.Name, Rows: rows}
+}
+
+// DecodeOptions are the decoding parameters besides the matrix itself.
+type DecodeOptions struct {
+	SampleRate int
+	LFE        LFEParameters
+	Analog     AnalogParameters
+	// Filters are frequency domain filters applied to LT/RT or to decoded channels.
+	Filters []ChannelFilter
+	// Trims are the gain trims in dB of the decoded channels, by name.
+	Trims map[string]float64
+	// Gain is the gain staging of the decoded channels, after the trims. The mode none keeps the
+	// levels of the matrix, for measurements.
+	Gain GainStaging
+	// Limiter, when enabled, limits the true peaks after the gain staging.
+	Limiter Limiter
+}
+
+// DecodeMatrix decodes LT and RT with any decoding matrix. The FFT loop applies each row
+// to the frequency domain signals, then goes back to the time domain.
+// Returns the decoded channels by name, with the gain staging of opts (by pairs by default).
+func DecodeMatrix(LT []float64, RT []float64, m Matrix, opts DecodeOptions) map[string][]float64 {
+	log.Info("DecodeMatrix...", "matrix", m.Name, "channels", m.Channels(), "analog", opts.Analog.Enabled)
+
+	N := len(LT)
+	if len(RT) != N {
+		log.Error("Input slices LT and RT must have the same length : matrix decoding")
+		panic("Input slices LT and RT must have the same length : matrix decoding")
+	}
+	// Init fourier transform
+	fft := fourier.NewFFT(N)
+
+	// Compute frequency domain signals
+	freqLT := fft.Coefficients(nil, LT)
+	freqRT := fft.Coefficients(nil, RT)
+
+	M := len(freqLT)
+	log.Info("Matrix decoding : Expected FFT output size:", "expected", (N/2)+1, "actual", M)
+
+	applyChannelFilters(opts.Filters, "LT", freqLT, opts.SampleRate, N)
+	applyChannelFilters(opts.Filters, "RT", freqRT, opts.SampleRate, N)
+
+	// Analog mode : responses of the reference and quadrature all-pass cascades for each frequency
+	var reference, quadrature []complex128
+	if opts.Analog.Enabled {
+		freqResolution := float64(opts.SampleRate) / float64(N)
+		reference = make([]complex128, M)
+		quadrature = make([]complex128, M)
+		for i := 0; i < M; i++ {
+			reference[i], quadrature[i] = opts.Analog.responses(float64(i) * freqResolution)
+		}
+		log.Info("Analog phase-shift networks", "referencePolesHz", opts.Analog.ReferencePolesHz, "quadraturePolesHz", opts.Analog.QuadraturePolesHz,
+			"maxPhaseErrorDeg(50Hz-12kHz)", opts.Analog.maxPhaseError(50, 12000))
+	}
+
+	outputs := make(map[string][]float64, len(m.Rows))
+	freqOut := make([]complex128, M)
+	for _, row := range m.Rows {
+		lt := complex128(row.LT)
+		rt := complex128(row.RT)
+		for i := 0; i < M; i++ {
+			if opts.Analog.Enabled {
+				freqOut[i] = opts.Analog.apply(lt, reference[i], quadrature[i])*freqLT[i] + opts.Analog.apply(rt, reference[i], quadrature[i])*freqRT[i]
+			} else {
+				freqOut[i] = lt*freqLT[i] + rt*freqRT[i]
+			}
+		}
+		if row.LowPass {
+			// Appliquer le filtre passe-bas
+			lowPassFilter(freqOut, float64(opts.SampleRate), opts.LFE)
+		}
+		applyChannelFilters(opts.Filters, row.Channel, freqOut, opts.SampleRate, N)
+		// Inverse FFT to go back to time domain
+		outputs[row.Channel] = fft.Sequence(nil, freqOut)
+		// gonum's inverse transform is not scaled by 1/N
+		scale(outputs[row.Channel], 1/float64(N))
+	}
+
+	stageGains(outputs, opts)
+
+	log.Info("DecodeMatrix is done.", "matrix", m.Name)
+
+	return outputs
+}
+
+// normalizeOutputs brings the peak of the front pair and of the back pair together, and of the
+// other channels one by one, to full scale. The first versions did not scale the inverse FFT by
+// 1/N, so their peaks were always above full scale and normalize always brought them back to it :
+// this keeps their levels.
+func normalizeOutputs(outputs map[string][]float64) {
+	var groups [][]string
+	paired := map[string]bool{}
+	for _, pair := range [][]string{{"LF", "RF"}, {"LB", "RB"}} {
+		_, okLeft := outputs[pair[0]]
+		_, okRight := outputs[pair[1]]
+		if okLeft && okRight {
+			groups = append(groups, pair)
+			paired[pair[0]], paired[pair[1]] = true, true
+		}
+	}
+	for name := range outputs {
+		if !paired[name] {
+			groups = append(groups, []string{name})
+		}
+	}
+	for _, group := range groups {
+		var peak float64
+		for _, name := range group {
+			peak = max(peak, maxAbs(outputs[name]))
+		}
+		if peak > 0 {
+			for _, name := range group {
+				scale(outputs[name], 1/peak)
+			}
+		}
+	}
+}
+
+// decodingMatrix returns the matrix of a configuration, with the center and LFE rows for 5.1 and stems.
+func decodingMatrix(cfg Config) Matrix {
+	var m Matrix
+	var centerCoeff float64 = 1 / math.Sqrt(2)
+
+	switch cfg.Matrix {
+	case "QS":
+		m = qsMatrix(cfg.QS)
+	case "custom":
+		m = *cfg.CustomMatrix
+	default:
+		m = sqMatrix(cfg.SQ)
+		centerCoeff = cfg.SQ.Alpha
+	}
+
+	if cfg.AudioFormat == "5.1" || cfg.AudioFormat == "stems" {
+		m = with5_1(m, centerCoeff, cfg.LFE.Coeff())
+	}
+	return m
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// quadSources are the encoder inputs, in the order of the encoding functions.
+var quadSources = []string{"LF", "RF", "LB", "RB"}
+
+// encoderFor returns the encoder matching the matrix of a configuration : the SQ or QS encoder,
+// or for a custom matrix its conjugate transpose (LF, RF, LB and RB rows).
+func encoderFor(cfg Config, m Matrix) func(LF, RF, LB, RB complex128) (complex128, complex128) {
+	switch cfg.Matrix {
+	case "SQ":
+		return encodeSQ
+	case "QS":
+		return encodeQS
+	}
+	var rows [4]MatrixRow
+	for i, name := range quadSources {
+		rows[i], _ = m.row(name)
+	}
+	return func(LF, RF, LB, RB complex128) (complex128, complex128) {
+		var lt, rt complex128
+		for i, c := range []complex128{LF, RF, LB, RB} {
+			lt += conj(rows[i].LT) * c
+			rt += conj(rows[i].RT) * c
+		}
+		return lt, rt
+	}
+}
+
+// conj returns the complex conjugate of a matrix coefficient.
+func conj(c Coefficient) complex128 {
+	return complex(real(c), -imag(c))
+}
+
+// MeasureBand is the separation matrix of one frequency band : DB[source][output] is the level of
+// the output when only the source plays, relative to the level of the source, in dB.
+type MeasureBand struct {
+	Name   string      `json:"name"`
+	LowHz  float64     `json:"low_hz"`
+	HighHz float64     `json:"high_hz"`
+	DB     [][]float64 `json:"db"`
+}
+
+// Measurement is the result of MeasureSeparation.
+type Measurement struct {
+	Matrix      string        `json:"matrix"`
+	Engine      string        `json:"engine"`
+	Analog      bool          `json:"analog"`
+	Signal      string        `json:"signal"`
+	FrequencyHz float64       `json:"frequency_hz,omitempty"`
+	SampleRate  int           `json:"sample_rate"`
+	Sources     []string      `json:"sources"`
+	Outputs     []string      `json:"outputs"`
+	Bands       []MeasureBand `json:"bands"`
+}
+
+// measureFloorDB is the lowest level reported, for outputs which get nothing at all.
+const measureFloorDB = -120
+
+// measureBands are the full band then the octave bands from 63 Hz to 16 kHz.
+func measureBands() []MeasureBand {
+	bands := []MeasureBand{{Name: "all", LowHz: 20, HighHz: 20000}}
+	for _, center := range []float64{63, 125, 250, 500, 1000, 2000, 4000, 8000, 16000} {
+		name := fmt.Sprintf("%g", center)
+		if center >= 1000 {
+			name = fmt.Sprintf("%gk", center/1000)
+		}
+		bands = append(bands, MeasureBand{Name: name, LowHz: center / math.Sqrt2, HighHz: center * math.Sqrt2})
+	}
+	return bands
+}
+
+// bandPowers returns the power of a signal in each band, from its spectrum.
+func bandPowers(fft *fourier.FFT, data []float64, bands []MeasureBand, sampleRate int) []float64 {
+	spectrum := fft.Coefficients(nil, data)
+	freqResolution := float64(sampleRate) / float64(len(data))
+	powers := make([]float64, len(bands))
+	for i, c := range spectrum {
+		f := float64(i) * freqResolution
+		for b, band := range bands {
+			if f >= band.LowHz && f < band.HighHz {
+				powers[b] += real(c)*real(c) + imag(c)*imag(c)
+			}
+		}
+	}
+	return powers
+}
+
+// MeasureSeparation plays a test signal (tone at freq Hz or pink noise) in each quad position in turn,
+// encodes it with the encoder matching the matrix, decodes it with the configuration and measures
+// the level of every output per band. The outputs are not normalized, so that the levels are comparable.
+// Bands where the test signal has no energy (all but one for a tone) are left out. The tone is moved
+// to the nearest FFT bin.
+func MeasureSeparation(cfg Config, signal string, freq float64, sampleRate int, n int) Measurement {
+	m := decodingMatrix(cfg)
+	encode := encoderFor(cfg, m)
+	opts := cfg.decodeOptions(sampleRate)
+	opts.Trims, opts.Gain, opts.Limiter = nil, GainStaging{Mode: "none"}, Limiter{}
+
+	var source []float64
+	if signal == "tone" {
+		// a whole number of periods, so that the tone stays in its band
+		freq = math.Max(1, math.Round(freq*float64(n)/float64(sampleRate))) * float64(sampleRate) / float64(n)
+		source = sineTone(freq, 0.5, sampleRate, n)
+	} else {
+		source = pinkNoise(0.5, n, 1)
+		freq = 0
+	}
+
+	fft := fourier.NewFFT(n)
+	bands := measureBands()
+	sourcePowers := bandPowers(fft, source, bands, sampleRate)
+	silence := make([]float64, n)
+
+	measurement := Measurement{
+		Matrix:      m.Name,
+		Engine:      cfg.Engine,
+		Analog:      cfg.Analog.Enabled,
+		Signal:      signal,
+		FrequencyHz: freq,
+		SampleRate:  sampleRate,
+		Sources:     quadSources,
+		Outputs:     m.Channels(),
+	}
+	for b := range bands {
+		bands[b].DB = make([][]float64, len(quadSources))
+	}
+
+	for s, name := range quadSources {
+		log.Info("Measure separation...", "matrix", m.Name, "source", name, "signal", signal)
+		inputs := [4][]float64{silence, silence, silence, silence}
+		inputs[s] = source
+		LT, RT := encodeMatrix(inputs[0], inputs[1], inputs[2], inputs[3], encode)
+		outputs := decodeWithOptions(LT, RT, m, cfg, opts)
+
+		for b := range bands {
+			bands[b].DB[s] = make([]float64, len(measurement.Outputs))
+		}
+		for o, output := range measurement.Outputs {
+			powers := bandPowers(fft, outputs[output], bands, sampleRate)
+			for b := range bands {
+				level := 10 * math.Log10(powers[b]/sourcePowers[b])
+				if math.IsNaN(level) || level < measureFloorDB {
+					level = measureFloorDB
+				}
+				bands[b].DB[s][o] = math.Round(level*100) / 100
+			}
+		}
+	}
+
+	total := sourcePowers[0]
+	for b, band := range bands {
+		if sourcePowers[b] > total*1e-6 {
+			measurement.Bands = append(measurement.Bands, band)
+		}
+	}
+	return measurement
+}
+
+// printMeasurement writes one separation table per band, sources in rows and outputs in columns.
+func printMeasurement(w io.Writer, measurement Measurement) {
+	signal := measurement.Signal
+	if signal == "tone" {
+		signal = fmt.Sprintf("tone %.1f Hz", measurement.FrequencyHz)
+	}
+	fmt.Fprintf(w, "%s decoding (engine %s, analog %v), %s, level of each output in dB relative to the source\n",
+		measurement.Matrix, measurement.Engine, measurement.Analog, signal)
+	for _, band := range measurement.Bands {
+		fmt.Fprintf(w, "\nband %s (%.0f-%.0f Hz)\n", band.Name, band.LowHz, band.HighHz)
+		fmt.Fprintf(w, "%-8s", "source")
+		for _, output := range measurement.Outputs {
+			fmt.Fprintf(w, "%8s", output)
+		}
+		fmt.Fprintln(w)
+		for s, source := range measurement.Sources {
+			fmt.Fprintf(w, "%-8s", source)
+			for o := range measurement.Outputs {
+				fmt.Fprintf(w, "%8.1f", band.DB[s][o])
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// writeMeasurementJSON writes the measurement as JSON.
+func writeMeasurementJSON(path string, measurement Measurement) error {
+	data, err := json.MarshalIndent(measurement, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding measurement: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing measurement: %w", err)
+	}
+	return nil
+}
+
+// parseSignal checks the -signal flag of measure.
+func parseSignal(signal string) (string, error) {
+	signal = strings.ToLower(signal)
+	if signal != "tone" && signal != "pink" {
+		return "", fmt.Errorf("signal must be tone or pink, not %q", signal)
+	}
+	return signal, nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Metadata of the input file, carried to the decoded files so that they stay traceable.
+// The tags use the Vorbis comment names (TITLE, ARTIST...) : they are mapped to the LIST/INFO
+// ids of the wave files and to the text chunks of the AIFF files. The bext, iXML and ID3 chunks
+// are copied as they are, the coding history of bext gets one more line for the decoding.
+// The cue points (track markers) are in cue.go.
+// In the output files these chunks come after the audio data, the first 44 bytes of a wave file
+// stay the plain PCM header.
+
+// Tag is a NAME=value comment.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Metadata are the tags and the descriptive chunks of an audio file.
+type Metadata struct {
+	Tags []Tag
+	Bext []byte // content of the BWF bext chunk
+	IXML []byte // content of the iXML chunk
+	ID3  []byte // ID3v2 tag
+	Cues []Cue  // cue points, by position
+	// History is the T= text of the coding history line added to bext.
+	History string
+}
+
+// infoNames are the LIST/INFO ids and their Vorbis comment names.
+var infoNames = []struct{ id, name string }{
+	{"INAM", "TITLE"}, {"IART", "ARTIST"}, {"IPRD", "ALBUM"}, {"ICRD", "DATE"}, {"IGNR", "GENRE"},
+	{"ICMT", "COMMENT"}, {"ICOP", "COPYRIGHT"}, {"ISFT", "ENCODER"}, {"IENG", "ENGINEER"},
+	{"ITRK", "TRACKNUMBER"}, {"ISBJ", "SUBJECT"}, {"IKEY", "KEYWORDS"}, {"ISRC", "SOURCE"},
+	{"ITCH", "TECHNICIAN"}, {"IMED", "MEDIUM"},
+}
+
+// aiffNames are the AIFF text chunks and their Vorbis comment names (ANNO chunks are comments).
+var aiffNames = []struct{ id, name string }{
+	{"NAME", "TITLE"}, {"AUTH", "ARTIST"}, {"(c) ", "COPYRIGHT"}, {"ANNO", "COMMENT"},
+}
+
+// Get returns the value of the first tag with this name.
+func (m *Metadata) Get(name string) (string, bool) {
+	for _, tag := range m.Tags {
+		if tag.Name == name {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of the tag, or adds it.
+func (m *Metadata) Set(name string, value string) {
+	for i := range m.Tags {
+		if m.Tags[i].Name == name {
+			m.Tags[i].Value = value
+			return
+		}
+	}
+	m.Tags = append(m.Tags, Tag{name, value})
+}
+
+// empty tells whether there is nothing to write.
+func (m Metadata) empty() bool {
+	return len(m.Tags) == 0 && len(m.Bext) == 0 && len(m.IXML) == 0 && len(m.ID3) == 0 && len(m.Cues) == 0
+}
+
+// addDecoding records the tool and the decoding parameters : ENCODER, DECODING and the bext
+// coding history.
+func (m *Metadata) addDecoding(cfg Config) {
+	tool := "sqdecoder " + toolVersion()
+	m.Set("ENCODER", tool)
+	m.Set("DECODING", cfg.summary())
+	m.History = tool + " " + cfg.summary()
+}
+
+// readMetadata reads the metadata of a wave, FLAC or AIFF file. Raw files have none.
+func readMetadata(s string, in InputOptions) (Metadata, error) {
+	if in.Raw != nil {
+		return Metadata{}, nil
+	}
+	f, err := os.Open(s)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("error opening audio file: %w", err)
+	}
+	defer f.Close()
+
+	format, err := sniffFormat(f)
+	if err != nil {
+		return Metadata{}, err
+	}
+	switch format {
+	case "flac":
+		stream, err := readFLACMetadata(bufio.NewReader(f))
+		if err != nil {
+			return Metadata{}, err
+		}
+		m := Metadata{ID3: stream.id3, Cues: stream.cues}
+		for _, comment := range stream.tags {
+			name, value, ok := strings.Cut(comment, "=")
+			name = strings.ToUpper(name)
+			// the channel mask is the one of the input layout
+			if ok && name != "WAVEFORMATEXTENSIBLE_CHANNEL_MASK" {
+				m.Tags = append(m.Tags, Tag{name, value})
+			}
+		}
+		return m, nil
+	case "aiff":
+		return readAIFFMetadata(f)
+	}
+	return readWaveMetadata(f)
+}
+
+// readWaveMetadata reads the LIST/INFO, bext, iXML and ID3 chunks of a wave file.
+func readWaveMetadata(r io.ReadSeeker) (Metadata, error) {
+	var m Metadata
+	_, chunks, err := walkRIFF(r)
+	if err != nil {
+		return m, err
+	}
+	labels := map[uint32]string{}
+	for _, chunk := range chunks {
+		switch chunk.ID {
+		case "LIST", "bext", "iXML", "id3 ", "ID3 ", "cue ":
+		default:
+			continue
+		}
+		data, err := readChunk(r, chunk)
+		if err != nil {
+			return m, err
+		}
+		switch chunk.ID {
+		case "LIST":
+			m.Tags = append(m.Tags, parseInfoList(data)...)
+			parseLabels(data, labels)
+		case "cue ":
+			m.Cues = parseCueChunk(data)
+		case "bext":
+			m.Bext = data
+		case "iXML":
+			m.IXML = data
+		default:
+			m.ID3 = data
+		}
+	}
+	for i := range m.Cues {
+		m.Cues[i].Label = labels[m.Cues[i].ID]
+	}
+	return m, nil
+}
+
+// parseInfoList returns the tags of a LIST chunk of type INFO : sub-chunks with zero terminated
+// strings. The ids which are not in infoNames keep their id as name.
+func parseInfoList(data []byte) []Tag {
+	if len(data) < 4 || string(data[0:4]) != "INFO" {
+		return nil
+	}
+	var tags []Tag
+	data = data[4:]
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			size = len(data) - 8
+		}
+		value := strings.TrimRight(string(data[8:8+size]), "\x00")
+		name := id
+		for _, info := range infoNames {
+			if info.id == id {
+				name = info.name
+			}
+		}
+		tags = append(tags, Tag{name, value})
+		data = data[min(len(data), 8+size+size%2):]
+	}
+	return tags
+}
+
+// readAIFFMetadata reads the NAME, AUTH, (c), ANNO, MARK and ID3 chunks of an AIFF file.
+func readAIFFMetadata(r io.ReadSeeker) (Metadata, error) {
+	var m Metadata
+	_, chunks, err := walkAIFF(r)
+	if err != nil {
+		return m, err
+	}
+	for _, chunk := range chunks {
+		name := ""
+		for _, text := range aiffNames {
+			if text.id == chunk.ID {
+				name = text.name
+			}
+		}
+		if name == "" && chunk.ID != "ID3 " && chunk.ID != "id3 " && chunk.ID != "MARK" {
+			continue
+		}
+		data, err := readChunk(r, chunk)
+		if err != nil {
+			return m, err
+		}
+		if chunk.ID == "MARK" {
+			m.Cues = parseMarkChunk(data)
+			continue
+		}
+		if name == "" {
+			m.ID3 = data
+			continue
+		}
+		value := strings.TrimRight(string(data), "\x00")
+		// several ANNO chunks make one comment
+		if previous, ok := m.Get(name); ok && name == "COMMENT" {
+			value = previous + "\n" + value
+		}
+		m.Set(name, value)
+	}
+	return m, nil
+}
+
+// textTags splits the tags between the ones with an id in names, by id, and the other ones,
+// which go to the comment as "NAME: value" lines.
+func (m Metadata) textTags(names []struct{ id, name string }, isID func(string) bool) ([]Tag, string) {
+	var tags []Tag
+	comment, _ := m.Get("COMMENT")
+	for _, tag := range m.Tags {
+		if tag.Name == "COMMENT" {
+			continue
+		}
+		id := ""
+		for _, n := range names {
+			if n.name == tag.Name {
+				id = n.id
+			}
+		}
+		if id == "" && isID(tag.Name) {
+			id = tag.Name
+		}
+		if id == "" {
+			if comment != "" {
+				comment += "\n"
+			}
+			comment += tag.Name + ": " + tag.Value
+			continue
+		}
+		tags = append(tags, Tag{id, tag.Value})
+	}
+	return tags, comment
+}
+
+// writeChunk appends a chunk to b, with its pad byte.
+func writeChunk(b *bytes.Buffer, order binary.ByteOrder, id string, data []byte) {
+	b.WriteString(id)
+	binary.Write(b, order, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+}
+
+// waveChunks returns the LIST/INFO, bext, iXML, cue, LIST/adtl and id3 chunks of a wave file
+// with channels channels of 16 bits samples.
+func (m Metadata) waveChunks(sampleRate int, channels int) []byte {
+	var b bytes.Buffer
+	tags, comment := m.textTags(infoNames, func(name string) bool {
+		return len(name) == 4 && name[0] == 'I' && strings.ToUpper(name) == name
+	})
+	if comment != "" {
+		tags = append(tags, Tag{"ICMT", comment})
+	}
+	if len(tags) > 0 {
+		var list bytes.Buffer
+		list.WriteString("INFO")
+		for _, tag := range tags {
+			writeChunk(&list, binary.LittleEndian, tag.Name, append([]byte(tag.Value), 0))
+		}
+		writeChunk(&b, binary.LittleEndian, "LIST", list.Bytes())
+	}
+
+	// bext : 602 bytes of fixed fields, then the coding history, lines ending with CR LF
+	if len(m.Bext) > 0 {
+		bext := m.Bext
+		if len(bext) >= 602 && m.History != "" {
+			history := string(bytes.TrimRight(bext[602:], "\x00"))
+			if history != "" && !strings.HasSuffix(history, "\r\n") {
+				history += "\r\n"
+			}
+			bext = append(bext[:602:602], history+codingHistory(sampleRate, channels, m.History)...)
+		}
+		writeChunk(&b, binary.LittleEndian, "bext", bext)
+	}
+	if len(m.IXML) > 0 {
+		writeChunk(&b, binary.LittleEndian, "iXML", m.IXML)
+	}
+	m.writeCueChunks(&b)
+	if len(m.ID3) > 0 {
+		writeChunk(&b, binary.LittleEndian, "id3 ", m.ID3)
+	}
+	return b.Bytes()
+}
+
+// codingHistory returns a coding history line of EBU R 98 for the written file.
+func codingHistory(sampleRate int, channels int, text string) string {
+	mode := ""
+	switch channels {
+	case 1:
+		mode = ",M=mono"
+	case 2:
+		mode = ",M=stereo"
+	}
+	return fmt.Sprintf("A=PCM,F=%d,W=16%s,T=%s\r\n", sampleRate, mode, text)
+}
+
+// aiffChunks returns the NAME, AUTH, (c), ANNO, MARK and ID3 chunks of an AIFF file.
+func (m Metadata) aiffChunks() []byte {
+	var b bytes.Buffer
+	tags, comment := m.textTags(aiffNames, func(string) bool { return false })
+	if comment != "" {
+		tags = append(tags, Tag{"ANNO", comment})
+	}
+	for _, tag := range tags {
+		writeChunk(&b, binary.BigEndian, tag.Name, []byte(tag.Value))
+	}
+	if len(m.Cues) > 0 {
+		writeChunk(&b, binary.BigEndian, "MARK", m.markChunk())
+	}
+	if len(m.ID3) > 0 {
+		writeChunk(&b, binary.BigEndian, "ID3 ", m.ID3)
+	}
+	return b.Bytes()
+}
+
+// vorbisComments returns the tags as NAME=value comments for the FLAC files.
+func (m Metadata) vorbisComments() []string {
+	var comments []string
+	for _, tag := range m.Tags {
+		comments = append(comments, tag.Name+"="+tag.Value)
+	}
+	return comments
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bextChunk returns a bext chunk content with a description and a coding history.
+func bextChunk(description string, history string) string {
+	fixed := make([]byte, 602)
+	copy(fixed, description)
+	return string(fixed) + history
+}
+
+// taggedWave returns a stereo wave file with LIST/INFO, bext, iXML and id3 chunks around the data.
+func taggedWave(data string) []byte {
+	return riffFile(
+		[2]string{"bext", bextChunk("Transfer of side A", "A=ANALOGUE,M=stereo,T=Studer A80\r\n")},
+		[2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)},
+		[2]string{"iXML", "<BWFXML><PROJECT>quad</PROJECT></BWFXML>"},
+		[2]string{"data", data},
+		[2]string{"LIST", "INFOINAM\x0b\x00\x00\x00Quad album\x00\x00IART\x05\x00\x00\x00Band\x00\x00ICMT\x05\x00\x00\x00mint\x00\x00IXYZ\x02\x00\x00\x00x\x00"},
+		[2]string{"id3 ", "ID3\x03\x00\x00\x00\x00\x00\x00"},
+	)
+}
+
+func TestReadWaveMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagged.wav")
+	if err := os.WriteFile(path, taggedWave(samples(int16(1), int16(2))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMetadata(path, InputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Tag{{"TITLE", "Quad album"}, {"ARTIST", "Band"}, {"COMMENT", "mint"}, {"IXYZ", "x"}}
+	if len(m.Tags) != len(want) {
+		t.Fatalf("tags = %q, want %q", m.Tags, want)
+	}
+	for i := range want {
+		if m.Tags[i] != want[i] {
+			t.Errorf("tag %d = %q, want %q", i, m.Tags[i], want[i])
+		}
+	}
+	if !strings.HasPrefix(string(m.Bext), "Transfer of side A\x00") || len(m.Bext) != 602+34 {
+		t.Errorf("bext = %q", m.Bext)
+	}
+	if string(m.IXML) != "<BWFXML><PROJECT>quad</PROJECT></BWFXML>" || string(m.ID3) != "ID3\x03\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("iXML = %q, ID3 = %q", m.IXML, m.ID3)
+	}
+}
+
+// decodedMetadata returns the metadata of the tagged wave file with the decoding added.
+func decodedMetadata(t *testing.T) Metadata {
+	t.Helper()
+	m, err := readWaveMetadata(bytes.NewReader(taggedWave(samples(int16(1), int16(2)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.addDecoding(testConfig(t, "SQ"))
+	return m
+}
+
+func TestWriteMetadata(t *testing.T) {
+	dir := t.TempDir()
+	in := constantChannels(2, 100)
+
+	t.Run("wav", func(t *testing.T) {
+		path := filepath.Join(dir, "out.wav")
+		if err := writeAudio(path, 44100, []string{"LF", "RF"}, in, OutputOptions{Metadata: decodedMetadata(t)}); err != nil {
+			t.Fatal(err)
+		}
+		header, samples := readPCM16(t, path)
+		if want := expectedHeader(2, 44100, 100); !bytes.Equal(header[8:], want[8:]) || samples[1][99] != pcm16(0.2) {
+			t.Errorf("header % x, last sample %d", header, samples[1][99])
+		}
+		m, err := readMetadata(path, InputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		title, _ := m.Get("TITLE")
+		encoder, _ := m.Get("ENCODER")
+		comment, _ := m.Get("COMMENT")
+		if title != "Quad album" || encoder != "sqdecoder "+toolVersion() || !strings.HasPrefix(comment, "mint\nDECODING: matrix=SQ layout=front/back alpha=0.7071") {
+			t.Errorf("tags = %q", m.Tags)
+		}
+		if _, ok := m.Get("IXYZ"); !ok {
+			t.Errorf("unknown INFO id lost: %q", m.Tags)
+		}
+		history := strings.Split(string(m.Bext[602:]), "\r\n")
+		if len(history) != 3 || history[0] != "A=ANALOGUE,M=stereo,T=Studer A80" ||
+			!strings.HasPrefix(history[1], "A=PCM,F=44100,W=16,M=stereo,T=sqdecoder ") || history[2] != "" {
+			t.Errorf("coding history = %q", history)
+		}
+		if string(m.IXML) != "<BWFXML><PROJECT>quad</PROJECT></BWFXML>" || string(m.ID3) != "ID3\x03\x00\x00\x00\x00\x00\x00" {
+			t.Errorf("iXML = %q, ID3 = %q", m.IXML, m.ID3)
+		}
+	})
+
+	t.Run("aiff", func(t *testing.T) {
+		path := filepath.Join(dir, "out.aiff")
+		if err := writeAudio(path, 44100, []string{"LF", "RF"}, in, OutputOptions{Format: "aiff", Metadata: decodedMetadata(t)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, out, err := readAudio(path); err != nil || len(out[0]) != 100 {
+			t.Fatalf("audio: %v", err)
+		}
+		m, err := readMetadata(path, InputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		title, _ := m.Get("TITLE")
+		artist, _ := m.Get("ARTIST")
+		comment, _ := m.Get("COMMENT")
+		if title != "Quad album" || artist != "Band" || !strings.Contains(comment, "\nENCODER: sqdecoder ") || !strings.Contains(comment, "\nDECODING: matrix=SQ") {
+			t.Errorf("tags = %q", m.Tags)
+		}
+		if string(m.ID3) != "ID3\x03\x00\x00\x00\x00\x00\x00" || m.Bext != nil {
+			t.Errorf("ID3 = %q, bext = %q", m.ID3, m.Bext)
+		}
+	})
+
+	t.Run("flac", func(t *testing.T) {
+		path := filepath.Join(dir, "out.flac")
+		in := constantChannels(4, 100)
+		if err := writeAudio(path, 44100, []string{"LF", "RF", "LB", "RB"}, in, OutputOptions{Format: "flac", Metadata: decodedMetadata(t)}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := readMetadata(path, InputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"TITLE=Quad album", "ARTIST=Band", "COMMENT=mint", "IXYZ=x", "ENCODER=sqdecoder " + toolVersion()}
+		got := m.vorbisComments()
+		if len(got) != len(want)+1 || !strings.HasPrefix(got[len(want)], "DECODING=matrix=SQ") {
+			t.Fatalf("comments = %q", got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("comment %d = %q, want %q", i, got[i], want[i])
+			}
+		}
+	})
+}
+
+// TestDecodeMetadata checks that every decoded file gets the tags of the input and the decoding.
+func TestDecodeMetadata(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "sq_speech_SQ.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "tagged.wav")
+	if err := os.WriteFile(input, taggedWave(string(fixture[44:])), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inDir(t, dir, func() {
+		if err := runDecode([]string{"-input", input, "-audioformat", "stems", "-matrixformat", "QS"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	stems, err := filepath.Glob(filepath.Join(dir, "tagged_QS_*.wav"))
+	if err != nil || len(stems) != 6 {
+		t.Fatalf("stems %q: %v", stems, err)
+	}
+	for _, stem := range stems {
+		m, err := readMetadata(stem, InputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		title, _ := m.Get("TITLE")
+		comment, _ := m.Get("COMMENT")
+		if title != "Quad album" || !strings.Contains(comment, "DECODING: matrix=QS layout=stems alpha=0.924 beta=0.383 lfe=-10dB/") {
+			t.Errorf("%s: tags = %q", stem, m.Tags)
+		}
+		if !strings.Contains(string(m.Bext), "A=PCM,F=44100,W=16,M=mono,T=sqdecoder ") {
+			t.Errorf("%s: bext = %q", stem, m.Bext[602:])
+		}
+	}
+}
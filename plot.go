@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"strings"
+)
+
+// canvas is a small RGBA image with the few drawing primitives the PNG reports need.
+type canvas struct {
+	*image.RGBA
+}
+
+var (
+	colorBackground = color.RGBA{20, 20, 28, 255}
+	colorAxis       = color.RGBA{200, 200, 200, 255}
+	colorGrid       = color.RGBA{60, 60, 72, 255}
+	// channelColors are the colors of the channel curves and markers.
+	channelColors = map[string]color.RGBA{
+		"LF":  {80, 160, 255, 255},
+		"RF":  {255, 120, 80, 255},
+		"LB":  {90, 220, 120, 255},
+		"RB":  {240, 220, 70, 255},
+		"C":   {220, 120, 240, 255},
+		"LFE": {160, 160, 160, 255},
+	}
+)
+
+func newCanvas(width int, height int) *canvas {
+	c := &canvas{image.NewRGBA(image.Rect(0, 0, width, height))}
+	c.fill(0, 0, width, height, colorBackground)
+	return c
+}
+
+// fill paints the rectangle [x0, x1) x [y0, y1).
+func (c *canvas) fill(x0, y0, x1, y1 int, col color.Color) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			c.Set(x, y, col)
+		}
+	}
+}
+
+// line draws a line with Bresenham's algorithm.
+func (c *canvas) line(x0, y0, x1, y1 int, col color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		c.Set(x0, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*e >= dy {
+			e += dy
+			x0 += sx
+		}
+		if 2*e <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// ring draws a circle of radius r.
+func (c *canvas) ring(x, y, r int, col color.Color) {
+	for a := 0; a < 8*r; a++ {
+		angle := 2 * math.Pi * float64(a) / float64(8*r)
+		c.Set(x+int(math.Round(float64(r)*math.Cos(angle))), y+int(math.Round(float64(r)*math.Sin(angle))), col)
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// font3x5 is a tiny bitmap font : 5 rows of 3 bits per glyph, enough for labels and numbers.
+var font3x5 = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7}, '1': {2, 6, 2, 2, 7}, '2': {7, 1, 7, 4, 7}, '3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1}, '5': {7, 4, 7, 1, 7}, '6': {7, 4, 7, 5, 7}, '7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7}, '9': {7, 5, 7, 1, 7},
+	'A': {2, 5, 7, 5, 5}, 'B': {6, 5, 6, 5, 6}, 'C': {3, 4, 4, 4, 3}, 'D': {6, 5, 5, 5, 6},
+	'E': {7, 4, 6, 4, 7}, 'F': {7, 4, 6, 4, 4}, 'G': {3, 4, 5, 5, 3}, 'H': {5, 5, 7, 5, 5},
+	'I': {7, 2, 2, 2, 7}, 'J': {1, 1, 1, 5, 2}, 'K': {5, 5, 6, 5, 5}, 'L': {4, 4, 4, 4, 7},
+	'M': {5, 7, 7, 5, 5}, 'N': {6, 5, 5, 5, 5}, 'O': {2, 5, 5, 5, 2}, 'P': {6, 5, 6, 4, 4},
+	'Q': {2, 5, 5, 6, 3}, 'R': {6, 5, 6, 5, 5}, 'S': {3, 4, 2, 1, 6}, 'T': {7, 2, 2, 2, 2},
+	'U': {5, 5, 5, 5, 7}, 'V': {5, 5, 5, 5, 2}, 'W': {5, 5, 7, 7, 5}, 'X': {5, 5, 2, 5, 5},
+	'Y': {5, 5, 2, 2, 2}, 'Z': {7, 1, 2, 4, 7},
+	'-': {0, 0, 7, 0, 0}, '+': {0, 2, 7, 2, 0}, '.': {0, 0, 0, 0, 2}, ':': {0, 2, 0, 2, 0},
+	'/': {1, 1, 2, 4, 4}, '%': {5, 1, 2, 4, 5}, '(': {2, 4, 4, 4, 2}, ')': {2, 1, 1, 1, 2},
+	'=': {0, 7, 0, 7, 0}, ',': {0, 0, 0, 2, 4}, ' ': {0, 0, 0, 0, 0},
+}
+
+// text writes s with the 3x5 font scaled 2 times, (x, y) is the top left corner.
+func (c *canvas) text(x, y int, s string, col color.Color) {
+	const scale = 2
+	for _, r := range strings.ToUpper(s) {
+		glyph := font3x5[r]
+		for row := 0; row < 5; row++ {
+			for bit := 0; bit < 3; bit++ {
+				if glyph[row]&(4>>bit) != 0 {
+					c.fill(x+bit*scale, y+row*scale, x+(bit+1)*scale, y+(row+1)*scale, col)
+				}
+			}
+		}
+		x += 4 * scale
+	}
+}
+
+// textWidth returns the width in pixels of s written by text.
+func textWidth(s string) int {
+	return len([]rune(s)) * 8
+}
+
+// heatColor maps v in [0, 1] to a black - blue - red - yellow - white color scale.
+func heatColor(v float64) color.RGBA {
+	v = math.Max(0, math.Min(1, v))
+	stops := []color.RGBA{{0, 0, 0, 255}, {30, 30, 160, 255}, {200, 30, 60, 255}, {250, 200, 40, 255}, {255, 255, 255, 255}}
+	position := v * float64(len(stops)-1)
+	i := int(position)
+	if i >= len(stops)-1 {
+		return stops[len(stops)-1]
+	}
+	t := position - float64(i)
+	mix := func(a, b uint8) uint8 { return uint8(float64(a) + t*(float64(b)-float64(a))) }
+	return color.RGBA{mix(stops[i].R, stops[i+1].R), mix(stops[i].G, stops[i+1].G), mix(stops[i].B, stops[i+1].B), 255}
+}
+
+// save writes the canvas as a PNG file.
+func (c *canvas) save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating PNG file: %w", err)
+	}
+	if err := png.Encode(file, c); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing PNG file: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Every quad direction is encoded as an (LT, RT) amplitude ratio and phase difference, that is
+// a point of the Poincaré sphere. The analysis locates the time-frequency tiles of a recording on
+// the sphere and compares them with the positions used by the SQ and QS encoders.
+
+// poincarePosition is a direction of the sound stage as encoded by a matrix.
+type poincarePosition struct {
+	Matrix    string
+	Name      string
+	Direction [3]float64
+}
+
+// encodedPositions returns the four corners and the four intermediate positions (center front,
+// center back, left side, right side) of the SQ and QS encoders.
+func encodedPositions() []poincarePosition {
+	h := complex(1/math.Sqrt(2), 0)
+	sources := []struct {
+		name           string
+		lf, rf, lb, rb complex128
+	}{
+		{"LF", 1, 0, 0, 0},
+		{"RF", 0, 1, 0, 0},
+		{"LB", 0, 0, 1, 0},
+		{"RB", 0, 0, 0, 1},
+		{"CF", h, h, 0, 0},
+		{"CB", 0, 0, h, h},
+		{"LS", h, 0, h, 0},
+		{"RS", 0, h, 0, h},
+	}
+	encoders := []struct {
+		matrix string
+		encode func(LF, RF, LB, RB complex128) (complex128, complex128)
+	}{
+		{"SQ", encodeSQ},
+		{"QS", encodeQS},
+	}
+
+	var positions []poincarePosition
+	for _, encoder := range encoders {
+		for _, source := range sources {
+			var s stokes
+			s.add(encoder.encode(source.lf, source.rf, source.lb, source.rb))
+			_, direction := s.polarization()
+			positions = append(positions, poincarePosition{Matrix: encoder.matrix, Name: source.name, Direction: direction})
+		}
+	}
+	return positions
+}
+
+// sphereCoordinates returns the amplitude angle (0° = LT only, 45° = LT and RT equal, 90° = RT only)
+// and the phase of LT relative to RT in degrees, of a direction of the sphere.
+func sphereCoordinates(direction [3]float64) (float64, float64) {
+	theta := 0.5 * math.Acos(math.Max(-1, math.Min(1, direction[0]))) * 180 / math.Pi
+	if math.Hypot(direction[1], direction[2]) < 1e-6 {
+		return theta, 0 // LT only or RT only, the phase means nothing
+	}
+	phase := math.Atan2(direction[2], direction[1]) * 180 / math.Pi
+	return theta, phase
+}
+
+// angularDistance returns the angle in degrees between two directions of the sphere.
+func angularDistance(a, b [3]float64) float64 {
+	dot := a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+	return math.Acos(math.Max(-1, math.Min(1, dot))) * 180 / math.Pi
+}
+
+// nearestPosition returns the encoded position of a matrix closest to a direction and its distance.
+func nearestPosition(positions []poincarePosition, matrix string, direction [3]float64) (string, float64) {
+	name, distance := "", math.Inf(1)
+	for _, p := range positions {
+		if p.Matrix != matrix {
+			continue
+		}
+		if d := angularDistance(p.Direction, direction); d < distance {
+			name, distance = p.Name, d
+		}
+	}
+	return name, distance
+}
+
+// PoincareWindow is the LT/RT relationship over one analysis window.
+type PoincareWindow struct {
+	Time      float64 // start of the window in seconds
+	LevelDB   float64 // LT+RT energy relative to the loudest window
+	Degree    float64 // degree of polarization, 1 for a single source
+	Direction [3]float64
+	RatioDB   float64 // LT/RT amplitude ratio
+	PhaseDeg  float64 // phase of LT relative to RT
+	SQ        string  // nearest SQ position
+	QS        string  // nearest QS position
+}
+
+// PoincareReport is the result of AnalyzePoincare.
+type PoincareReport struct {
+	Windows []PoincareWindow
+	// Polarized is the share of the energy that comes from well localized sources.
+	Polarized float64
+	// SQShare and QSShare give the share of the polarized energy nearest to each encoded position.
+	SQShare map[string]float64
+	QSShare map[string]float64
+	// SQDistance and QSDistance are the mean angular distances in degrees to the nearest encoded position.
+	SQDistance float64
+	QSDistance float64
+
+	tiles [][]stokes
+}
+
+// AnalyzePoincare locates LT/RT on the Poincaré sphere, per window and per time-frequency tile,
+// with the same tiles as DetectMatrix.
+func AnalyzePoincare(LT []float64, RT []float64, sampleRate int) PoincareReport {
+	const windowSize = 2048
+	positions := encodedPositions()
+	report := PoincareReport{
+		SQShare: map[string]float64{},
+		QSShare: map[string]float64{},
+		tiles:   stokesTiles(LT, RT, sampleRate, windowSize, 16, 100, 8000),
+	}
+
+	var total, polarized, sqDistance, qsDistance float64
+	loudest := math.Inf(-1)
+	for i, frame := range report.tiles {
+		var window stokes
+		for _, tile := range frame {
+			window.s0 += tile.s0
+			window.s1 += tile.s1
+			window.s2 += tile.s2
+			window.s3 += tile.s3
+
+			degree, direction := tile.polarization()
+			weight := tile.s0 * degree
+			total += tile.s0
+			if weight == 0 {
+				continue
+			}
+			polarized += weight
+			sqName, sqDist := nearestPosition(positions, "SQ", direction)
+			qsName, qsDist := nearestPosition(positions, "QS", direction)
+			report.SQShare[sqName] += weight
+			report.QSShare[qsName] += weight
+			sqDistance += weight * sqDist
+			qsDistance += weight * qsDist
+		}
+
+		degree, direction := window.polarization()
+		theta, phase := sphereCoordinates(direction)
+		ratioDB := 60.0
+		if theta > 0 {
+			ratioDB = math.Max(-60, math.Min(60, 20*math.Log10(1/math.Tan(theta*math.Pi/180))))
+		}
+		sqName, _ := nearestPosition(positions, "SQ", direction)
+		qsName, _ := nearestPosition(positions, "QS", direction)
+		levelDB := 10 * math.Log10(window.s0)
+		loudest = math.Max(loudest, levelDB)
+		report.Windows = append(report.Windows, PoincareWindow{
+			Time:      float64(i*windowSize) / float64(sampleRate),
+			LevelDB:   levelDB,
+			Degree:    degree,
+			Direction: direction,
+			RatioDB:   ratioDB,
+			PhaseDeg:  phase,
+			SQ:        sqName,
+			QS:        qsName,
+		})
+	}
+	for i := range report.Windows {
+		report.Windows[i].LevelDB = math.Max(-120, report.Windows[i].LevelDB-loudest)
+	}
+
+	if total == 0 || polarized == 0 {
+		return report
+	}
+	report.Polarized = polarized / total
+	for name := range report.SQShare {
+		report.SQShare[name] /= polarized
+	}
+	for name := range report.QSShare {
+		report.QSShare[name] /= polarized
+	}
+	report.SQDistance = sqDistance / polarized
+	report.QSDistance = qsDistance / polarized
+
+	log.Info("AnalyzePoincare is done.", "windows", len(report.Windows), "polarized", report.Polarized,
+		"sqDistance", report.SQDistance, "qsDistance", report.QSDistance)
+
+	return report
+}
+
+// printPoincare writes the predominant directions of the recording for both encodings.
+func printPoincare(w io.Writer, report PoincareReport) {
+	fmt.Fprintf(w, "Poincaré sphere (polarized energy %.1f%%):\n", 100*report.Polarized)
+	closest := "SQ"
+	if report.QSDistance < report.SQDistance {
+		closest = "QS"
+	}
+	fmt.Fprintf(w, "mean distance to the nearest position : SQ %.1f°, QS %.1f° (closest to %s)\n", report.SQDistance, report.QSDistance, closest)
+	for _, matrix := range []string{"SQ", "QS"} {
+		share := report.SQShare
+		if matrix == "QS" {
+			share = report.QSShare
+		}
+		names := make([]string, 0, len(share))
+		for name := range share {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return share[names[i]] > share[names[j]] })
+		fmt.Fprintf(w, "%s directions:", matrix)
+		for _, name := range names {
+			if share[name] >= 0.001 {
+				fmt.Fprintf(w, " %s %.1f%%", name, 100*share[name])
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writePoincareCSV writes one line per analysis window.
+func writePoincareCSV(path string, report PoincareReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating CSV file: %w", err)
+	}
+	defer file.Close()
+
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"time_s", "level_db", "degree", "s1", "s2", "s3", "ratio_db", "phase_deg", "nearest_sq", "nearest_qs"})
+	for _, window := range report.Windows {
+		writer.Write([]string{
+			format(window.Time), format(window.LevelDB), format(window.Degree),
+			format(window.Direction[0]), format(window.Direction[1]), format(window.Direction[2]),
+			format(window.RatioDB), format(window.PhaseDeg), window.SQ, window.QS,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV file: %w", err)
+	}
+	return file.Close()
+}
+
+// writePoincarePNG draws the sphere as a map : phase of LT relative to RT horizontally, amplitude
+// angle vertically (LT only at the top, RT only at the bottom). The heatmap is the polarized energy
+// of the tiles (40 dB range), the rings are the SQ (red) and QS (cyan) encoded positions.
+func writePoincarePNG(path string, report PoincareReport) error {
+	const (
+		left, top     = 70, 40
+		width, height = 720, 360 // 2 pixels per degree of phase, 4 pixels per degree of amplitude angle
+		cell          = 4
+	)
+	c := newCanvas(left+width+30, top+height+50)
+
+	// histogram of the polarized energy, one cell per 2° of phase and 1° of amplitude angle
+	columns, rows := width/cell, height/cell
+	histogram := make([]float64, columns*rows)
+	for _, frame := range report.tiles {
+		for _, tile := range frame {
+			degree, direction := tile.polarization()
+			if degree == 0 {
+				continue
+			}
+			theta, phase := sphereCoordinates(direction)
+			column := min(columns-1, int((phase+180)/360*float64(columns)))
+			row := min(rows-1, int(theta/90*float64(rows)))
+			histogram[row*columns+column] += tile.s0 * degree
+		}
+	}
+	var peak float64
+	for _, v := range histogram {
+		peak = math.Max(peak, v)
+	}
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			v := histogram[row*columns+column]
+			if v == 0 || peak == 0 {
+				continue
+			}
+			x, y := left+column*cell, top+row*cell
+			c.fill(x, y, x+cell, y+cell, heatColor(1+math.Log10(v/peak)/4))
+		}
+	}
+
+	// grid and axes
+	for _, phase := range []float64{-180, -90, 0, 90, 180} {
+		x := left + int((phase+180)/360*width)
+		c.line(x, top, x, top+height, colorGrid)
+		label := strconv.Itoa(int(phase))
+		c.text(x-textWidth(label)/2, top+height+8, label, colorAxis)
+	}
+	for _, tick := range []struct {
+		theta float64
+		label string
+	}{{0, "LT"}, {22.5, "+7.7DB"}, {45, "0DB"}, {67.5, "-7.7DB"}, {90, "RT"}} {
+		y := top + int(tick.theta/90*height)
+		c.line(left, y, left+width, y, colorGrid)
+		c.text(left-textWidth(tick.label)-6, y-5, tick.label, colorAxis)
+	}
+	c.text(left+width/2-textWidth("PHASE LT-RT (DEG)")/2, top+height+28, "PHASE LT-RT (DEG)", colorAxis)
+	c.text(left, 12, "POINCARE SPHERE  LT/RT RATIO VS PHASE", colorAxis)
+
+	sqColor := color.RGBA{255, 80, 80, 255}
+	qsColor := color.RGBA{80, 220, 255, 255}
+	c.text(left+width-textWidth("SQ QS"), 12, "SQ", sqColor)
+	c.text(left+width-textWidth("QS"), 12, "QS", qsColor)
+
+	for _, p := range encodedPositions() {
+		theta, phase := sphereCoordinates(p.Direction)
+		x := left + int((phase+180)/360*width)
+		y := top + int(theta/90*height)
+		col, radius, dy := sqColor, 6, -16
+		if p.Matrix == "QS" {
+			col, radius, dy = qsColor, 9, 12
+		}
+		c.ring(x, y, radius, col)
+		c.text(x-textWidth(p.Name)/2, y+dy, p.Name, col)
+	}
+
+	return c.save(path)
+}
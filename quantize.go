@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Quantisation of the float samples to the integers of the output files : rounded to the
+// nearest step, saturated at full scale (with a count of the clipped samples per channel, a
+// decoded center can exceed 1 after the mixing), with an optional dither :
+// - none : plain rounding
+// - tpdf : triangular dither of +/-1 step, which turns the quantisation distortion of the quiet
+//   passages (fade outs) into a constant hiss
+// - shaped : the same dither with a second order error feedback, which moves the noise up,
+//   where the ear is less sensitive (lower below a sixth of the sample rate, higher above)
+// Float outputs are not quantised.
+
+// dithers are the values of -dither.
+var dithers = []string{"none", "tpdf", "shaped"}
+
+// parseDither checks the -dither flag.
+func parseDither(value string) (string, error) {
+	for _, dither := range dithers {
+		if strings.ToLower(value) == dither {
+			return dither, nil
+		}
+	}
+	if value == "" {
+		return "none", nil
+	}
+	return "", fmt.Errorf("dither must be %s, not %q", strings.Join(dithers, ", "), value)
+}
+
+// quantizer converts the samples of the channels of a file to integers of bits bits.
+type quantizer struct {
+	dither   string
+	scale    float64 // full scale : 2^(bits-1)-1
+	random   *rand.Rand
+	clipped  []int64      // per channel
+	peaks    []float64    // per channel, before the saturation
+	feedback [][2]float64 // per channel, the last two quantisation errors for the noise shaping
+}
+
+// newQuantizer returns a quantizer of channels channels to bits bits. The dither noise is the
+// same from one run to the other.
+func newQuantizer(channels int, bits int, dither string) *quantizer {
+	return &quantizer{
+		dither:   dither,
+		scale:    float64(int64(1)<<(bits-1) - 1),
+		random:   rand.New(rand.NewSource(1)),
+		clipped:  make([]int64, channels),
+		peaks:    make([]float64, channels),
+		feedback: make([][2]float64, channels),
+	}
+}
+
+// quantize returns the integer value of the sample x of the channel c : -1..1 is scaled to
+// -(2^(bits-1)-1)..2^(bits-1)-1, and saturated to -2^(bits-1)..2^(bits-1)-1.
+func (q *quantizer) quantize(c int, x float64) int64 {
+	q.peaks[c] = max(q.peaks[c], math.Abs(x))
+	v := x * q.scale
+	if q.dither == "shaped" {
+		// noise transfer function (1 - z^-1)^2
+		v += -2*q.feedback[c][0] + q.feedback[c][1]
+	}
+	dithered := v
+	if q.dither == "tpdf" || q.dither == "shaped" {
+		dithered += q.random.Float64() - q.random.Float64()
+	}
+	rounded := math.Round(dithered)
+	if rounded > q.scale || rounded < -q.scale-1 {
+		// saturated : the error is not fed back, it would make the next samples clip too
+		q.clipped[c]++
+		q.feedback[c] = [2]float64{}
+		return int64(max(-q.scale-1, min(q.scale, rounded)))
+	}
+	// the error fed back includes the dither, which is shaped too
+	q.feedback[c] = [2]float64{rounded - v, q.feedback[c][0]}
+	return int64(rounded)
+}
+
+// report logs the number of clipped samples of each channel of the file s, names being the
+// channels.
+func (q *quantizer) report(s string, names []string) {
+	for c, clipped := range q.clipped {
+		if clipped == 0 {
+			continue
+		}
+		name := fmt.Sprint(c + 1)
+		if c < len(names) {
+			name = names[c]
+		}
+		log.Warn("Clipped samples", "output", s, "channel", name, "clipped", clipped, "peak", fmt.Sprintf("%+.2f dBFS", 20*math.Log10(q.peaks[c])))
+	}
+}
+
+// pcm16 converts a sample to 16 bits, full scale being 32767 : rounded and saturated, without
+// dither nor clipping count.
+func pcm16(x float64) int16 {
+	return int16(max(math.MinInt16, min(math.MaxInt16, math.Round(x*math.MaxInt16))))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+func TestQuantize(t *testing.T) {
+	const step = 1.0 / 32767
+	tests := []struct {
+		name    string
+		bits    int
+		x       float64
+		want    int64
+		clipped int64
+	}{
+		{"rounded up", 16, 0.6 * step, 1, 0},
+		{"rounded down", 16, 0.4 * step, 0, 0},
+		{"negative", 16, -2.5 * step, -3, 0},
+		{"full scale", 16, 1, 32767, 0},
+		{"negative full scale", 16, -1, -32767, 0},
+		{"last negative step", 16, -32768 * step, -32768, 0},
+		{"saturated", 16, 1.5, 32767, 1},
+		{"negative saturated", 16, -1.5, -32768, 1},
+		{"24 bits", 24, 0.5, 4194304, 0},
+		{"24 bits saturated", 24, 2, 8388607, 1},
+		{"8 bits", 8, -0.5, -64, 0},
+	}
+	for _, tt := range tests {
+		q := newQuantizer(1, tt.bits, "none")
+		if got := q.quantize(0, tt.x); got != tt.want || q.clipped[0] != tt.clipped {
+			t.Errorf("%s: quantize(%v) = %d with %d clipped, want %d with %d", tt.name, tt.x, got, q.clipped[0], tt.want, tt.clipped)
+		}
+	}
+}
+
+// lowBandNoise returns the power of the quantisation error of quiet samples below 1/32 of the
+// sample rate.
+func lowBandNoise(dither string) float64 {
+	const n = 1 << 16
+	q := newQuantizer(1, 16, dither)
+	errors := make([]float64, n)
+	for i := range errors {
+		x := 0.3 * math.Sin(float64(i)/10) / 32767
+		errors[i] = float64(q.quantize(0, x)) - x*32767
+	}
+	var power float64
+	for _, c := range fourier.NewFFT(n).Coefficients(nil, errors)[:n/32] {
+		power += real(c)*real(c) + imag(c)*imag(c)
+	}
+	return power
+}
+
+func TestDither(t *testing.T) {
+	// a signal of less than a step disappears without dither, and stays in the mean with TPDF
+	const n = 100000
+	for _, dither := range []string{"none", "tpdf", "shaped"} {
+		q := newQuantizer(1, 16, dither)
+		var sum float64
+		for range n {
+			v := q.quantize(0, 0.25/32767)
+			if v < -6 || v > 6 {
+				t.Fatalf("%s: sample %d for a quarter of a step", dither, v)
+			}
+			sum += float64(v)
+		}
+		mean := sum / n
+		if want := map[string]float64{"none": 0, "tpdf": 0.25, "shaped": 0.25}[dither]; math.Abs(mean-want) > 0.01 {
+			t.Errorf("%s: mean %.4f, want %.2f", dither, mean, want)
+		}
+	}
+
+	// the noise shaping lowers the noise at the low frequencies
+	tpdf, shaped := lowBandNoise("tpdf"), lowBandNoise("shaped")
+	if 10*math.Log10(tpdf/shaped) < 20 {
+		t.Errorf("low band noise %.2e with noise shaping, %.2e with TPDF only", shaped, tpdf)
+	}
+
+	if _, err := parseDither("rpdf"); err == nil {
+		t.Errorf("rpdf dither accepted")
+	}
+}
+
+func TestWriterSaturation(t *testing.T) {
+	// a center above full scale must saturate, not wrap around to negative samples
+	in := [][]float64{{0.5, 1.2, -1.7, 1}, {0, 0, 0, 0}}
+	path := filepath.Join(t.TempDir(), "clipped.wav")
+	if err := writeAudio(path, 44100, []string{"C", "LFE"}, in, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, samples := readPCM16(t, path)
+	want := []int16{16384, 32767, -32768, 32767}
+	for i := range want {
+		if samples[0][i] != want[i] {
+			t.Errorf("frame %d = %d, want %d", i, samples[0][i], want[i])
+		}
+	}
+}
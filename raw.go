@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Raw PCM : interleaved samples without any header, as written by the capture tools. The sample
+// rate, the channels and the sample format are given on the command line.
+
+// RawFormat describes the samples of a raw file.
+type RawFormat struct {
+	SampleRate int
+	Channels   int
+	Bits       int  // 8, 16, 24 or 32 for integer samples, 32 or 64 for float samples
+	Float      bool // IEEE float samples
+	BigEndian  bool
+}
+
+// waveInfo returns the format of the raw samples, checked, for frames frames.
+func (raw RawFormat) waveInfo(frames int64) (WaveInfo, error) {
+	info := WaveInfo{
+		Container:     "raw",
+		FormatCode:    waveFormatPCM,
+		Channels:      raw.Channels,
+		SampleRate:    raw.SampleRate,
+		BitsPerSample: raw.Bits,
+		ValidBits:     raw.Bits,
+		BigEndian:     raw.BigEndian,
+		Frames:        frames,
+	}
+	if raw.Float {
+		info.FormatCode = waveFormatFloat
+	}
+	info.SubFormat = info.FormatCode
+
+	if raw.Channels < 1 {
+		return info, fmt.Errorf("raw input needs the number of channels (-raw-channels)")
+	}
+	if raw.SampleRate < 1 {
+		return info, fmt.Errorf("raw input needs the sample rate (-raw-rate)")
+	}
+	switch {
+	case raw.Float && (raw.Bits == 32 || raw.Bits == 64):
+	case !raw.Float && (raw.Bits == 8 || raw.Bits == 16 || raw.Bits == 24 || raw.Bits == 32):
+	default:
+		return info, fmt.Errorf("unsupported raw samples: %d bits, float %v", raw.Bits, raw.Float)
+	}
+	return info, nil
+}
+
+// decodeRaw reads all the channels of a raw file. A last incomplete frame is ignored.
+func decodeRaw(r io.ReadSeeker, raw RawFormat) (WaveInfo, [][]float64, error) {
+	info, err := raw.waveInfo(0)
+	if err != nil {
+		return info, nil, err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return info, nil, fmt.Errorf("error reading file size: %w", err)
+	}
+	blockAlign := int64(info.Channels * info.bytesPerSample())
+	info.Frames = size / blockAlign
+	if size%blockAlign != 0 {
+		log.Warn("Raw file size is not a whole number of frames, check -raw-channels and -raw-bits", "bytes", size, "frameBytes", blockAlign)
+	}
+	channels, err := decodeFrames(r, info, 0)
+	return info, channels, err
+}
+
+// readRaw reads all the channels of a raw file.
+func readRaw(s string, raw RawFormat) (WaveInfo, [][]float64, error) {
+	f, err := os.Open(s)
+	if err != nil {
+		return WaveInfo{}, nil, fmt.Errorf("error opening raw file: %w", err)
+	}
+	defer f.Close()
+	return decodeRaw(f, raw)
+}
+
+// writeRaw writes channels as interleaved raw samples in the format of opts.Raw (16 bits little
+// endian when its Bits is 0). The sample rate and the channels are not in the file, they are logged.
+func writeRaw(s string, sampleRate int, names []string, channels [][]float64, opts OutputOptions) error {
+	raw := opts.Raw
+	frames := len(channels[0])
+	for _, channel := range channels {
+		if len(channel) != frames {
+			return fmt.Errorf("all channels must be the same length")
+		}
+	}
+	if raw.Bits == 0 {
+		raw.Bits = 16
+	}
+	raw.SampleRate, raw.Channels = sampleRate, len(channels)
+	info, err := raw.waveInfo(int64(frames))
+	if err != nil {
+		return err
+	}
+	endian := "little"
+	if raw.BigEndian {
+		endian = "big"
+	}
+	log.Info("Raw output", "ouput", s, "sampleRate", sampleRate, "channels", len(channels), "bits", raw.Bits, "float", raw.Float, "endian", endian)
+
+	outFile, err := os.Create(s)
+	if err != nil {
+		return fmt.Errorf("error creating raw file: %w", err)
+	}
+	defer outFile.Close()
+	w := bufio.NewWriterSize(outFile, 1<<16)
+
+	q := newQuantizer(len(channels), raw.Bits, opts.Dither)
+	encode := sampleEncoder(info, q)
+	sample := make([]byte, info.bytesPerSample())
+	for i := 0; i < frames; i++ {
+		for c, channel := range channels {
+			encode(sample, c, channel[i])
+			if _, err := w.Write(sample); err != nil {
+				return fmt.Errorf("error writing audio data: %w", err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing audio data: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("error closing raw file: %w", err)
+	}
+	q.report(s, names)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestDecodeRaw(t *testing.T) {
+	// two frames of the stereo values (0.5, -0.25) then (-1, 1) in each format
+	const half, quarter = 0.5, 0.25
+	tests := []struct {
+		name     string
+		raw      RawFormat
+		data     string
+		accuracy float64
+	}{
+		{"s16le", RawFormat{Bits: 16}, samples(int16(16384), int16(-8192), int16(-32767), int16(32767)), 1e-4},
+		{"s16be", RawFormat{Bits: 16, BigEndian: true}, bigEndian(int16(16384), int16(-8192), int16(-32767), int16(32767)), 1e-4},
+		{"s24le", RawFormat{Bits: 24}, "\x00\x00\x40" + "\x00\x00\xe0" + "\x01\x00\x80" + "\xff\xff\x7f", 1e-6},
+		{"s24be", RawFormat{Bits: 24, BigEndian: true}, "\x40\x00\x00" + "\xe0\x00\x00" + "\x80\x00\x01" + "\x7f\xff\xff", 1e-6},
+		{"s8 signed", RawFormat{Bits: 8}, "\x40\xe0\x81\x7f", 1e-2},
+		{"f32be", RawFormat{Bits: 32, Float: true, BigEndian: true}, bigEndian(float32(half), float32(-quarter), float32(-1), float32(1)), 0},
+		{"f64le", RawFormat{Bits: 64, Float: true}, samples(half, -quarter, -1.0, 1.0), 0},
+		{"incomplete last frame", RawFormat{Bits: 16}, samples(int16(16384), int16(-8192), int16(-32767), int16(32767)) + "\x01\x02\x03", 1e-4},
+	}
+
+	want := [][]float64{{half, -1}, {-quarter, 1}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.raw.SampleRate, tt.raw.Channels = 48000, 2
+			info, channels, err := decodeRaw(bytes.NewReader([]byte(tt.data)), tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Container != "raw" || info.Channels != 2 || info.SampleRate != 48000 || info.Frames != 2 {
+				t.Errorf("info = %+v", info)
+			}
+			for c := range want {
+				for i := range want[c] {
+					if d := channels[c][i] - want[c][i]; d > tt.accuracy || d < -tt.accuracy {
+						t.Errorf("channel %d frame %d = %v, want %v", c, i, channels[c][i], want[c][i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRawErrors(t *testing.T) {
+	for _, raw := range []RawFormat{
+		{SampleRate: 44100, Channels: 0, Bits: 16},
+		{SampleRate: 0, Channels: 2, Bits: 16},
+		{SampleRate: 44100, Channels: 2, Bits: 12},
+		{SampleRate: 44100, Channels: 2, Bits: 16, Float: true},
+	} {
+		if _, _, err := decodeRaw(bytes.NewReader(make([]byte, 16)), raw); err == nil {
+			t.Errorf("%+v: no error", raw)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-raw-endian", "middle"},
+		{"-input-format", "mp3"},
+		{"-raw-bits", "20"},
+	} {
+		var inputs inputFlags
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		inputs.register(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := inputs.resolve(); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	in := [][]float64{{0.5, -1, 1, 0.123456789}, {-0.25, 0, -0.999, 1e-9}}
+	dir := t.TempDir()
+	for _, raw := range []RawFormat{
+		{Bits: 8}, {Bits: 16}, {Bits: 24, BigEndian: true}, {Bits: 32}, {Bits: 32, Float: true}, {Bits: 64, Float: true, BigEndian: true},
+	} {
+		path := filepath.Join(dir, "test.raw")
+		if err := writeRaw(path, 96000, nil, in, OutputOptions{Raw: raw}); err != nil {
+			t.Fatal(err)
+		}
+		raw.SampleRate, raw.Channels = 96000, 2
+		info, out, err := readRaw(path, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Frames != 4 {
+			t.Fatalf("%+v: %d frames", raw, info.Frames)
+		}
+		// integer samples are rounded : at most half a step from the input
+		step := 0.5 / float64(int64(1)<<(raw.Bits-1)-1)
+		if raw.Float {
+			step = 1e-7
+		}
+		for c := range in {
+			for i := range in[c] {
+				if d := in[c][i] - out[c][i]; d > step || d < -step {
+					t.Errorf("%+v: channel %d frame %d = %v, want %v", raw, c, i, out[c][i], in[c][i])
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeRawInputOutput decodes the data chunk of a wave file as raw input with raw outputs :
+// the outputs must be the data chunks of the wave outputs.
+func TestDecodeRawInputOutput(t *testing.T) {
+	input, err := filepath.Abs(filepath.Join("testdata", "sq_speech_SQ.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := readWaveInfo(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wave, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawDir := t.TempDir()
+	rawInput := filepath.Join(rawDir, "sq_speech_SQ.raw")
+	if err := os.WriteFile(rawInput, wave[44:], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	waveDir := t.TempDir()
+	inDir(t, waveDir, func() {
+		if err := runDecode([]string{"-input", input, "-audioformat", "4.0"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	inDir(t, rawDir, func() {
+		args := []string{"-input", rawInput, "-input-format", "raw", "-raw-rate", strconv.Itoa(info.SampleRate), "-audioformat", "4.0", "-outformat", "raw"}
+		if err := runDecode(args); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	want, err := os.ReadFile(filepath.Join(waveDir, "sq_speech_SQ_4_0.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(rawDir, "sq_speech_SQ_4_0.raw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the metadata chunks follow the data chunk of the wave file
+	want = want[44 : 44+binary.LittleEndian.Uint32(want[40:44])]
+	if !bytes.Equal(got, want) {
+		t.Errorf("raw output (%d bytes) differs from the wave data chunk (%d bytes)", len(got), len(want))
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Sample rate conversion, polyphase windowed sinc :
+// - the ratio is reduced to up/down (160/147 from 44.1 to 48 kHz) : the output sample n is at
+//   n*down/up input samples, between the input samples base and base+1 at the phase
+//   (n*down mod up)/up
+// - each phase has its own coefficients, a Kaiser windowed sinc shifted by the phase, cut at
+//   the lower of the two Nyquist frequencies (a little below, the rolloff, to leave room for
+//   the transition band)
+// - above maxPhases phases (odd ratios, 44100 to 47999), the coefficients are interpolated
+//   between the nearest two of a table of maxPhases phases
+// The quality chooses the length of the filter (zero crossings of the sinc on each side), the
+// Kaiser beta (stopband attenuation) and the rolloff.
+
+// resampleQuality are the parameters of a quality of the resampler.
+type resampleQuality struct {
+	zeroCrossings int
+	beta          float64
+	rolloff       float64
+}
+
+// resampleQualities are the values of -resample-quality.
+var resampleQualities = map[string]resampleQuality{
+	"fast":     {zeroCrossings: 8, beta: 6, rolloff: 0.85},
+	"standard": {zeroCrossings: 24, beta: 9, rolloff: 0.92},
+	"best":     {zeroCrossings: 64, beta: 12, rolloff: 0.96},
+}
+
+// maxPhases is the largest table of phases, interpolated above.
+const maxPhases = 4096
+
+// parseResampleQuality checks a -resample-quality value.
+func parseResampleQuality(s string) (string, error) {
+	s = strings.ToLower(s)
+	if _, ok := resampleQualities[s]; !ok {
+		return "", fmt.Errorf("resample quality must be fast, standard or best, not %q", s)
+	}
+	return s, nil
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// besselI0 is the modified Bessel function of the first kind, order 0, for the Kaiser window.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+// Resampler converts from one sample rate to another.
+type Resampler struct {
+	up, down int
+	// half is the number of input samples on each side of the output position
+	half int
+	// phases are the coefficients of the phases, phases[p][k] for the input sample
+	// base-half+1+k at the phase p/(len(phases)-1)
+	phases [][]float64
+	exact  bool
+}
+
+// newResampler returns a resampler from the rate from to the rate to.
+func newResampler(from, to int, quality string) *Resampler {
+	q := resampleQualities[quality]
+	g := gcd(from, to)
+	r := &Resampler{up: to / g, down: from / g}
+
+	// cut-off in cycles per input sample, and half length of the sinc in input samples
+	fc := q.rolloff * float64(min(from, to)) / float64(2*from)
+	width := float64(q.zeroCrossings) / (2 * fc)
+	r.half = int(math.Ceil(width))
+
+	count := r.up
+	r.exact = count <= maxPhases
+	if !r.exact {
+		count = maxPhases
+	}
+	norm := besselI0(q.beta)
+	r.phases = make([][]float64, count+1)
+	for p := range r.phases {
+		frac := float64(p) / float64(count)
+		h := make([]float64, 2*r.half)
+		for k := range h {
+			t := float64(k-r.half+1) - frac
+			if math.Abs(t) >= width {
+				continue
+			}
+			x := t / width
+			window := besselI0(q.beta*math.Sqrt(1-x*x)) / norm
+			sinc := 2 * fc
+			if t != 0 {
+				sinc = math.Sin(2*math.Pi*fc*t) / (math.Pi * t)
+			}
+			h[k] = sinc * window
+		}
+		r.phases[p] = h
+	}
+	return r
+}
+
+// Process returns the data at the output sample rate.
+func (r *Resampler) Process(data []float64) []float64 {
+	if r.up == r.down {
+		return append([]float64(nil), data...)
+	}
+	n := (int64(len(data))*int64(r.up) + int64(r.down) - 1) / int64(r.down)
+	out := make([]float64, n)
+	coefficients := make([]float64, 2*r.half)
+	for i := range out {
+		position := int64(i) * int64(r.down)
+		base := int(position / int64(r.up))
+		phase := int(position % int64(r.up))
+
+		h := coefficients
+		if r.exact {
+			h = r.phases[phase]
+		} else {
+			// between the phases p and p+1 of the table
+			index := float64(phase) * maxPhases / float64(r.up)
+			p := int(index)
+			w := index - float64(p)
+			for k := range coefficients {
+				coefficients[k] = (1-w)*r.phases[p][k] + w*r.phases[p+1][k]
+			}
+		}
+
+		var v float64
+		first := base - r.half + 1
+		for k, c := range h {
+			if j := first + k; j >= 0 && j < len(data) {
+				v += c * data[j]
+			}
+		}
+		out[i] = v
+	}
+	return out
+}
+
+// resample converts LT and RT from the rate from to the rate to.
+func resample(LT, RT []float64, from, to int, quality string) ([]float64, []float64) {
+	log.Info("Resample", "from", from, "to", to, "quality", quality)
+	r := newResampler(from, to, quality)
+	return r.Process(LT), r.Process(RT)
+}
+
+// resampled returns the metadata with the positions moved to the rate to : the cue points and
+// the time reference of the bext chunk, in samples since midnight.
+func (m Metadata) resampled(from, to int) Metadata {
+	rescale := func(position int64) int64 {
+		return int64(math.Round(float64(position) * float64(to) / float64(from)))
+	}
+	if len(m.Cues) > 0 {
+		cues := make([]Cue, len(m.Cues))
+		for i, cue := range m.Cues {
+			cues[i] = cue
+			cues[i].Position = rescale(cue.Position)
+		}
+		m.Cues = cues
+	}
+	if len(m.Bext) >= 346 {
+		m.Bext = append([]byte(nil), m.Bext...)
+		reference := binary.LittleEndian.Uint64(m.Bext[338:346])
+		binary.LittleEndian.PutUint64(m.Bext[338:346], uint64(rescale(int64(reference))))
+	}
+	return m
+}
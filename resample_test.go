@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		from, to  int
+		quality   string
+		tolerance float64
+	}{
+		{44100, 48000, "fast", 1e-2},
+		{44100, 48000, "standard", 1e-3},
+		{44100, 48000, "best", 1e-4},
+		{48000, 96000, "standard", 1e-3},
+		{96000, 48000, "standard", 1e-3},
+		{44100, 47999, "standard", 1e-3}, // interpolated phases
+	}
+	for _, tt := range tests {
+		in := sineTone(1000, 0.5, tt.from, tt.from/2)
+		out := newResampler(tt.from, tt.to, tt.quality).Process(in)
+		if want := (len(in)*tt.to + tt.from - 1) / tt.from; len(out) != want {
+			t.Errorf("%d to %d: %d samples, want %d", tt.from, tt.to, len(out), want)
+		}
+		want := sineTone(1000, 0.5, tt.to, len(out))
+		// away from the edges, where the filter runs out of input
+		var worst float64
+		for i := len(out) / 10; i < len(out)*9/10; i++ {
+			worst = max(worst, math.Abs(out[i]-want[i]))
+		}
+		if worst > tt.tolerance {
+			t.Errorf("%d to %d %s: error %.2e, want below %.0e", tt.from, tt.to, tt.quality, worst, tt.tolerance)
+		}
+	}
+
+	// a tone above the new Nyquist frequency is filtered out when going down
+	out := newResampler(96000, 48000, "standard").Process(sineTone(30000, 0.5, 96000, 48000))
+	var peak float64
+	for _, v := range out[len(out)/10 : len(out)*9/10] {
+		peak = max(peak, math.Abs(v))
+	}
+	if 20*math.Log10(peak/0.5) > -60 {
+		t.Errorf("30 kHz tone at %.1f dB after the conversion to 48 kHz, want below -60", 20*math.Log10(peak/0.5))
+	}
+
+	if _, err := parseResampleQuality("medium"); err == nil {
+		t.Errorf("medium quality accepted")
+	}
+}
+
+func TestResampledMetadata(t *testing.T) {
+	bext := make([]byte, 602)
+	binary.LittleEndian.PutUint64(bext[338:346], 44100*3600)
+	m := Metadata{Bext: bext, Cues: []Cue{{ID: 1, Position: 44100, Label: "B"}}}
+	got := m.resampled(44100, 48000)
+	if got.Cues[0].Position != 48000 || got.Cues[0].Label != "B" {
+		t.Errorf("cue %+v, want at 48000", got.Cues[0])
+	}
+	if reference := binary.LittleEndian.Uint64(got.Bext[338:346]); reference != 48000*3600 {
+		t.Errorf("time reference %d, want %d", reference, 48000*3600)
+	}
+	if m.Cues[0].Position != 44100 || binary.LittleEndian.Uint64(bext[338:346]) != 44100*3600 {
+		t.Errorf("metadata of the input changed")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// rmsDB returns the RMS level of data in dB, -inf for silence.
+func rmsDB(data []float64) float64 {
+	var sum float64
+	for _, v := range data {
+		sum += v * v
+	}
+	return 10 * math.Log10(sum/float64(len(data)))
+}
+
+func testConfig(t *testing.T, matrix string) Config {
+	t.Helper()
+	cfg, err := loadConfig("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Matrix = matrix
+	return cfg
+}
+
+func TestMeasureSeparation(t *testing.T) {
+	const floor = -60
+	// expected levels of LF, RF, LB and RB outputs in dB for each source, floor for nothing
+	tests := map[string][4][4]float64{
+		"SQ": {
+			{0, floor, -3, -3},
+			{floor, 0, -3, -3},
+			{-3, -3, 0, floor},
+			{-3, -3, floor, 0},
+		},
+		"QS": {
+			{0, -3, -3, floor},
+			{-3, 0, floor, -3},
+			{-3, floor, 0, -3},
+			{floor, -3, -3, 0},
+		},
+	}
+
+	for matrix, want := range tests {
+		t.Run(matrix, func(t *testing.T) {
+			measurement := MeasureSeparation(testConfig(t, matrix), "pink", 0, 44100, 1<<14)
+			band := measurement.Bands[0]
+			if band.Name != "all" {
+				t.Fatalf("first band %q, want all", band.Name)
+			}
+			for s, source := range measurement.Sources {
+				for o, output := range measurement.Outputs[:4] {
+					got := band.DB[s][o]
+					if want[s][o] == floor {
+						if got > floor {
+							t.Errorf("%s -> %s: %.2f dB, want below %d dB", source, output, got, floor)
+						}
+					} else if math.Abs(got-want[s][o]) > 0.1 {
+						t.Errorf("%s -> %s: %.2f dB, want %.0f dB", source, output, got, want[s][o])
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestRoundTripShape checks that decoding an encoded single source gives back the source in its
+// own channel, up to the normalization.
+func TestRoundTripShape(t *testing.T) {
+	const n = 1 << 13
+	// without the DC and Nyquist bins, where a 90 degrees phase shift is not possible
+	fft := fourier.NewFFT(n)
+	spectrum := fft.Coefficients(nil, pinkNoise(0.5, n, 7))
+	spectrum[0], spectrum[n/2] = 0, 0
+	source := fft.Sequence(nil, spectrum)
+	silence := make([]float64, n)
+	cfg := testConfig(t, "SQ")
+
+	for s, name := range quadSources {
+		inputs := [4][]float64{silence, silence, silence, silence}
+		inputs[s] = source
+
+		LT, RT := EncodeSQ(inputs[0], inputs[1], inputs[2], inputs[3])
+		LF, RF, LB, RB := DecodeSQ(LT, RT, cfg.SQ, 44100)
+		if diff := engineDifference(source, [][]float64{LF, RF, LB, RB}[s]); diff > -100 {
+			t.Errorf("SQ %s: difference %.1f dB, want below -100 dB", name, diff)
+		}
+
+		LT, RT = EncodeQS(inputs[0], inputs[1], inputs[2], inputs[3])
+		LF, RF, LB, RB = DecodeQS(LT, RT, cfg.QS, 44100)
+		if diff := engineDifference(source, [][]float64{LF, RF, LB, RB}[s]); diff > -100 {
+			t.Errorf("QS %s: difference %.1f dB, want below -100 dB", name, diff)
+		}
+	}
+}
+
+// TestEnginesAgree compares the FFT and the FIR Hilbert decodings of an encoded tone.
+func TestEnginesAgree(t *testing.T) {
+	const n = 1 << 14
+	silence := make([]float64, n)
+	tone := sineTone(1000, 0.5, 44100, n)
+	LT, RT := EncodeSQ(tone, silence, silence, tone)
+
+	cfg := testConfig(t, "SQ")
+	m := decodingMatrix(cfg)
+	fft := decodeWithOptions(LT, RT, m, cfg, cfg.decodeOptions(44100))
+	cfg.Engine = "fir"
+	fir := decodeWithOptions(LT, RT, m, cfg, cfg.decodeOptions(44100))
+
+	// the FIR transformers need half their length to settle at both ends
+	edge := cfg.Hilbert.Taps
+	for _, channel := range []string{"LF", "RF", "LB", "RB"} {
+		if diff := engineDifference(fft[channel][edge:n-edge], fir[channel][edge:n-edge]); diff > -40 {
+			t.Errorf("%s: difference %.1f dB, want below -40 dB", channel, diff)
+		}
+	}
+}
+
+// TestGroundTruth decodes the generated SQ fixture and checks that the loudest channel of each
+// segment is the position of its ground truth.
+func TestGroundTruth(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "sq_speech.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var truth GroundTruth
+	if err := json.Unmarshal(data, &truth); err != nil {
+		t.Fatal(err)
+	}
+	LT, RT, sampleRate, err := readWaveFile(filepath.Join("testdata", truth.Files["SQ"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampleRate != truth.SampleRate {
+		t.Fatalf("sample rate %d, want %d", sampleRate, truth.SampleRate)
+	}
+
+	cfg := testConfig(t, "SQ")
+	outputs := decodeWithOptions(LT, RT, decodingMatrix(cfg), cfg, cfg.decodeOptions(sampleRate))
+	for _, segment := range truth.Segments {
+		start := int(segment.StartS * float64(sampleRate))
+		end := min(len(LT), int(segment.EndS*float64(sampleRate)))
+		loudest, level := "", math.Inf(-1)
+		for _, channel := range quadSources {
+			if l := rmsDB(outputs[channel][start:end]); l > level {
+				loudest, level = channel, l
+			}
+		}
+		if loudest != segment.Position {
+			t.Errorf("segment %.2f-%.2f s: loudest channel %s, want %s", segment.StartS, segment.EndS, loudest, segment.Position)
+		}
+	}
+}
+
+// TestDecodeChannelWiring encodes a single source, decodes it with the decode command in each
+// layout and checks where the source ends up in the written files.
+func TestDecodeChannelWiring(t *testing.T) {
+	const n = 1 << 14
+	silence := make([]float64, n)
+
+	tests := []struct {
+		source      int // index in quadSources
+		audioformat string
+		file        string // part of the name of the file holding the source
+		channel     int    // channel of the source in the file
+		silent      int    // channel of the opposite output in the same file, -1 for none
+	}{
+		{0, "", "output_front_", 0, 1},
+		{1, "", "output_front_", 1, 0},
+		{2, "", "output_back_", 0, 1},
+		{3, "", "output_back_", 1, 0},
+		{0, "4.0", "_4_0.wav", 0, 1},
+		{1, "4.0", "_4_0.wav", 1, 0},
+		{2, "4.0", "_4_0.wav", 2, 3},
+		{3, "4.0", "_4_0.wav", 3, 2},
+		{0, "5.1", "_5_1.wav", 0, 1},
+		{1, "5.1", "_5_1.wav", 1, 0},
+		{2, "5.1", "_5_1.wav", 4, 5},
+		{3, "5.1", "_5_1.wav", 5, 4},
+		{2, "stems", "_LB.wav", 0, -1},
+		{3, "stems", "_RB.wav", 0, -1},
+	}
+
+	for _, tt := range tests {
+		name := quadSources[tt.source] + "_" + tt.audioformat
+		t.Run(name, func(t *testing.T) {
+			inputs := [4][]float64{silence, silence, silence, silence}
+			inputs[tt.source] = pinkNoise(0.5, n, 3)
+			LT, RT := EncodeSQ(inputs[0], inputs[1], inputs[2], inputs[3])
+
+			dir := t.TempDir()
+			input := filepath.Join(dir, "wiring.wav")
+			if err := writeWaveFile(input, 44100, LT, RT); err != nil {
+				t.Fatal(err)
+			}
+			args := []string{"-input", input}
+			if tt.audioformat != "" {
+				args = append(args, "-audioformat", tt.audioformat)
+			}
+			inDir(t, dir, func() {
+				if err := runDecode(args); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var levels []float64
+			for _, entry := range entries {
+				if !strings.Contains(entry.Name(), tt.file) {
+					continue
+				}
+				_, samples := readPCM16(t, filepath.Join(dir, entry.Name()))
+				for _, channel := range samples {
+					data := make([]float64, len(channel))
+					for i, v := range channel {
+						data[i] = float64(v) / 32767
+					}
+					levels = append(levels, rmsDB(data))
+				}
+			}
+			if levels == nil {
+				t.Fatalf("no output file with %q", tt.file)
+			}
+
+			// the outputs are normalized by pair, the matrix shows in the silent opposite channel
+			if tt.silent >= 0 && levels[tt.silent] > levels[tt.channel]-60 {
+				t.Errorf("opposite channel %d at %.1f dB, want 60 dB below the source at %.1f dB", tt.silent, levels[tt.silent], levels[tt.channel])
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Synthetic test signals, used by the measure and generate commands.
+
+// sineTone returns n samples of a sine at freq Hz.
+func sineTone(freq float64, amplitude float64, sampleRate int, n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+	}
+	return data
+}
+
+// pinkNoise returns n samples of pink noise (-3 dB per octave) with the given peak amplitude.
+// White noise goes through Paul Kellet's filter ; the seed makes the signal reproducible.
+func pinkNoise(amplitude float64, n int, seed int64) []float64 {
+	random := rand.New(rand.NewSource(seed))
+	data := make([]float64, n)
+	var b0, b1, b2, b3, b4, b5, b6 float64
+	for i := range data {
+		white := random.Float64()*2 - 1
+		b0 = 0.99886*b0 + white*0.0555179
+		b1 = 0.99332*b1 + white*0.0750759
+		b2 = 0.96900*b2 + white*0.1538520
+		b3 = 0.86650*b3 + white*0.3104856
+		b4 = 0.55000*b4 + white*0.5329522
+		b5 = -0.7616*b5 - white*0.0168980
+		data[i] = b0 + b1 + b2 + b3 + b4 + b5 + b6 + white*0.5362
+		b6 = white * 0.115926
+	}
+	peak := maxAbs(data)
+	for i := range data {
+		data[i] *= amplitude / peak
+	}
+	return data
+}
+
+// logSweep returns n samples of a logarithmic sine sweep from startHz to endHz.
+func logSweep(startHz float64, endHz float64, amplitude float64, sampleRate int, n int) []float64 {
+	data := make([]float64, n)
+	duration := float64(n) / float64(sampleRate)
+	k := math.Log(endHz / startHz)
+	for i := range data {
+		t := float64(i) / float64(sampleRate)
+		phase := 2 * math.Pi * startHz * duration / k * (math.Exp(t/duration*k) - 1)
+		data[i] = amplitude * math.Sin(phase)
+	}
+	return data
+}
+
+// speechLike returns n samples of a signal with the rhythm and spectrum of speech : a glottal
+// sawtooth with a moving pitch, through two formant resonators which change at every syllable,
+// with a syllabic envelope of about 4 Hz. It is not intelligible but localizes like a voice.
+func speechLike(amplitude float64, sampleRate int, n int, seed int64) []float64 {
+	random := rand.New(rand.NewSource(seed))
+	data := make([]float64, n)
+	fs := float64(sampleRate)
+	syllable := int(0.25 * fs)
+
+	var phase float64
+	var formant1, formant2 *biquad
+	for i := range data {
+		if i%syllable == 0 {
+			// a new vowel
+			formant1 = newBandPassBiquad(fs, 300+500*random.Float64(), 5)
+			formant2 = newBandPassBiquad(fs, 900+1500*random.Float64(), 8)
+		}
+		t := float64(i) / fs
+		pitch := 120 + 30*math.Sin(2*math.Pi*0.7*t)
+		phase += pitch / fs
+		phase -= math.Floor(phase)
+		glottal := 2*phase - 1 + 0.05*(random.Float64()*2-1)
+
+		position := float64(i%syllable) / float64(syllable)
+		envelope := math.Pow(math.Sin(math.Pi*position), 0.7)
+		data[i] = envelope * (formant1.process(glottal) + 0.5*formant2.process(glottal))
+	}
+
+	peak := maxAbs(data)
+	for i := range data {
+		data[i] *= amplitude / peak
+	}
+	return data
+}
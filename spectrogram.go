@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// powerFrames cuts a channel in Hann windowed frames of windowSize samples every hop samples
+// and returns the power spectrum of each frame.
+func powerFrames(data []float64, windowSize int, hop int) [][]float64 {
+	fft := fourier.NewFFT(windowSize)
+	window := make([]float64, windowSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(windowSize-1)) // Hann
+	}
+
+	var frames [][]float64
+	frame := make([]float64, windowSize)
+	var coefficients []complex128
+	for start := 0; start+windowSize <= len(data); start += hop {
+		for i := range frame {
+			frame[i] = data[start+i] * window[i]
+		}
+		coefficients = fft.Coefficients(coefficients, frame)
+		power := make([]float64, len(coefficients))
+		for i, c := range coefficients {
+			power[i] = real(c)*real(c) + imag(c)*imag(c)
+		}
+		frames = append(frames, power)
+	}
+	return frames
+}
+
+// plotLayout is the plot area of the PNG reports.
+const (
+	plotLeft, plotTop     = 70, 40
+	plotWidth, plotHeight = 1000, 320
+)
+
+// timeTicks draws the time axis of a plot of duration seconds.
+func timeTicks(c *canvas, duration float64) {
+	step := 1.0
+	for _, s := range []float64{1, 2, 5, 10, 15, 30, 60, 120, 300, 600} {
+		step = s
+		if duration/s <= 12 {
+			break
+		}
+	}
+	for t := 0.0; t <= duration; t += step {
+		x := plotLeft + int(t/duration*plotWidth)
+		c.line(x, plotTop, x, plotTop+plotHeight, colorGrid)
+		label := strconv.Itoa(int(t))
+		c.text(x-textWidth(label)/2, plotTop+plotHeight+8, label, colorAxis)
+	}
+	c.text(plotLeft+plotWidth/2-textWidth("TIME (S)")/2, plotTop+plotHeight+28, "TIME (S)", colorAxis)
+}
+
+// writeSpectrogramPNG draws the spectrogram of a decoded channel, 20 Hz to 20 kHz on a log scale.
+// The colors cover 90 dB below referenceDB, the loudest bin of all the channels, so that the
+// spectrograms of the channels of one decode can be compared.
+func writeSpectrogramPNG(path string, channel string, frames [][]float64, sampleRate int, windowSize int, hop int, referenceDB float64) error {
+	c := newCanvas(plotLeft+plotWidth+30, plotTop+plotHeight+50)
+	if len(frames) == 0 {
+		return c.save(path)
+	}
+
+	const minFreq, maxFreq = 20.0, 20000.0
+	freqResolution := float64(sampleRate) / float64(windowSize)
+	for x := 0; x < plotWidth; x++ {
+		frame := frames[min(len(frames)-1, x*len(frames)/plotWidth)]
+		for y := 0; y < plotHeight; y++ {
+			// each pixel row covers a frequency band, its color is the loudest bin of the band
+			fLow := maxFreq * math.Pow(minFreq/maxFreq, float64(y+1)/plotHeight)
+			fHigh := maxFreq * math.Pow(minFreq/maxFreq, float64(y)/plotHeight)
+			low := max(1, int(fLow/freqResolution)) // not the DC bin
+			high := max(low+1, int(math.Ceil(fHigh/freqResolution)))
+			var power float64
+			for i := low; i < high && i < len(frame); i++ {
+				power = math.Max(power, frame[i])
+			}
+			if power == 0 {
+				continue
+			}
+			level := 10*math.Log10(power) - referenceDB
+			c.Set(plotLeft+x, plotTop+y, heatColor(1+level/90))
+		}
+	}
+
+	for _, f := range []float64{20, 100, 1000, 10000, 20000} {
+		y := plotTop + int(math.Log(maxFreq/f)/math.Log(maxFreq/minFreq)*plotHeight)
+		c.line(plotLeft-4, y, plotLeft, y, colorAxis)
+		label := strconv.Itoa(int(f))
+		if f >= 1000 {
+			label = strconv.Itoa(int(f/1000)) + "K"
+		}
+		c.text(plotLeft-textWidth(label)-8, y-5, label, colorAxis)
+	}
+	duration := float64((len(frames)-1)*hop+windowSize) / float64(sampleRate)
+	timeTicks(c, duration)
+	c.text(plotLeft, 12, "SPECTROGRAM "+channel+" (HZ, 90 DB RANGE)", channelColor(channel))
+
+	return c.save(path)
+}
+
+// channelColor returns the color of a channel in the plots.
+func channelColor(channel string) color.RGBA {
+	if col, ok := channelColors[channel]; ok {
+		return col
+	}
+	return colorAxis
+}
+
+// rmsLevels returns the RMS level in dBFS of a channel for each block of blockSize samples.
+func rmsLevels(data []float64, blockSize int) []float64 {
+	var levels []float64
+	for start := 0; start < len(data); start += blockSize {
+		end := min(len(data), start+blockSize)
+		var sum float64
+		for _, v := range data[start:end] {
+			sum += v * v
+		}
+		levels = append(levels, 10*math.Log10(sum/float64(end-start)+1e-20))
+	}
+	return levels
+}
+
+// writeLevelsPNG draws the level over time (RMS every 400 ms) of the front and back channels.
+func writeLevelsPNG(path string, outputs map[string][]float64, channels []string, sampleRate int) error {
+	const rangeDB = 60
+	c := newCanvas(plotLeft+plotWidth+30, plotTop+plotHeight+50)
+
+	blockSize := sampleRate * 400 / 1000
+	levels := map[string][]float64{}
+	loudest := math.Inf(-1)
+	var blocks, samples int
+	for _, channel := range channels {
+		data, ok := outputs[channel]
+		if !ok {
+			continue
+		}
+		levels[channel] = rmsLevels(data, blockSize)
+		blocks = max(blocks, len(levels[channel]))
+		samples = max(samples, len(data))
+		for _, level := range levels[channel] {
+			loudest = math.Max(loudest, level)
+		}
+	}
+
+	for db := 0; db <= rangeDB; db += 10 {
+		y := plotTop + db*plotHeight/rangeDB
+		c.line(plotLeft, y, plotLeft+plotWidth, y, colorGrid)
+		label := strconv.Itoa(-db)
+		c.text(plotLeft-textWidth(label)-8, y-5, label, colorAxis)
+	}
+	if samples > 0 {
+		timeTicks(c, float64(samples)/float64(sampleRate))
+	}
+
+	legend := plotLeft + plotWidth
+	for i := len(channels) - 1; i >= 0; i-- {
+		channel := channels[i]
+		if _, ok := levels[channel]; !ok {
+			continue
+		}
+		legend -= textWidth(channel) + 8
+		c.text(legend, 12, channel, channelColor(channel))
+	}
+	c.text(plotLeft, 12, "LEVEL (DB, RMS 400 MS, RELATIVE TO THE LOUDEST)", colorAxis)
+
+	for _, channel := range channels {
+		channelLevels, ok := levels[channel]
+		if !ok {
+			continue
+		}
+		col := channelColor(channel)
+		toY := func(level float64) int {
+			db := math.Max(0, math.Min(rangeDB, loudest-level))
+			return plotTop + int(db/rangeDB*plotHeight)
+		}
+		for i := 1; i < len(channelLevels); i++ {
+			// one point in the middle of each block
+			x0 := plotLeft + (2*i-1)*plotWidth/(2*blocks)
+			x1 := plotLeft + (2*i+1)*plotWidth/(2*blocks)
+			c.line(x0, toY(channelLevels[i-1]), x1, toY(channelLevels[i]), col)
+		}
+	}
+
+	return c.save(path)
+}
+
+// writeDecodePlots writes a spectrogram per decoded channel (prefix_spectrogram_LF.png...) and the
+// level over time of the quad channels (prefix_levels.png).
+func writeDecodePlots(prefix string, outputs map[string][]float64, channels []string, sampleRate int) error {
+	const windowSize = 2048
+	samples := 0
+	for _, channel := range channels {
+		samples = max(samples, len(outputs[channel]))
+	}
+	hop := max(windowSize/4, samples/plotWidth) // about one frame per pixel column
+
+	spectra := map[string][][]float64{}
+	referenceDB := math.Inf(-1)
+	for _, channel := range channels {
+		spectra[channel] = powerFrames(outputs[channel], windowSize, hop)
+		for _, frame := range spectra[channel] {
+			for _, power := range frame {
+				if power > 0 {
+					referenceDB = math.Max(referenceDB, 10*math.Log10(power))
+				}
+			}
+		}
+	}
+
+	for _, channel := range channels {
+		path := prefix + "_spectrogram_" + channel + ".png"
+		log.Info("Write spectrogram...", "channel", channel, "output", path)
+		if err := writeSpectrogramPNG(path, channel, spectra[channel], sampleRate, windowSize, hop, referenceDB); err != nil {
+			return fmt.Errorf("failed to write spectrogram of %s: %w", channel, err)
+		}
+	}
+
+	path := prefix + "_levels.png"
+	log.Info("Write levels plot...", "output", path)
+	if err := writeLevelsPNG(path, outputs, []string{"LF", "RF", "LB", "RB"}, sampleRate); err != nil {
+		return fmt.Errorf("failed to write levels plot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// expectedHeader builds the 44 bytes PCM 16 bits header the writers must produce.
+func expectedHeader(channels int, sampleRate int, frames int) []byte {
+	dataSize := frames * channels * 2
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+dataSize))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*channels*2))
+	binary.Write(&b, binary.LittleEndian, uint16(channels*2))
+	binary.Write(&b, binary.LittleEndian, uint16(16))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(dataSize))
+	return b.Bytes()
+}
+
+// readPCM16 reads a file written by the writers of this package : its 44 bytes header and
+// the 16 bits samples of each channel. The metadata chunks after the data are skipped, the RIFF
+// size must count them.
+func readPCM16(t *testing.T, path string) ([]byte, [][]int16) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 44 {
+		t.Fatalf("%s: %d bytes, too short for a header", path, len(data))
+	}
+	header := data[:44]
+	channels := int(binary.LittleEndian.Uint16(header[22:24]))
+	if channels == 0 {
+		t.Fatalf("%s: no channels", path)
+	}
+	if riffSize := binary.LittleEndian.Uint32(header[4:8]); string(header[0:4]) == "RIFF" && int(riffSize) != len(data)-8 {
+		t.Errorf("%s: RIFF size %d, file %d bytes", path, riffSize, len(data))
+	}
+	pcm := data[44:]
+	if dataSize := int(binary.LittleEndian.Uint32(header[40:44])); dataSize < len(pcm) {
+		pcm = pcm[:dataSize]
+	}
+	frames := len(pcm) / (2 * channels)
+	samples := make([][]int16, channels)
+	for c := range samples {
+		samples[c] = make([]int16, frames)
+		for i := 0; i < frames; i++ {
+			offset := 2 * (i*channels + c)
+			samples[c][i] = int16(binary.LittleEndian.Uint16(pcm[offset : offset+2]))
+		}
+	}
+	return header, samples
+}
+
+// constantChannels returns count channels of frames samples, channel i holding the value (i+1)/10.
+func constantChannels(count int, frames int) [][]float64 {
+	channels := make([][]float64, count)
+	for i := range channels {
+		channels[i] = make([]float64, frames)
+		for j := range channels[i] {
+			channels[i][j] = float64(i+1) / 10
+		}
+	}
+	return channels
+}
+
+func TestWriterHeadersAndChannelOrder(t *testing.T) {
+	const frames, sampleRate = 10, 48000
+	dir := t.TempDir()
+	in := constantChannels(6, frames)
+
+	tests := []struct {
+		name  string
+		write func(path string) error
+		// order gives, for each channel of the file, the index of the input written there
+		order []int
+	}{
+		{"stereo", func(path string) error { return writeWaveFile(path, sampleRate, in[0], in[1]) }, []int{0, 1}},
+		{"mono", func(path string) error { return writeWaveFileMono(path, sampleRate, in[0]) }, []int{0}},
+		{"4.0", func(path string) error {
+			return writeWaveFile4_0(path, sampleRate, in[0], in[1], in[2], in[3])
+		}, []int{0, 1, 2, 3}},
+		// arguments are LF, RF, LB, RB, C, LFE and the file order is L, R, C, LFE, Ls, Rs
+		{"5.1", func(path string) error {
+			return writeWaveFile5_1(path, sampleRate, in[0], in[1], in[2], in[3], in[4], in[5])
+		}, []int{0, 1, 4, 5, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".wav")
+			if err := tt.write(path); err != nil {
+				t.Fatal(err)
+			}
+			header, samples := readPCM16(t, path)
+			if want := expectedHeader(len(tt.order), sampleRate, frames); !bytes.Equal(header, want) {
+				t.Errorf("header\n got % x\nwant % x", header, want)
+			}
+			for c, input := range tt.order {
+				want := int16(math.Round(in[input][0] * 32767))
+				for i, got := range samples[c] {
+					if got != want {
+						t.Fatalf("channel %d frame %d = %d, want %d (input %d)", c, i, got, want, input)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadWaveInfo(t *testing.T) {
+	info, err := readWaveInfo(filepath.Join("testdata", "qsdemo2_excerpt.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WaveInfo{Container: "RIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Frames: 16384}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+
+	// a LIST chunk with an odd size before the data chunk
+	var b bytes.Buffer
+	header := expectedHeader(2, 22050, 3)
+	b.Write(header[:36])
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(5))
+	b.Write([]byte{'I', 'N', 'F', 'O', 'x', 0})
+	b.Write(header[36:])
+	b.Write(make([]byte, 12))
+	path := filepath.Join(t.TempDir(), "list.wav")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err = readWaveInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Frames != 3 || info.SampleRate != 22050 {
+		t.Errorf("got %+v, want 3 frames at 22050 Hz", info)
+	}
+}
+
+// riffFile builds a RIFF/WAVE file from chunks given as id and content, with the pad bytes.
+func riffFile(chunks ...[2]string) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	for _, chunk := range chunks {
+		body.WriteString(chunk[0])
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunk[1])))
+		body.WriteString(chunk[1])
+		if len(chunk[1])%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(body.Len()))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// fmtChunk returns the content of a fmt chunk, extensible when subFormat is not 0.
+func fmtChunk(formatCode int, channels int, sampleRate int, bits int, subFormat int, validBits int) string {
+	var b bytes.Buffer
+	blockAlign := channels * ((bits + 7) / 8)
+	binary.Write(&b, binary.LittleEndian, uint16(formatCode))
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(&b, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&b, binary.LittleEndian, uint16(bits))
+	if subFormat != 0 {
+		binary.Write(&b, binary.LittleEndian, uint16(22))
+		binary.Write(&b, binary.LittleEndian, uint16(validBits))
+		binary.Write(&b, binary.LittleEndian, uint32(0x3))
+		binary.Write(&b, binary.LittleEndian, uint16(subFormat))
+		b.Write(extensibleGUIDSuffix)
+	}
+	return b.String()
+}
+
+// samples returns the little endian bytes of values.
+func samples(values ...any) string {
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.String()
+}
+
+func TestDecodeWaveLayouts(t *testing.T) {
+	// two frames of the stereo values (0.5, -0.25) then (-1, 1) in each format
+	const half, quarter = 0.5, 0.25
+	pcm16 := samples(int16(16384), int16(-8192), int16(-32767), int16(32767))
+	pcm24 := "\x00\x00\x40" + "\x00\x00\xe0" + "\x01\x00\x80" + "\xff\xff\x7f"
+	float32s := samples(float32(half), float32(-quarter), float32(-1), float32(1))
+
+	tests := []struct {
+		name     string
+		file     []byte
+		info     WaveInfo
+		accuracy float64
+	}{
+		{"pcm16", riffFile([2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)}, [2]string{"data", pcm16}),
+			WaveInfo{Container: "RIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Frames: 2}, 1e-4},
+		{"chunks before and after", riffFile(
+			[2]string{"JUNK", string(make([]byte, 28))},
+			[2]string{"bext", "odd"},
+			[2]string{"fmt ", fmtChunk(1, 2, 48000, 16, 0, 0)},
+			[2]string{"fact", samples(uint32(2))},
+			[2]string{"LIST", "INFOISFT\x05\x00\x00\x00test\x00"},
+			[2]string{"data", pcm16},
+			[2]string{"LIST", "INFO"}),
+			WaveInfo{Container: "RIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 48000, BitsPerSample: 16, ValidBits: 16, Frames: 2}, 1e-4},
+		{"pcm24", riffFile([2]string{"fmt ", fmtChunk(1, 2, 96000, 24, 0, 0)}, [2]string{"data", pcm24}),
+			WaveInfo{Container: "RIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 96000, BitsPerSample: 24, ValidBits: 24, Frames: 2}, 1e-6},
+		{"extensible pcm24", riffFile([2]string{"fmt ", fmtChunk(0xFFFE, 2, 96000, 24, 1, 24)}, [2]string{"data", pcm24}),
+			WaveInfo{Container: "RIFF", FormatCode: 0xFFFE, SubFormat: 1, Channels: 2, SampleRate: 96000, BitsPerSample: 24, ValidBits: 24, ChannelMask: 3, Frames: 2}, 1e-6},
+		{"float32", riffFile([2]string{"fmt ", fmtChunk(3, 2, 44100, 32, 0, 0)}, [2]string{"fact", samples(uint32(2))}, [2]string{"data", float32s}),
+			WaveInfo{Container: "RIFF", FormatCode: 3, SubFormat: 3, Channels: 2, SampleRate: 44100, BitsPerSample: 32, ValidBits: 32, Frames: 2}, 0},
+		{"extensible float32", riffFile([2]string{"fmt ", fmtChunk(0xFFFE, 2, 44100, 32, 3, 32)}, [2]string{"data", float32s}),
+			WaveInfo{Container: "RIFF", FormatCode: 0xFFFE, SubFormat: 3, Channels: 2, SampleRate: 44100, BitsPerSample: 32, ValidBits: 32, ChannelMask: 3, Frames: 2}, 0},
+		{"incomplete last frame", riffFile([2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)}, [2]string{"data", pcm16 + "\x01\x02\x03"}),
+			WaveInfo{Container: "RIFF", FormatCode: 1, SubFormat: 1, Channels: 2, SampleRate: 44100, BitsPerSample: 16, ValidBits: 16, Frames: 2}, 1e-4},
+	}
+
+	want := [][]float64{{half, -1}, {-quarter, 1}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, channels, err := decodeWave(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != tt.info {
+				t.Errorf("got %+v, want %+v", info, tt.info)
+			}
+			for c := range want {
+				for i := range want[c] {
+					if d := channels[c][i] - want[c][i]; d > tt.accuracy || d < -tt.accuracy {
+						t.Errorf("channel %d frame %d = %v, want %v", c, i, channels[c][i], want[c][i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeWaveTruncated(t *testing.T) {
+	// a recording which was not closed : the data chunk is longer than the file
+	file := riffFile([2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)}, [2]string{"data", samples(int16(1), int16(2), int16(3), int16(4))})
+	binary.LittleEndian.PutUint32(file[len(file)-12:], 0xFFFFFFFF)
+	info, channels, err := decodeWave(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Frames != 2 || len(channels[0]) != 2 {
+		t.Errorf("got %d frames, want 2", info.Frames)
+	}
+}
+
+func TestDecodeWaveErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"not riff", []byte("RIFX\x04\x00\x00\x00WAVE")},
+		{"no fmt", riffFile([2]string{"data", "\x00\x00\x00\x00"})},
+		{"no data", riffFile([2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)})},
+		{"adpcm", riffFile([2]string{"fmt ", fmtChunk(2, 2, 44100, 4, 0, 0)}, [2]string{"data", "\x00\x00"})},
+		{"unknown guid", riffFile([2]string{"fmt ", fmtChunk(0xFFFE, 2, 44100, 16, 1, 16)[:30] + "\x01" + fmtChunk(0xFFFE, 2, 44100, 16, 1, 16)[31:]}, [2]string{"data", "\x00\x00\x00\x00"})},
+		{"block align", riffFile([2]string{"fmt ", fmtChunk(1, 2, 44100, 16, 0, 0)[:12] + "\x03\x00" + fmtChunk(1, 2, 44100, 16, 0, 0)[14:]}, [2]string{"data", "\x00\x00\x00\x00"})},
+	}
+	for _, tt := range tests {
+		if _, _, err := decodeWave(bytes.NewReader(tt.file)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestPickChannels(t *testing.T) {
+	tests := []struct {
+		count  int
+		pick   string
+		lt, rt int
+		ok     bool
+	}{
+		{2, "", 0, 1, true},
+		{1, "", 0, 0, false},
+		{6, "", 0, 0, false},
+		{6, "3,4", 2, 3, true},
+		{2, "2,1", 1, 0, true},
+		{6, "0,1", 0, 0, false},
+		{6, "5,7", 0, 0, false},
+		{6, "1", 0, 0, false},
+	}
+	for _, tt := range tests {
+		lt, rt, err := pickChannels(tt.count, tt.pick)
+		if (err == nil) != tt.ok || (tt.ok && (lt != tt.lt || rt != tt.rt)) {
+			t.Errorf("pickChannels(%d, %q) = %d, %d, %v", tt.count, tt.pick, lt, rt, err)
+		}
+	}
+}
+
+func FuzzDecodeWave(f *testing.F) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "qsdemo2_excerpt.wav"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(fixture[:4096])
+	f.Add(riffFile([2]string{"fmt ", fmtChunk(0xFFFE, 2, 96000, 24, 1, 24)}, [2]string{"data", "\x00\x00\x40\x00\x00\xe0"}))
+	f.Add(riffFile([2]string{"bext", "odd"}, [2]string{"fmt ", fmtChunk(3, 1, 44100, 64, 0, 0)}, [2]string{"data", samples(0.5)}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, channels, err := decodeWave(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(channels) != info.Channels {
+			t.Fatalf("%d channels decoded, format says %d", len(channels), info.Channels)
+		}
+		for _, channel := range channels {
+			if int64(len(channel)) != info.Frames {
+				t.Fatalf("%d frames decoded, format says %d", len(channel), info.Frames)
+			}
+		}
+		if int64(len(data)) < info.Frames*int64(info.Channels*info.bytesPerSample()) {
+			t.Fatalf("%d frames in %d bytes", info.Frames, len(data))
+		}
+	})
+}
+
+func TestRF64(t *testing.T) {
+	// promote to RF64 above 100 bytes instead of 4 GB
+	defer func(limit int64) { riffLimit = limit }(riffLimit)
+	riffLimit = 100
+
+	in := constantChannels(2, 20)
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name string
+		opts OutputOptions
+		id   string
+	}{
+		{"rf64", OutputOptions{}, "RF64"},
+		{"bw64", OutputOptions{BW64: true}, "BW64"},
+	} {
+		path := filepath.Join(dir, tt.name+".wav")
+		if err := writeWave(path, 48000, nil, in, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want bytes.Buffer
+		want.WriteString(tt.id + "\xff\xff\xff\xffWAVEds64\x1c\x00\x00\x00")
+		binary.Write(&want, binary.LittleEndian, []uint64{uint64(len(data) - 8), 80, 20})
+		want.Write([]byte{0, 0, 0, 0})
+		want.Write(expectedHeader(2, 48000, 20)[12:40])
+		want.WriteString("\xff\xff\xff\xff")
+		if !bytes.Equal(data[:80], want.Bytes()) {
+			t.Errorf("%s header\n got % x\nwant % x", tt.name, data[:80], want.Bytes())
+		}
+
+		info, channels, err := decodeWave(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Container != tt.id || info.Frames != 20 {
+			t.Errorf("%s: got %+v", tt.name, info)
+		}
+		if want := float64(int16(in[1][19]*32767)) / 32767; channels[1][19] != want {
+			t.Errorf("%s: last sample %v", tt.name, channels[1][19])
+		}
+	}
+
+	// below the limit the header stays a plain RIFF one
+	path := filepath.Join(dir, "small.wav")
+	if err := writeWave(path, 48000, nil, constantChannels(2, 10), OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	header, _ := readPCM16(t, path)
+	if !bytes.Equal(header, expectedHeader(2, 48000, 10)) {
+		t.Errorf("small file header % x", header)
+	}
+}
+
+func TestDS64Table(t *testing.T) {
+	// a BW64 file whose ds64 table gives the size of a large chunk before the data chunk
+	var ds64 bytes.Buffer
+	binary.Write(&ds64, binary.LittleEndian, []uint64{0, 4, 1, 1})
+	ds64.Truncate(24)
+	binary.Write(&ds64, binary.LittleEndian, uint32(1))
+	ds64.WriteString("axml")
+	binary.Write(&ds64, binary.LittleEndian, uint64(6))
+	file := riffFile(
+		[2]string{"ds64", ds64.String()},
+		[2]string{"fmt ", fmtChunk(1, 2, 48000, 16, 0, 0)},
+		[2]string{"axml", "<bw64>"},
+		[2]string{"data", samples(int16(100), int16(-100))})
+	copy(file, "BW64\xff\xff\xff\xff")
+	// 32 bits sizes of axml and data set to 0xFFFFFFFF
+	for _, id := range []string{"axml", "data"} {
+		i := bytes.LastIndex(file, []byte(id))
+		binary.LittleEndian.PutUint32(file[i+4:], 0xFFFFFFFF)
+	}
+
+	info, channels, err := decodeWave(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != "BW64" || info.Frames != 1 || channels[1][0] != -100.0/32767 {
+		t.Errorf("got %+v, %v", info, channels)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// WAV reading : a RIFF chunk walker which skips the chunks it does not know (LIST, bext, JUNK,
+// fact, ...), PCM 8/16/24/32 bits and IEEE float 32/64 bits, plain or WAVE_FORMAT_EXTENSIBLE,
+// in RIFF, RF64 or BW64 files.
+// WAV writing : 16 bits PCM, RIFF or RF64/BW64 above 4 GB, the metadata chunks after the data.
+
+const (
+	waveFormatPCM        = 1
+	waveFormatFloat      = 3
+	waveFormatExtensible = 0xFFFE
+)
+
+// extensibleGUIDSuffix is the end of the KSDATAFORMAT_SUBTYPE GUIDs, the format code is in the first 2 bytes.
+var extensibleGUIDSuffix = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// riffChunk is a chunk of a RIFF file : its id, the size of its content and where the content starts.
+type riffChunk struct {
+	ID     string
+	Size   int64
+	Offset int64
+}
+
+// walkRIFF returns the container (RIFF, RF64 or BW64) and the chunks of a wave file. Chunks are
+// word aligned : a chunk with an odd size is followed by a pad byte. The size of the RIFF header
+// is not trusted, the walk goes to the end of the file, and a chunk longer than the file (a
+// recording which was not closed properly) is cut at the end of the file.
+// RF64 and BW64 files have 0xFFFFFFFF as 32 bits sizes, the 64 bits sizes are in the ds64 chunk.
+func walkRIFF(r io.ReadSeeker) (string, []riffChunk, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading file size: %w", err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", nil, fmt.Errorf("error reading RIFF header: %w", err)
+	}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, fmt.Errorf("error reading RIFF header: %w", err)
+	}
+	container := string(header[0:4])
+	if (container != "RIFF" && container != "RF64" && container != "BW64") || string(header[8:12]) != "WAVE" {
+		return "", nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	var chunks []riffChunk
+	// 64 bits sizes of the ds64 chunk, by chunk id
+	sizes64 := map[string]int64{}
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	for offset+8 <= fileSize {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return "", nil, fmt.Errorf("error seeking chunk: %w", err)
+		}
+		if _, err := io.ReadFull(r, chunkHeader); err != nil {
+			return "", nil, fmt.Errorf("error reading chunk header: %w", err)
+		}
+		chunk := riffChunk{
+			ID:     string(chunkHeader[0:4]),
+			Size:   int64(binary.LittleEndian.Uint32(chunkHeader[4:8])),
+			Offset: offset + 8,
+		}
+		if size, ok := sizes64[chunk.ID]; ok && chunk.Size == 0xFFFFFFFF {
+			chunk.Size = size
+		}
+		if chunk.Offset+chunk.Size > fileSize {
+			log.Warn("Truncated chunk", "chunk", chunk.ID, "size", chunk.Size, "available", fileSize-chunk.Offset)
+			chunk.Size = fileSize - chunk.Offset
+		}
+		if chunk.ID == "ds64" && container != "RIFF" && len(chunks) == 0 {
+			data, err := readChunk(r, chunk)
+			if err != nil {
+				return "", nil, err
+			}
+			if err := parseDS64(data, sizes64); err != nil {
+				return "", nil, err
+			}
+		}
+		chunks = append(chunks, chunk)
+		offset = chunk.Offset + chunk.Size + chunk.Size%2
+	}
+	return container, chunks, nil
+}
+
+// parseDS64 reads the 64 bits sizes of a ds64 chunk : the data chunk size and the table of the
+// other large chunks. The RIFF size and the sample count are not needed.
+func parseDS64(data []byte, sizes map[string]int64) error {
+	if len(data) < 28 {
+		return fmt.Errorf("ds64 chunk too short: %d bytes", len(data))
+	}
+	sizes["data"] = int64(binary.LittleEndian.Uint64(data[8:16]) & math.MaxInt64)
+	count := int(binary.LittleEndian.Uint32(data[24:28]))
+	table := data[28:]
+	for i := 0; i < count && 12*(i+1) <= len(table); i++ {
+		entry := table[12*i : 12*(i+1)]
+		sizes[string(entry[0:4])] = int64(binary.LittleEndian.Uint64(entry[4:12]) & math.MaxInt64)
+	}
+	return nil
+}
+
+// findChunk returns the first chunk with this id.
+func findChunk(chunks []riffChunk, id string) (riffChunk, bool) {
+	for _, chunk := range chunks {
+		if chunk.ID == id {
+			return chunk, true
+		}
+	}
+	return riffChunk{}, false
+}
+
+// readChunk returns the content of a chunk.
+func readChunk(r io.ReadSeeker, chunk riffChunk) ([]byte, error) {
+	if _, err := r.Seek(chunk.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking %s chunk: %w", chunk.ID, err)
+	}
+	data := make([]byte, chunk.Size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading %s chunk: %w", chunk.ID, err)
+	}
+	return data, nil
+}
+
+// WaveInfo describes the format of a wave file, as read from its fmt and data chunks.
+type WaveInfo struct {
+	Container     string // RIFF, or RF64 and BW64 for files larger than 4 GB, FLAC, AIFF or AIFC
+	FormatCode    int    // 1 = PCM, 3 = IEEE float, 0xFFFE = extensible
+	SubFormat     int    // format of the samples : FormatCode, or the sub format of an extensible file
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	ValidBits     int    // bits really used in each sample, BitsPerSample but for extensible files
+	ChannelMask   uint32 // speaker positions of an extensible file, 0 if none
+	Compression   string // AIFF-C compression type (NONE, sowt, fl32...), NONE for AIFF
+	BigEndian     bool   // samples are big endian : AIFF but sowt, raw files with -raw-endian big
+	Frames        int64
+}
+
+// aiff tells whether the samples come from an AIFF or AIFF-C file.
+func (info WaveInfo) aiff() bool {
+	return info.Container == "AIFF" || info.Container == "AIFC"
+}
+
+// Duration returns the length of the audio data in seconds.
+func (info WaveInfo) Duration() float64 {
+	if info.SampleRate == 0 {
+		return 0
+	}
+	return float64(info.Frames) / float64(info.SampleRate)
+}
+
+// bytesPerSample is the size of one sample of one channel in the data chunk.
+func (info WaveInfo) bytesPerSample() int {
+	return (info.BitsPerSample + 7) / 8
+}
+
+// parseFmtChunk reads the fmt chunk and checks that the samples can be decoded.
+func parseFmtChunk(data []byte) (WaveInfo, error) {
+	var info WaveInfo
+	if len(data) < 16 {
+		return info, fmt.Errorf("fmt chunk too short: %d bytes", len(data))
+	}
+	info.FormatCode = int(binary.LittleEndian.Uint16(data[0:2]))
+	info.Channels = int(binary.LittleEndian.Uint16(data[2:4]))
+	info.SampleRate = int(binary.LittleEndian.Uint32(data[4:8]))
+	blockAlign := int(binary.LittleEndian.Uint16(data[12:14]))
+	info.BitsPerSample = int(binary.LittleEndian.Uint16(data[14:16]))
+	info.SubFormat = info.FormatCode
+	info.ValidBits = info.BitsPerSample
+
+	if info.FormatCode == waveFormatExtensible {
+		// cbSize, wValidBitsPerSample, dwChannelMask and the SubFormat GUID
+		if len(data) < 40 {
+			return info, fmt.Errorf("WAVE_FORMAT_EXTENSIBLE fmt chunk too short: %d bytes", len(data))
+		}
+		info.ValidBits = int(binary.LittleEndian.Uint16(data[18:20]))
+		info.ChannelMask = binary.LittleEndian.Uint32(data[20:24])
+		if !bytes.Equal(data[26:40], extensibleGUIDSuffix) {
+			return info, fmt.Errorf("unknown WAVE_FORMAT_EXTENSIBLE sub format GUID % x", data[24:40])
+		}
+		info.SubFormat = int(binary.LittleEndian.Uint16(data[24:26]))
+		if info.ValidBits == 0 || info.ValidBits > info.BitsPerSample {
+			info.ValidBits = info.BitsPerSample
+		}
+	}
+
+	if info.Channels == 0 {
+		return info, fmt.Errorf("no channels in fmt chunk")
+	}
+	if info.SampleRate == 0 {
+		return info, fmt.Errorf("sample rate is 0 in fmt chunk")
+	}
+	switch {
+	case info.SubFormat == waveFormatPCM && info.BitsPerSample >= 8 && info.BitsPerSample <= 32:
+	case info.SubFormat == waveFormatFloat && (info.BitsPerSample == 32 || info.BitsPerSample == 64):
+	default:
+		return info, fmt.Errorf("unsupported WAV format: format code %#x, %d bits per sample", info.SubFormat, info.BitsPerSample)
+	}
+	if blockAlign != info.Channels*info.bytesPerSample() {
+		return info, fmt.Errorf("block align %d does not match %d channels of %d bits", blockAlign, info.Channels, info.BitsPerSample)
+	}
+	return info, nil
+}
+
+// readWaveHeader walks the chunks of a wave file and returns its format and its data chunk.
+func readWaveHeader(r io.ReadSeeker) (WaveInfo, riffChunk, error) {
+	container, chunks, err := walkRIFF(r)
+	if err != nil {
+		return WaveInfo{}, riffChunk{}, err
+	}
+	fmtChunk, ok := findChunk(chunks, "fmt ")
+	if !ok {
+		return WaveInfo{}, riffChunk{}, fmt.Errorf("no fmt chunk found")
+	}
+	dataChunk, ok := findChunk(chunks, "data")
+	if !ok {
+		return WaveInfo{}, riffChunk{}, fmt.Errorf("no data chunk found")
+	}
+	data, err := readChunk(r, fmtChunk)
+	if err != nil {
+		return WaveInfo{}, riffChunk{}, err
+	}
+	info, err := parseFmtChunk(data)
+	if err != nil {
+		return info, riffChunk{}, err
+	}
+	info.Container = container
+	info.Frames = dataChunk.Size / int64(info.Channels*info.bytesPerSample())
+	return info, dataChunk, nil
+}
+
+// byteOrder returns the byte order of the samples.
+func (info WaveInfo) byteOrder() binary.ByteOrder {
+	if info.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// unsigned8 tells whether 8 bits samples are unsigned, as in wave files. They are signed in
+// AIFF and raw files.
+func (info WaveInfo) unsigned8() bool {
+	return !info.aiff() && info.Container != "raw"
+}
+
+// sampleDecoder returns the function converting one sample of the data chunk to a float64,
+// full scale being -1..1 (32767 for 16 bits, as written by the writers).
+func sampleDecoder(info WaveInfo) func(b []byte) float64 {
+	order := info.byteOrder()
+	switch {
+	case info.SubFormat == waveFormatFloat && info.BitsPerSample == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b))) }
+	case info.SubFormat == waveFormatFloat:
+		return func(b []byte) float64 { return math.Float64frombits(order.Uint64(b)) }
+	case info.bytesPerSample() == 1 && info.unsigned8():
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 127 }
+	}
+	// signed, the valid bits are the most significant bits of the container
+	size := info.bytesPerSample()
+	scale := 1 / float64(int64(1)<<(8*size-1)-1)
+	shift := 64 - 8*size
+	bigEndian := info.BigEndian
+	return func(b []byte) float64 {
+		var v uint64
+		for i := range size {
+			if bigEndian {
+				v = v<<8 | uint64(b[i])
+			} else {
+				v = v<<8 | uint64(b[size-1-i])
+			}
+		}
+		return float64(int64(v<<shift)>>shift) * scale
+	}
+}
+
+// sampleEncoder returns the function writing one sample of the channel c in the format of info,
+// the reverse of sampleDecoder. Integer samples are quantised by q, which has 8 bits per byte
+// of the samples.
+func sampleEncoder(info WaveInfo, q *quantizer) func(b []byte, c int, x float64) {
+	order := info.byteOrder()
+	switch {
+	case info.SubFormat == waveFormatFloat && info.BitsPerSample == 32:
+		return func(b []byte, c int, x float64) { order.PutUint32(b, math.Float32bits(float32(x))) }
+	case info.SubFormat == waveFormatFloat:
+		return func(b []byte, c int, x float64) { order.PutUint64(b, math.Float64bits(x)) }
+	case info.bytesPerSample() == 1 && info.unsigned8():
+		return func(b []byte, c int, x float64) { b[0] = byte(q.quantize(c, x) + 128) }
+	}
+	size := info.bytesPerSample()
+	bigEndian := info.BigEndian
+	return func(b []byte, c int, x float64) {
+		v := q.quantize(c, x)
+		for i := range size {
+			if bigEndian {
+				b[size-1-i] = byte(v >> (8 * i))
+			} else {
+				b[i] = byte(v >> (8 * i))
+			}
+		}
+	}
+}
+
+// decodeWave reads all the channels of a wave file, as float64 between -1 and 1.
+// A last incomplete frame is ignored.
+func decodeWave(r io.ReadSeeker) (WaveInfo, [][]float64, error) {
+	info, dataChunk, err := readWaveHeader(r)
+	if err != nil {
+		return info, nil, err
+	}
+	channels, err := decodeFrames(r, info, dataChunk.Offset)
+	return info, channels, err
+}
+
+// decodeFrames reads the info.Frames interleaved frames starting at offset.
+func decodeFrames(r io.ReadSeeker, info WaveInfo, offset int64) ([][]float64, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking audio data: %w", err)
+	}
+
+	decode := sampleDecoder(info)
+	size := info.bytesPerSample()
+	frame := make([]byte, info.Channels*size)
+	channels := make([][]float64, info.Channels)
+	for c := range channels {
+		channels[c] = make([]float64, info.Frames)
+	}
+	reader := bufio.NewReaderSize(r, 1<<16)
+	for i := int64(0); i < info.Frames; i++ {
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return nil, fmt.Errorf("error reading audio data: %w", err)
+		}
+		for c := range channels {
+			channels[c][i] = decode(frame[c*size : (c+1)*size])
+		}
+	}
+	return channels, nil
+}
+
+// readWaveInfo reads the fmt and data chunk headers of a wave file without reading the audio data.
+func readWaveInfo(s string) (WaveInfo, error) {
+	f, err := os.Open(s)
+	if err != nil {
+		return WaveInfo{}, fmt.Errorf("error opening WAV file: %w", err)
+	}
+	defer f.Close()
+
+	info, _, err := readWaveHeader(f)
+	return info, err
+}
+
+// riffLimit is the largest size of a RIFF file : above, the files are written as RF64.
+var riffLimit int64 = math.MaxUint32
+
+// waveHeader returns the header of a 16 bits PCM wave file, up to the data chunk size.
+// When the file would be larger than 4 GB, the 32 bits sizes are set to 0xFFFFFFFF and the real
+// sizes go in a ds64 chunk, with RF64 (or BW64) instead of RIFF. trailer is the size of the
+// metadata chunks written after the data chunk.
+func waveHeader(channels int, sampleRate int, frames int64, trailer int64, opts OutputOptions) []byte {
+	const bitsPerSample = 16
+	blockAlign := channels * bitsPerSample / 8
+	dataSize := frames * int64(blockAlign)
+
+	var b bytes.Buffer
+	riffSize := 36 + dataSize + trailer
+	large := 8+riffSize > riffLimit
+	if large {
+		riffSize += 36 // ds64 chunk
+		id := "RF64"
+		if opts.BW64 {
+			id = "BW64"
+		}
+		b.WriteString(id)
+		binary.Write(&b, binary.LittleEndian, uint32(0xFFFFFFFF))
+		b.WriteString("WAVE")
+		b.WriteString("ds64")
+		binary.Write(&b, binary.LittleEndian, uint32(28))
+		binary.Write(&b, binary.LittleEndian, uint64(riffSize))
+		binary.Write(&b, binary.LittleEndian, uint64(dataSize))
+		binary.Write(&b, binary.LittleEndian, uint64(frames))
+		binary.Write(&b, binary.LittleEndian, uint32(0)) // no table of other chunk sizes
+	} else {
+		b.WriteString("RIFF")
+		binary.Write(&b, binary.LittleEndian, uint32(riffSize))
+		b.WriteString("WAVE")
+	}
+
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(waveFormatPCM))
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(&b, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&b, binary.LittleEndian, uint16(bitsPerSample))
+
+	b.WriteString("data")
+	if large {
+		binary.Write(&b, binary.LittleEndian, uint32(0xFFFFFFFF))
+	} else {
+		binary.Write(&b, binary.LittleEndian, uint32(dataSize))
+	}
+	return b.Bytes()
+}
+
+// writeWave writes channels as an interleaved 16 bits PCM wave file, in the order of the slice,
+// followed by the metadata chunks.
+func writeWave(s string, sampleRate int, names []string, channels [][]float64, opts OutputOptions) error {
+	frames := len(channels[0])
+	for _, channel := range channels {
+		if len(channel) != frames {
+			return fmt.Errorf("all channels must be the same length")
+		}
+	}
+
+	outFile, err := os.Create(s)
+	if err != nil {
+		return fmt.Errorf("error creating WAV file: %w", err)
+	}
+	defer outFile.Close()
+
+	trailer := opts.Metadata.waveChunks(sampleRate, len(channels))
+	header := waveHeader(len(channels), sampleRate, int64(frames), int64(len(trailer)), opts)
+	if len(header) > 44 {
+		log.Info("Output larger than 4 GB, written as RF64/BW64", "ouput", s, "header", string(header[0:4]))
+	}
+	w := bufio.NewWriterSize(outFile, 1<<16)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("error writing WAV header: %w", err)
+	}
+
+	q := newQuantizer(len(channels), 16, opts.Dither)
+	sample := make([]byte, 2)
+	for i := 0; i < frames; i++ {
+		for c, channel := range channels {
+			binary.LittleEndian.PutUint16(sample, uint16(q.quantize(c, channel[i])))
+			if _, err := w.Write(sample); err != nil {
+				return fmt.Errorf("error writing audio data: %w", err)
+			}
+		}
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return fmt.Errorf("error writing WAV metadata: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing audio data: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("error closing WAV file: %w", err)
+	}
+	q.report(s, names)
+	return nil
+}